@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// clipboardRef is the reference attaching the image in the clipboard.
+const clipboardRef = "clipboard"
+
+// Set of file extensions an @file reference needs to be read as an image.
+var imageExts = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// refs matches the @file references of a prompt.
+var refs = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// attachments loads the images given by path and those referenced in the
+// prompt with @file or @clipboard. References to other files are left to
+// the model.
+func attachments(root string, prompt string, paths []string) ([]agent.Image, error) {
+	for _, m := range refs.FindAllStringSubmatch(prompt, -1) {
+		ref := strings.TrimRight(m[1], ".,;:!?)\"'")
+
+		if ref == clipboardRef || slices.Contains(imageExts, strings.ToLower(filepath.Ext(ref))) {
+			paths = append(paths, ref)
+		}
+	}
+
+	var images []agent.Image
+	for _, path := range paths {
+		if path == clipboardRef {
+			img, err := clipboardImage()
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, img)
+			continue
+		}
+
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+
+		img, err := agent.LoadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// clipboardImage reads the image in the clipboard with the tool of the
+// platform: pngpaste on macOS, wl-paste or xclip on Linux.
+func clipboardImage() (agent.Image, error) {
+	var commands [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		commands = [][]string{{"pngpaste", "-"}}
+	default:
+		commands = [][]string{
+			{"wl-paste", "--no-newline", "--type", "image/png"},
+			{"xclip", "-selection", "clipboard", "-target", "image/png", "-out"},
+		}
+	}
+
+	var errs []error
+	for _, args := range commands {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", args[0], strings.TrimSpace(stderr.String())))
+			continue
+		}
+
+		return agent.NewImage(clipboardRef, stdout.Bytes())
+	}
+
+	if len(errs) == 0 {
+		names := make([]string, len(commands))
+		for i, args := range commands {
+			names[i] = args[0]
+		}
+		return agent.Image{}, fmt.Errorf("clipboard: install %s to paste images", strings.Join(names, " or "))
+	}
+
+	return agent.Image{}, fmt.Errorf("clipboard: no image: %w", errors.Join(errs...))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/config"
+	"slices"
+	"strings"
+)
+
+// configCommand runs the config subcommands against the loaded config.
+func configCommand(cfg config.Config, tools []string, args []string) error {
+	if len(args) == 0 {
+		return &exitCodeError{code: exitUsage, err: fmt.Errorf("config: missing subcommand, use validate or show")}
+	}
+
+	switch args[0] {
+	case "validate":
+		if err := cfg.Validate(tools); err != nil {
+			return &exitCodeError{code: exitError, err: fmt.Errorf("config is invalid:\n%w", err)}
+		}
+
+		fmt.Printf("config is valid, endpoint %s serving %s\n", cfg.Endpoint, cfg.Active().Model)
+		for _, src := range cfg.Sources {
+			fmt.Printf("  read %s\n", src)
+		}
+		for _, key := range cfg.Ignored {
+			fmt.Printf("  ignored %s, the project isn't trusted\n", key)
+		}
+
+		return nil
+
+	case "show":
+		fmt.Printf("# sources: %s\n", strings.Join(append([]string{"defaults"}, cfg.Sources...), ", "))
+		if cfg.Profile != "" {
+			fmt.Printf("# profile: %s\n", cfg.Profile)
+		}
+
+		// The profiles were already applied and only add noise.
+		cfg.Profiles = nil
+
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		fmt.Println(string(data))
+
+		return nil
+	}
+
+	return &exitCodeError{code: exitUsage, err: fmt.Errorf("config: unknown subcommand %q, use validate or show", args[0])}
+}
+
+// =============================================================================
+
+func toolNames(tools []agent.Tool) []string {
+	names := make([]string, len(tools))
+	for i, t := range tools {
+		names[i] = t.Name
+	}
+
+	return names
+}
+
+// enabledTools keeps the tools named in the config, all of them when it
+// names none.
+func enabledTools(tools []agent.Tool, enabled []string) []agent.Tool {
+	if len(enabled) == 0 {
+		return tools
+	}
+
+	return slices.DeleteFunc(slices.Clone(tools), func(t agent.Tool) bool {
+		return !slices.Contains(enabled, t.Name)
+	})
+}
+
+// policy enforces the permissions of the config. Tools that need approval
+// are denied when there is nobody to ask, unless a hook approved the call.
+// A hook can't run a tool the config denies.
+func policy(perms config.Permissions, approvals *agent.Approvals) agent.Policy {
+	return func(ctx context.Context, call client.ToolCall) error {
+		switch perms.For(call.Function.Name) {
+		case config.PermissionDeny:
+			return fmt.Errorf("%w: %s is not allowed by the permission policy", agent.ErrDenied, call.Function.Name)
+
+		case config.PermissionAsk:
+			if agent.HookApproved(ctx) {
+				return nil
+			}
+			if approvals == nil {
+				return fmt.Errorf("%w: %s needs the user's approval", agent.ErrDenied, call.Function.Name)
+			}
+			return approvals.Check(ctx, call)
+		}
+
+		return nil
+	}
+}
+
+// commandHooks runs the hooks of the config as commands in dir.
+func commandHooks(hooks []config.Hook, dir string) []agent.Hook {
+	list := make([]agent.Hook, len(hooks))
+	for i, h := range hooks {
+		list[i] = agent.ForTools(agent.CommandHook(h.Command, dir), h.Tools...)
+	}
+
+	return list
+}
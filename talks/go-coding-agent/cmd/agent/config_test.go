@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// hookApproved returns the context the agent runs the policy with once a
+// pre-tool hook approved the call, taken from a turn where the model calls
+// a tool.
+func hookApproved(t *testing.T) context.Context {
+	t.Helper()
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		chunk := `{"choices":[{"index":0,"delta":{"content":"done"},"finish_reason":"stop"}]}`
+		if requests.Add(1) == 1 {
+			chunk = `{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"probe","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+	}))
+	t.Cleanup(srv.Close)
+
+	var approved context.Context
+
+	a := agent.New(client.NewLLM(srv.URL+"/v1/chat/completions", "test", client.WithClientOptions(client.WithSlog(nil))),
+		agent.WithTools(agent.Tool{
+			Name: "probe",
+			Run:  func(ctx context.Context, args map[string]any) (string, error) { return "ok", nil },
+		}),
+		agent.WithPreToolHooks(func(ctx context.Context, in agent.HookInput) (agent.HookResult, error) {
+			return agent.HookResult{Decision: agent.DecisionApprove}, nil
+		}),
+		agent.WithPolicy(func(ctx context.Context, call client.ToolCall) error {
+			approved = ctx
+			return nil
+		}),
+	)
+
+	if err := a.Turn(context.Background(), "probe", func(agent.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if approved == nil || !agent.HookApproved(approved) {
+		t.Fatal("the policy wasn't told the hook approved the call")
+	}
+
+	return approved
+}
+
+func TestPolicy(t *testing.T) {
+	perms := config.Permissions{
+		Default: config.PermissionAllow,
+		Tools: map[string]string{
+			"run_command": config.PermissionDeny,
+			"write_file":  config.PermissionAsk,
+		},
+	}
+
+	var asked int
+	approvals := agent.NewApprovals(func(ctx context.Context, call client.ToolCall) (agent.Answer, string, error) {
+		asked++
+		return agent.Deny, "", nil
+	})
+
+	call := func(name string) client.ToolCall {
+		return client.ToolCall{Function: client.Function{Name: name}}
+	}
+
+	approved := hookApproved(t)
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		approvals *agent.Approvals
+		tool      string
+		denied    bool
+		asked     int
+	}{
+		{"allow", context.Background(), approvals, "read_file", false, 0},
+		{"deny", context.Background(), approvals, "run_command", true, 0},
+		{"deny approved by a hook", approved, approvals, "run_command", true, 0},
+		{"ask", context.Background(), approvals, "write_file", true, 1},
+		{"ask approved by a hook", approved, approvals, "write_file", false, 0},
+		{"ask without anyone to ask", context.Background(), nil, "write_file", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked = 0
+
+			err := policy(perms, tt.approvals)(tt.ctx, call(tt.tool))
+			if denied := errors.Is(err, agent.ErrDenied); denied != tt.denied {
+				t.Errorf("got %v, want denied %t", err, tt.denied)
+			}
+
+			if asked != tt.asked {
+				t.Errorf("asked %d times, want %d", asked, tt.asked)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Set of exit codes of the headless mode, so scripts can tell why a run
+// stopped without parsing the output.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitMaxSteps    = 3 // or the token budget
+	exitTruncated   = 4
+	exitTimeout     = 124
+	exitInterrupted = 130
+)
+
+// Set of output formats of the headless mode.
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+)
+
+// exitCodeError makes the program exit with the code after printing the
+// error, when there is one.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// =============================================================================
+
+// record is a single line of the JSON event stream.
+type record struct {
+	Type       string         `json:"type"`
+	Model      string         `json:"model,omitempty"`
+	Role       string         `json:"role,omitempty"`
+	Content    string         `json:"content,omitempty"`
+	Images     []string       `json:"images,omitempty"`
+	Reasoning  string         `json:"reasoning,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Output     string         `json:"output,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Usage      *client.Usage  `json:"usage,omitempty"`
+	ExitReason string         `json:"exit_reason,omitempty"`
+	ExitCode   *int           `json:"exit_code,omitempty"`
+	DurationMS int64          `json:"duration_ms,omitempty"`
+	ToolCalls  int            `json:"tool_calls,omitempty"`
+	Events     []record       `json:"events,omitempty"`
+}
+
+// Set of plan modes of the headless mode.
+const (
+	planNone = ""
+	planRun  = "run"
+	planOnly = "only"
+)
+
+// approveMessage tells the model the plan it wrote can be carried out.
+const approveMessage = "The plan is approved. Carry it out step by step."
+
+// headless runs a single prompt through the full tool loop without any
+// interaction and writes the outcome to stdout in the requested format. In
+// plan mode the model first writes a plan, which is carried out right away
+// unless the plan is all that was asked for.
+func headless(ctx context.Context, a *agent.Agent, plan *agent.Plan, planMode string, prompt string, images []agent.Image, output string, stdout io.Writer, stderr io.Writer) error {
+	var (
+		start     = time.Now()
+		content   strings.Builder
+		reasoning strings.Builder
+		final     string
+		toolCalls int
+		events    []record
+		usage     *client.Usage
+		reason    string
+		turnErr   error
+	)
+
+	enc := json.NewEncoder(stdout)
+
+	write := func(r record) {
+		switch output {
+		case outputJSONL:
+			enc.Encode(r)
+		case outputJSON:
+			events = append(events, r)
+		}
+	}
+
+	// The tokens of a message are buffered so the event stream carries whole
+	// messages.
+	flush := func() {
+		if content.Len() == 0 && reasoning.Len() == 0 {
+			return
+		}
+
+		final = content.String()
+		write(record{
+			Type:      "message",
+			Role:      agent.RoleAssistant,
+			Content:   content.String(),
+			Reasoning: reasoning.String(),
+		})
+
+		content.Reset()
+		reasoning.Reset()
+	}
+
+	begin := record{Type: "start", Model: a.Model(), Content: prompt}
+	for _, img := range images {
+		begin.Images = append(begin.Images, img.Name)
+	}
+	write(begin)
+
+	emit := func(e agent.Event) {
+		switch e.Kind {
+		case agent.EventReasoning:
+			reasoning.WriteString(e.Text)
+
+		case agent.EventContent:
+			content.WriteString(e.Text)
+			if output == outputText {
+				fmt.Fprint(stdout, e.Text)
+			}
+
+		case agent.EventToolCall:
+			flush()
+			final = ""
+			toolCalls++
+
+			args, _ := json.Marshal(e.ToolCall.Function.Arguments)
+			if output == outputText {
+				fmt.Fprintf(stderr, "\n-> %s(%s)\n", e.ToolCall.Function.Name, args)
+			}
+
+			write(record{
+				Type:       "tool_call",
+				ToolCallID: e.ToolCall.ID,
+				Name:       e.ToolCall.Function.Name,
+				Arguments:  e.ToolCall.Function.Arguments,
+			})
+
+		case agent.EventToolResult:
+			r := record{
+				Type:       "tool_result",
+				ToolCallID: e.ToolCall.ID,
+				Name:       e.ToolCall.Function.Name,
+				Output:     e.Text,
+			}
+			if e.Err != nil {
+				r.Error = e.Err.Error()
+				if output == outputText {
+					fmt.Fprintf(stderr, "   %s failed: %s\n", e.ToolCall.Function.Name, e.Err)
+				}
+			}
+			write(r)
+
+		case agent.EventWarning:
+			if output == outputText {
+				fmt.Fprintf(stderr, "warning: %s\n", e.Err)
+			}
+			write(record{Type: "warning", Error: e.Err.Error()})
+
+		case agent.EventDone, agent.EventError:
+			flush()
+			if e.Usage != nil {
+				if usage == nil {
+					usage = &client.Usage{}
+				}
+				usage.PromptTokens += e.Usage.PromptTokens
+				usage.CompletionTokens += e.Usage.CompletionTokens
+				usage.TotalTokens += e.Usage.TotalTokens
+			}
+			reason = e.Reason
+			turnErr = e.Err
+		}
+	}
+
+	if planMode == planNone {
+		a.TurnWithImages(ctx, prompt, images, emit)
+	} else {
+		// A plan left from an earlier run would pass for the model's.
+		if err := plan.Clear(); err != nil {
+			return err
+		}
+
+		a.SetPlanning(true)
+		a.TurnWithImages(ctx, prompt, images, emit)
+		a.SetPlanning(false)
+
+		steps := plan.Render()
+		write(record{Type: "plan", Content: steps})
+
+		switch {
+		case turnErr != nil:
+		case steps == "":
+			turnErr = errors.New("the model didn't write a plan")
+		case planMode == planOnly:
+			// The plan is the answer.
+			final = steps
+			if output == outputText {
+				fmt.Fprintf(stdout, "\n\n%s", steps)
+			}
+		default:
+			if output == outputText {
+				fmt.Fprintf(stderr, "\nPlan:\n%s\n", steps)
+			}
+			plan.Approve()
+			a.Turn(ctx, approveMessage, emit)
+		}
+	}
+
+	code, exitReason := exitStatus(ctx, reason, turnErr)
+
+	result := record{
+		Type:       "result",
+		Content:    final,
+		Usage:      usage,
+		ExitReason: exitReason,
+		ExitCode:   &code,
+		DurationMS: time.Since(start).Milliseconds(),
+		ToolCalls:  toolCalls,
+	}
+	if turnErr != nil {
+		result.Error = turnErr.Error()
+	}
+
+	switch output {
+	case outputText:
+		if final != "" && !strings.HasSuffix(final, "\n") {
+			fmt.Fprintln(stdout)
+		}
+
+	case outputJSONL:
+		enc.Encode(result)
+
+	case outputJSON:
+		result.Events = events
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	}
+
+	if code == exitOK {
+		return nil
+	}
+
+	// The JSON formats already carry the error.
+	if output != outputText {
+		return &exitCodeError{code: code}
+	}
+
+	return &exitCodeError{code: code, err: turnErr}
+}
+
+// exitStatus maps the way the turn ended to an exit code and a reason.
+func exitStatus(ctx context.Context, reason string, err error) (int, string) {
+	switch {
+	case err == nil && reason == "length":
+		return exitTruncated, "length"
+
+	case err == nil:
+		return exitOK, "stop"
+
+	case errors.Is(err, agent.ErrMaxSteps):
+		return exitMaxSteps, "max_steps"
+
+	case errors.Is(err, agent.ErrTokenBudget):
+		return exitMaxSteps, "token_budget"
+
+	case errors.Is(err, agent.ErrNoVision):
+		return exitUsage, "no_vision"
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout, "timeout"
+
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		return exitInterrupted, "interrupted"
+	}
+
+	return exitError, "error"
+}
+
+// readPrompt appends whatever is piped on stdin to the prompt, so files and
+// command output can be handed to the agent.
+func readPrompt(prompt string, stdin *os.File) (string, error) {
+	info, err := stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return prompt, nil
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("read stdin: %w", err)
+	}
+
+	extra := strings.TrimSpace(string(data))
+	switch {
+	case extra == "":
+		return prompt, nil
+	case prompt == "":
+		return extra, nil
+	}
+
+	return prompt + "\n\n" + extra, nil
+}
@@ -0,0 +1,348 @@
+// This program runs the coding agent with an interactive terminal UI, or
+// headless with -p for scripts and CI.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/config"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func main() {
+	err := run()
+
+	var ec *exitCodeError
+	if errors.As(err, &ec) {
+		if ec.err != nil {
+			log.Print(ec.err)
+		}
+		os.Exit(ec.code)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	profile := flag.String("profile", "", "config profile to use")
+	configFile := flag.String("config", "", "user config file, instead of the one in the user config directory")
+	endpoint := flag.String("endpoint", "", "config endpoint to use")
+	server := flag.String("server", "", "chat completions endpoint")
+	model := flag.String("model", "", "model to chat with")
+	reasoning := flag.String("reasoning", "", "how to show reasoning: hidden, collapsed or full")
+	keepReasoning := flag.Bool("keep-reasoning", false, "send reasoning back to the model with the history")
+	logFile := flag.String("log", "", "write client logs to this file")
+	prompt := flag.String("p", "", "run this prompt headless, with any input piped on stdin appended, and exit")
+	output := flag.String("output", outputText, "headless output format: text, json or jsonl")
+	maxSteps := flag.Int("max-steps", 0, "maximum number of requests per turn while the model calls tools")
+	timeout := flag.Duration("timeout", 0, "headless time limit, none when zero")
+	system := flag.String("system", "", "system prompt for this session, replacing the generated one")
+	systemFile := flag.String("system-file", "", "read the system prompt for this session from the file")
+	planMode := flag.Bool("plan", false, "start in planning mode, headless the plan is carried out right away")
+	planOnlyMode := flag.Bool("plan-only", false, "headless, stop once the plan is written")
+	var imagePaths []string
+	flag.Func("image", "attach the image file, or clipboard, to the first prompt; can be repeated", func(path string) error {
+		imagePaths = append(imagePaths, path)
+		return nil
+	})
+	flag.Usage = usage
+	flag.Parse()
+
+	root, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("working directory: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+	// The flags are the last layer of the config, applied only when set.
+
+	cfg, err := config.Load(config.Options{
+		Profile:  *profile,
+		UserFile: *configFile,
+		Dir:      root,
+	})
+	if err != nil {
+		return &exitCodeError{code: exitUsage, err: fmt.Errorf("config: %w", err)}
+	}
+
+	if isFlagSet("endpoint") {
+		cfg.Endpoint = *endpoint
+	}
+	if isFlagSet("server") {
+		cfg.SetURL(*server)
+	}
+	if isFlagSet("model") {
+		cfg.SetModel(*model)
+	}
+	if isFlagSet("reasoning") {
+		cfg.Reasoning = *reasoning
+	}
+	if isFlagSet("max-steps") {
+		cfg.MaxSteps = *maxSteps
+	}
+
+	cp := agent.NewCheckpoints(root)
+	tools := slices.Concat(agent.CodingTools(root, cp), agent.GitTools(root), agent.GoTools(root))
+
+	known := append(toolNames(tools), agent.DelegateToolName, agent.PlanToolName, agent.RememberToolName, agent.RecallToolName)
+
+	if len(cfg.Ignored) > 0 {
+		log.Printf("config: the project isn't trusted, ignored %s; add it to trusted_projects in the user config to use them", strings.Join(cfg.Ignored, ", "))
+	}
+
+	if flag.Arg(0) == "config" {
+		return configCommand(cfg, known, flag.Args()[1:])
+	}
+
+	if err := cfg.Validate(known); err != nil {
+		return &exitCodeError{code: exitUsage, err: fmt.Errorf("config:\n%w", err)}
+	}
+
+	// -------------------------------------------------------------------------
+
+	headlessMode := isFlagSet("p")
+
+	display, err := agent.ParseReasoningDisplay(cfg.Reasoning)
+	if err != nil {
+		return &exitCodeError{code: exitUsage, err: err}
+	}
+
+	switch *output {
+	case outputText, outputJSON, outputJSONL:
+	default:
+		return &exitCodeError{code: exitUsage, err: fmt.Errorf("unknown output format %q, use text, json or jsonl", *output)}
+	}
+
+	input := *prompt
+	if headlessMode {
+		if input, err = readPrompt(input, os.Stdin); err != nil {
+			return &exitCodeError{code: exitUsage, err: err}
+		}
+
+		if input == "" {
+			return &exitCodeError{code: exitUsage, err: errors.New("empty prompt, pass it with -p or on stdin")}
+		}
+	}
+
+	images, err := attachments(root, input, imagePaths)
+	if err != nil {
+		return &exitCodeError{code: exitUsage, err: err}
+	}
+
+	// The terminal belongs to the UI so logs can only go to a file.
+	var logger *slog.Logger
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open log: %w", err)
+		}
+		defer f.Close()
+
+		logger = slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	ep := cfg.Active()
+
+	clientOptions := []func(cln *client.Client){client.WithSlog(logger)}
+	if key := ep.APIKey(); key != "" {
+		clientOptions = append(clientOptions, client.WithMiddleware(client.Header("Authorization", "Bearer "+key)))
+	}
+
+	newLLM := func(model string) *client.LLM {
+		return client.NewLLM(ep.URL, model, client.WithClientOptions(clientOptions...))
+	}
+
+	llm := newLLM(ep.Model)
+
+	// The memories are shared by every session, the memory subcommand
+	// manages them even when the agent doesn't use them.
+	var memories *agent.Memories
+	var embed *client.LLM
+	if cfg.Memory.Enabled || flag.Arg(0) == "memory" {
+		if memories, embed, err = openMemories(cfg, clientOptions); err != nil {
+			return err
+		}
+	}
+
+	if flag.Arg(0) == "memory" {
+		return memoryCommand(context.Background(), memories, flag.Args()[1:])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := llm.Require(ctx, client.CapabilityCompletion); err != nil {
+		return fmt.Errorf("model %q: %w", ep.Model, err)
+	}
+
+	if cfg.Memory.Enabled {
+		if err := embed.Require(ctx, client.CapabilityEmbedding); err != nil {
+			return fmt.Errorf("memory: model %q: %w", cfg.Memory.EmbedModel, err)
+		}
+	}
+
+	params := []client.Option{
+		client.WithParams(cfg.Sampling.Temperature, cfg.Sampling.TopP, cfg.Sampling.TopK),
+	}
+	if cfg.Sampling.MaxTokens > 0 {
+		params = append(params, client.WithMaxTokens(cfg.Sampling.MaxTokens))
+	}
+	enabled := enabledTools(tools, cfg.Tools)
+
+	delegation := len(cfg.Tools) == 0 || slices.Contains(cfg.Tools, agent.DelegateToolName)
+
+	env, err := agent.LoadEnvironment(ctx, root, enabled)
+	if err != nil {
+		return fmt.Errorf("environment: %w", err)
+	}
+
+	if delegation {
+		env.Tools = append(env.Tools, agent.DelegateToolName)
+	}
+	if cfg.Memory.Enabled {
+		env.Tools = append(env.Tools, agent.RememberToolName, agent.RecallToolName)
+	}
+	slices.Sort(env.Tools)
+
+	generated, err := agent.BuildSystemPrompt(cfg.SystemPrompt, env)
+	if err != nil {
+		return &exitCodeError{code: exitUsage, err: fmt.Errorf("system prompt: %w", err)}
+	}
+
+	systemPrompt := generated
+	switch {
+	case *systemFile != "":
+		data, err := os.ReadFile(*systemFile)
+		if err != nil {
+			return &exitCodeError{code: exitUsage, err: fmt.Errorf("system prompt: %w", err)}
+		}
+		systemPrompt = string(data)
+
+	case isFlagSet("system"):
+		systemPrompt = *system
+	}
+
+	// The plan is kept in the user's cache, not in the repository, so a
+	// long task can be picked up again.
+	planFile, err := planPath(root)
+	if err != nil {
+		return err
+	}
+
+	plan, err := agent.NewPlan(planFile)
+	if err != nil {
+		return err
+	}
+
+	// Only the terminal UI can ask the user, the asker hands the requests
+	// over to it.
+	var approvals *agent.Approvals
+	requests := make(chan approvalRequest)
+	if !headlessMode {
+		approvals = agent.NewApprovals(asker(requests))
+	}
+
+	options := []func(a *agent.Agent){
+		agent.WithLLMOptions(params...),
+		agent.WithKeepReasoning(*keepReasoning),
+		agent.WithSystemPrompt(systemPrompt),
+		agent.WithTools(enabled...),
+		agent.WithMaxSteps(cfg.MaxSteps),
+		agent.WithPolicy(policy(cfg.Permissions, approvals)),
+		agent.WithPreToolHooks(commandHooks(cfg.Hooks.PreTool, root)...),
+		agent.WithPostToolHooks(commandHooks(cfg.Hooks.PostTool, root)...),
+		agent.WithCheckpoints(cp),
+		agent.WithPlan(plan),
+	}
+
+	if delegation {
+		options = append(options, agent.WithDelegation(agent.DelegateConfig{
+			MaxTokens:   cfg.Delegate.MaxTokens,
+			MaxSteps:    cfg.Delegate.MaxSteps,
+			MaxParallel: cfg.Delegate.MaxParallel,
+		}))
+	}
+
+	if cfg.Memory.Enabled {
+		options = append(options, agent.WithMemory(memories))
+	}
+
+	a := agent.New(llm, options...)
+
+	if headlessMode {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+
+		mode := planNone
+		switch {
+		case *planOnlyMode:
+			mode = planOnly
+		case *planMode:
+			mode = planRun
+		}
+
+		return headless(ctx, a, plan, mode, input, images, *output, os.Stdout, os.Stderr)
+	}
+
+	a.SetPlanning(*planMode)
+
+	p := tea.NewProgram(newUI(a, root, images, cp, plan, approvals, requests, newLLM, display, generated), tea.WithAltScreen(), tea.WithMouseCellMotion())
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("ui: %w", err)
+	}
+
+	return nil
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage:\n  %[1]s [flags]\n  %[1]s [flags] -p prompt\n  %[1]s [flags] config validate|show\n  %[1]s [flags] memory list|edit id [text]|delete id...\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// planPath returns the file the plan of the project in root is kept in.
+func planPath(root string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("plan: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(root))
+
+	return filepath.Join(dir, "go-coding-agent", "plans", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// openMemories loads the memories, embedded by the model of the config which
+// is returned so the caller can check it's available.
+func openMemories(cfg config.Config, clientOptions []func(cln *client.Client)) (*agent.Memories, *client.LLM, error) {
+	path, err := memoryPath(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	embed := client.NewLLM(embeddingsURL(cfg), cfg.Memory.EmbedModel, client.WithClientOptions(clientOptions...))
+
+	memories, err := agent.NewMemories(path, embed.EmbedText)
+	if err != nil {
+		return nil, nil, err
+	}
+	memories.SetRecall(cfg.Memory.Recall)
+
+	return memories, embed, nil
+}
+
+// memoryPath returns where the memories are kept, in the user's config
+// directory unless the config says otherwise.
+func memoryPath(cfg config.Config) (string, error) {
+	if cfg.Memory.Path != "" {
+		return cfg.Memory.Path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("memory: %w", err)
+	}
+
+	return filepath.Join(dir, "go-coding-agent", "memory.json"), nil
+}
+
+// embeddingsURL returns the URL of the embedding endpoint, next to the chat
+// completions endpoint unless the config sets it.
+func embeddingsURL(cfg config.Config) string {
+	if cfg.Memory.EmbedURL != "" {
+		return cfg.Memory.EmbedURL
+	}
+
+	u := cfg.Active().URL
+	if base, found := strings.CutSuffix(u, "/chat/completions"); found {
+		return base + "/embeddings"
+	}
+
+	return u
+}
+
+// =============================================================================
+
+// memoryCommand runs the memory subcommands against the stored memories.
+func memoryCommand(ctx context.Context, memories *agent.Memories, args []string) error {
+	if len(args) == 0 {
+		return &exitCodeError{code: exitUsage, err: errors.New("memory: missing subcommand, use list, edit or delete")}
+	}
+
+	switch args[0] {
+	case "list":
+		list := memories.List()
+		if len(list) == 0 {
+			fmt.Println("no memories saved yet")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUPDATED\tMEMORY")
+		for _, m := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.ID, m.Updated.Format(time.DateTime), m.Text)
+		}
+
+		return w.Flush()
+
+	case "edit":
+		if len(args) < 2 {
+			return &exitCodeError{code: exitUsage, err: errors.New("memory edit: missing id, use memory edit id [text]")}
+		}
+
+		id, err := memoryID(args[1])
+		if err != nil {
+			return err
+		}
+
+		text := strings.Join(args[2:], " ")
+		if text == "" {
+			var current string
+			for _, m := range memories.List() {
+				if m.ID == id {
+					current = m.Text
+				}
+			}
+
+			if current == "" {
+				return &exitCodeError{code: exitError, err: fmt.Errorf("memory edit: %w: %d", agent.ErrNoMemory, id)}
+			}
+
+			if text, err = editText(current); err != nil {
+				return fmt.Errorf("memory edit: %w", err)
+			}
+
+			if strings.TrimSpace(text) == strings.TrimSpace(current) {
+				fmt.Printf("memory %d unchanged\n", id)
+				return nil
+			}
+		}
+
+		if _, err := memories.Edit(ctx, id, text); err != nil {
+			return &exitCodeError{code: exitError, err: fmt.Errorf("memory edit: %w", err)}
+		}
+
+		fmt.Printf("memory %d updated\n", id)
+
+		return nil
+
+	case "delete":
+		if len(args) < 2 {
+			return &exitCodeError{code: exitUsage, err: errors.New("memory delete: missing id, use memory delete id...")}
+		}
+
+		for _, arg := range args[1:] {
+			id, err := memoryID(arg)
+			if err != nil {
+				return err
+			}
+
+			if err := memories.Delete(id); err != nil {
+				return &exitCodeError{code: exitError, err: fmt.Errorf("memory delete: %w", err)}
+			}
+
+			fmt.Printf("memory %d deleted\n", id)
+		}
+
+		return nil
+	}
+
+	return &exitCodeError{code: exitUsage, err: fmt.Errorf("memory: unknown subcommand %q, use list, edit or delete", args[0])}
+}
+
+func memoryID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, &exitCodeError{code: exitUsage, err: fmt.Errorf("memory: %q is not a memory id, see memory list", arg)}
+	}
+
+	return id, nil
+}
+
+// editText opens the text in $EDITOR, vi when it isn't set, and returns it
+// as saved.
+func editText(text string) (string, error) {
+	f, err := os.CreateTemp("", "memory-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(text + "\n"); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may come with flags, like "code --wait".
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/agent"
+	"go-coding-agent/pkg/client"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	styleUser      = lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true)
+	styleModel     = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Bold(true)
+	styleReasoning = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+	styleInfo      = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	styleError     = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	styleStatus    = lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Background(lipgloss.Color("236")).Padding(0, 1)
+	styleInput     = lipgloss.NewStyle().BorderStyle(lipgloss.NormalBorder()).BorderTop(true).BorderForeground(lipgloss.Color("240"))
+)
+
+const placeholder = "Ask the agent, or /help"
+
+const helpText = `Enter sends the message, Alt+Enter or Ctrl+J adds a new line.
+@file.png or @clipboard in a message attaches the image.
+Esc cancels the answer in progress, PgUp/PgDn scroll, Ctrl+C quits.
+
+/clear           start a new conversation
+/model <name>    switch to another model
+/save [path]     save the transcript, .json saves the raw conversation
+/system [text]   show or replace the system prompt, /system reset restores it
+/diff            show the changes the agent made to the files
+/undo [n]        revert the last n file edits, 1 by default
+/restore <turn>  put the files back the way they were before the turn
+/checkpoints     list the file edits recorded this session
+/plan [on|off]   show the plan, or switch planning mode
+/plan edit       edit the plan in the input box, /plan clear drops it
+/approve         leave planning mode and carry out the plan
+/image <path>    attach an image, or the clipboard, to the next message
+/allowed [reset] list the tools allowed for the session, or forget them
+/help            show this help
+/quit            exit`
+
+// =============================================================================
+
+type entryKind int
+
+const (
+	entryUser entryKind = iota
+	entryAssistant
+	entryInfo
+	entryError
+)
+
+type entry struct {
+	kind      entryKind
+	model     string
+	text      strings.Builder
+	reasoning strings.Builder
+	started   time.Time
+	thought   time.Duration
+	done      bool
+
+	// The markdown rendering is cached since glamour is too slow to run on
+	// every frame.
+	rendered string
+	width    int
+}
+
+type eventMsg agent.Event
+
+type turnDoneMsg struct{}
+
+// approvalRequest is a tool call waiting for the user, who answers on reply.
+type approvalRequest struct {
+	call  client.ToolCall
+	reply chan approvalReply
+}
+
+type approvalReply struct {
+	answer agent.Answer
+	reason string
+}
+
+type approvalMsg approvalRequest
+
+// asker hands the approval requests of the agent over to the UI and waits
+// for the user's answer.
+func asker(requests chan<- approvalRequest) agent.Asker {
+	return func(ctx context.Context, call client.ToolCall) (agent.Answer, string, error) {
+		req := approvalRequest{
+			call:  call,
+			reply: make(chan approvalReply, 1),
+		}
+
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		}
+
+		select {
+		case r := <-req.reply:
+			return r.answer, r.reason, nil
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		}
+	}
+}
+
+type infoMsg struct {
+	text string
+	err  error
+}
+
+// =============================================================================
+
+type ui struct {
+	agent   *agent.Agent
+	root    string
+	cp      *agent.Checkpoints
+	plan    *agent.Plan
+	newLLM  func(model string) *client.LLM
+	display agent.ReasoningDisplay
+	system  string
+
+	approvals *agent.Approvals
+	requests  chan approvalRequest
+	pending   []approvalRequest
+
+	// denying is set while the reason of a denial is typed, draft holding
+	// what was in the input box before.
+	denying bool
+	draft   string
+
+	// attached are the images sent with the next message.
+	attached []agent.Image
+
+	viewport viewport.Model
+	input    textarea.Model
+	renderer *glamour.TermRenderer
+	width    int
+	ready    bool
+
+	entries   []*entry
+	current   *entry
+	streaming bool
+	cancel    context.CancelFunc
+	events    chan agent.Event
+}
+
+func newUI(a *agent.Agent, root string, attached []agent.Image, cp *agent.Checkpoints, plan *agent.Plan, approvals *agent.Approvals, requests chan approvalRequest, newLLM func(model string) *client.LLM, display agent.ReasoningDisplay, system string) *ui {
+	input := textarea.New()
+	input.Placeholder = placeholder
+	input.ShowLineNumbers = false
+	input.Prompt = "> "
+	input.SetHeight(3)
+	input.CharLimit = 0
+	input.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	input.Focus()
+
+	u := ui{
+		agent:     a,
+		root:      root,
+		attached:  attached,
+		cp:        cp,
+		plan:      plan,
+		newLLM:    newLLM,
+		display:   display,
+		system:    system,
+		approvals: approvals,
+		requests:  requests,
+		input:     input,
+	}
+
+	u.info("Chatting with %s. Type /help for the commands.", a.Model())
+	for _, img := range attached {
+		u.info("attached %s", img.Name)
+	}
+	if a.Planning() {
+		u.info("Planning mode is on, the agent will write a plan for you to review before changing anything.")
+	}
+	if len(plan.Steps()) > 0 {
+		u.info("There is a plan left from an earlier session, /plan shows it, /approve carries it on and /plan clear drops it.")
+	}
+
+	return &u
+}
+
+func (u *ui) Init() tea.Cmd {
+	return textarea.Blink
+}
+
+func (u *ui) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		u.resize(msg.Width, msg.Height)
+
+	case tea.KeyMsg:
+		if len(u.pending) > 0 {
+			if cmd, handled := u.answer(msg); handled {
+				u.refresh()
+				return u, cmd
+			}
+		}
+
+		switch msg.String() {
+		case "ctrl+c":
+			if u.cancel != nil {
+				u.cancel()
+			}
+			return u, tea.Quit
+
+		case "esc":
+			if u.streaming && u.cancel != nil {
+				u.cancel()
+			}
+			return u, nil
+
+		case "enter":
+			if u.streaming {
+				return u, nil
+			}
+			cmd := u.submit()
+			u.refresh()
+			return u, cmd
+
+		case "pgup", "pgdown", "ctrl+u", "ctrl+d":
+			var cmd tea.Cmd
+			u.viewport, cmd = u.viewport.Update(msg)
+			return u, cmd
+		}
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		u.viewport, cmd = u.viewport.Update(msg)
+		return u, cmd
+
+	case eventMsg:
+		u.handle(agent.Event(msg))
+		u.refresh()
+		return u, u.waitForEvent()
+
+	case approvalMsg:
+		u.pending = append(u.pending, approvalRequest(msg))
+		if len(u.pending) == 1 {
+			u.ask()
+		}
+		u.refresh()
+		return u, u.waitForEvent()
+
+	case turnDoneMsg:
+		u.streaming = false
+		u.cancel = nil
+		u.current = nil
+		u.pending = nil
+		if u.denying {
+			u.endDenying()
+		}
+		u.input.Placeholder = placeholder
+		if u.agent.Planning() {
+			u.showPlan()
+			u.info("Review the plan, then /approve to carry it out or /plan edit to change it.")
+		}
+		u.refresh()
+		return u, nil
+
+	case infoMsg:
+		if msg.err != nil {
+			u.error(msg.err)
+		} else {
+			u.info("%s", msg.text)
+		}
+		u.refresh()
+		return u, nil
+	}
+
+	var cmd tea.Cmd
+	u.input, cmd = u.input.Update(msg)
+	cmds = append(cmds, cmd)
+
+	return u, tea.Batch(cmds...)
+}
+
+func (u *ui) View() string {
+	if !u.ready {
+		return "starting..."
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		u.viewport.View(),
+		u.statusBar(),
+		styleInput.Width(u.width).Render(u.input.View()),
+	)
+}
+
+// =============================================================================
+
+func (u *ui) resize(width int, height int) {
+	u.width = width
+
+	inputHeight := u.input.Height() + 1
+	statusHeight := 1
+	vpHeight := max(height-inputHeight-statusHeight, 3)
+
+	if !u.ready {
+		u.viewport = viewport.New(width, vpHeight)
+		u.viewport.MouseWheelEnabled = true
+		u.ready = true
+	} else {
+		u.viewport.Width = width
+		u.viewport.Height = vpHeight
+	}
+
+	u.input.SetWidth(width)
+
+	// Word wrap leaves room for the margins glamour adds.
+	r, err := glamour.NewTermRenderer(
+		glamour.WithStandardStyle("dark"),
+		glamour.WithWordWrap(max(width-4, 20)),
+	)
+	if err == nil {
+		u.renderer = r
+	}
+
+	u.refresh()
+}
+
+// refresh re-renders the transcript, keeping the view at the bottom when
+// it already was there.
+func (u *ui) refresh() {
+	if !u.ready {
+		return
+	}
+
+	atBottom := u.viewport.AtBottom()
+
+	var b strings.Builder
+	for _, e := range u.entries {
+		b.WriteString(u.render(e))
+		b.WriteString("\n")
+	}
+
+	u.viewport.SetContent(b.String())
+
+	if atBottom || u.streaming {
+		u.viewport.GotoBottom()
+	}
+}
+
+func (u *ui) render(e *entry) string {
+	wrap := lipgloss.NewStyle().Width(u.width)
+
+	switch e.kind {
+	case entryUser:
+		return styleUser.Render("You") + "\n" + wrap.Render(e.text.String()) + "\n"
+
+	case entryInfo:
+		return wrap.Render(styleInfo.Render(e.text.String()))
+
+	case entryError:
+		return wrap.Render(styleError.Render("ERROR: " + e.text.String()))
+	}
+
+	var b strings.Builder
+	b.WriteString(styleModel.Render(e.model))
+	b.WriteString("\n")
+
+	if e.reasoning.Len() > 0 {
+		switch u.display {
+		case agent.ReasoningFull:
+			b.WriteString(wrap.Render(styleReasoning.Render(e.reasoning.String())))
+			b.WriteString("\n")
+
+		case agent.ReasoningCollapsed:
+			if e.thought == 0 {
+				b.WriteString(styleReasoning.Render("thinking..."))
+			} else {
+				b.WriteString(styleReasoning.Render(fmt.Sprintf("thought for %s", e.thought.Round(100*time.Millisecond))))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if !e.done {
+		b.WriteString(wrap.Render(e.text.String() + "▍"))
+		return b.String()
+	}
+
+	if e.width != u.width || e.rendered == "" {
+		e.rendered = e.text.String()
+		if u.renderer != nil {
+			if out, err := u.renderer.Render(e.text.String()); err == nil {
+				e.rendered = strings.Trim(out, "\n")
+			}
+		}
+		e.width = u.width
+	}
+
+	b.WriteString(e.rendered)
+
+	return b.String()
+}
+
+func (u *ui) statusBar() string {
+	state := "ready"
+	switch {
+	case u.streaming:
+		state = "streaming, esc to cancel"
+	case u.agent.Planning():
+		state = "planning"
+	}
+
+	total, last := u.agent.Usage()
+
+	left := fmt.Sprintf("%s  |  context %d tok  |  session in %d / out %d", u.agent.Model(), last.TotalTokens, total.PromptTokens, total.CompletionTokens)
+	gap := max(u.width-lipgloss.Width(left)-lipgloss.Width(state)-2, 1)
+
+	return styleStatus.Width(u.width).Render(left + strings.Repeat(" ", gap) + state)
+}
+
+// =============================================================================
+
+func (u *ui) submit() tea.Cmd {
+	input := strings.TrimSpace(u.input.Value())
+	if input == "" {
+		return nil
+	}
+	u.input.Reset()
+
+	if strings.HasPrefix(input, "/") {
+		return u.command(input)
+	}
+
+	return u.send(input)
+}
+
+// send starts a turn with the input.
+func (u *ui) send(input string) tea.Cmd {
+	images, err := attachments(u.root, input, nil)
+	if err != nil {
+		u.error(err)
+		u.input.SetValue(input)
+		return nil
+	}
+	images = append(u.attached, images...)
+	u.attached = nil
+
+	user := entry{kind: entryUser}
+	user.text.WriteString(input)
+	for _, img := range images {
+		fmt.Fprintf(&user.text, "\n[image %s]", img.Name)
+	}
+
+	answer := entry{
+		kind:    entryAssistant,
+		model:   u.agent.Model(),
+		started: time.Now(),
+	}
+
+	u.entries = append(u.entries, &user, &answer)
+	u.current = &answer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	u.streaming = true
+	u.events = make(chan agent.Event, 256)
+
+	go func(events chan agent.Event) {
+		defer cancel()
+		defer close(events)
+
+		u.agent.TurnWithImages(ctx, input, images, func(e agent.Event) {
+			events <- e
+		})
+	}(u.events)
+
+	return u.waitForEvent()
+}
+
+func (u *ui) waitForEvent() tea.Cmd {
+	events := u.events
+	requests := u.requests
+
+	return func() tea.Msg {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return turnDoneMsg{}
+			}
+			return eventMsg(e)
+
+		case req := <-requests:
+			return approvalMsg(req)
+		}
+	}
+}
+
+// ask shows the first tool call waiting for the user's approval.
+func (u *ui) ask() {
+	call := u.pending[0].call
+	args, _ := json.Marshal(call.Function.Arguments)
+
+	u.info("Allow %s(%s)?\n[y] once  [a] always for this session  [n] deny", call.Function.Name, args)
+	u.input.Placeholder = "y / a / n"
+}
+
+// answer replies to the approval request with the key pressed. Denying asks
+// for the reason in a step of its own, so the reason can start with any
+// letter, the text already in the input box being put back after.
+func (u *ui) answer(msg tea.KeyMsg) (tea.Cmd, bool) {
+	var reply approvalReply
+
+	switch key := msg.String(); {
+	case u.denying && key == "enter":
+		reply = approvalReply{answer: agent.Deny, reason: strings.TrimSpace(u.input.Value())}
+		u.endDenying()
+
+	case u.denying && key == "esc":
+		u.endDenying()
+		u.ask()
+		return nil, true
+
+	case u.denying:
+		return nil, false
+
+	case key == "y" || key == "Y":
+		reply.answer = agent.AllowOnce
+
+	case key == "a" || key == "A":
+		reply.answer = agent.AllowAlways
+
+	case key == "n" || key == "N":
+		u.denying = true
+		u.draft = u.input.Value()
+		u.input.Reset()
+		u.info("Why deny %s? Type the reason for the model and press enter, or press enter to give none. Esc goes back.", u.pending[0].call.Function.Name)
+		u.input.Placeholder = "the reason to deny"
+		return nil, true
+
+	case msg.Type == tea.KeyRunes:
+		// The other letters would end up in the next message.
+		return nil, true
+
+	default:
+		return nil, false
+	}
+
+	req := u.pending[0]
+	u.pending = u.pending[1:]
+	req.reply <- reply
+
+	// The other calls of the tool waiting in line are allowed with it.
+	if reply.answer == agent.AllowAlways {
+		u.pending = slices.DeleteFunc(u.pending, func(r approvalRequest) bool {
+			if r.call.Function.Name != req.call.Function.Name {
+				return false
+			}
+			r.reply <- approvalReply{answer: agent.AllowOnce}
+			return true
+		})
+	}
+
+	switch reply.answer {
+	case agent.AllowOnce:
+		u.info("allowed %s once", req.call.Function.Name)
+	case agent.AllowAlways:
+		u.info("allowed %s for the rest of the session", req.call.Function.Name)
+	default:
+		u.info("denied %s", req.call.Function.Name)
+	}
+
+	u.input.Placeholder = placeholder
+	if len(u.pending) > 0 {
+		u.ask()
+	}
+
+	return nil, true
+}
+
+// endDenying leaves the step asking for the reason of a denial.
+func (u *ui) endDenying() {
+	u.denying = false
+	u.input.SetValue(u.draft)
+	u.draft = ""
+}
+
+// handle applies an agent event to the answer being streamed.
+func (u *ui) handle(e agent.Event) {
+	cur := u.current
+	if cur == nil {
+		return
+	}
+
+	switch e.Kind {
+	case agent.EventReasoning:
+		cur.reasoning.WriteString(e.Text)
+
+	case agent.EventContent:
+		if cur.thought == 0 && cur.reasoning.Len() > 0 {
+			cur.thought = time.Since(cur.started)
+		}
+		cur.text.WriteString(e.Text)
+
+	case agent.EventToolCall:
+		args, _ := json.Marshal(e.ToolCall.Function.Arguments)
+		fmt.Fprintf(&cur.text, "\n\n`-> %s(%s)`\n\n", e.ToolCall.Function.Name, args)
+
+	case agent.EventToolResult:
+		if e.Err != nil {
+			fmt.Fprintf(&cur.text, "`%s failed: %s`\n\n", e.ToolCall.Function.Name, e.Err)
+		}
+
+	case agent.EventWarning:
+		fmt.Fprintf(&cur.text, "`warning: %s`\n\n", e.Err)
+
+	case agent.EventDone:
+		cur.done = true
+
+	case agent.EventError:
+		cur.done = true
+		if errors.Is(e.Err, context.Canceled) {
+			u.info("canceled")
+			return
+		}
+		u.error(e.Err)
+	}
+}
+
+func (u *ui) command(input string) tea.Cmd {
+	cmd, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "/quit", "/exit":
+		return tea.Quit
+
+	case "/help":
+		u.info("%s", helpText)
+
+	case "/image":
+		if arg == "" {
+			u.info("usage: /image <path>, or /image clipboard")
+			break
+		}
+
+		images, err := attachments(u.root, "", []string{arg})
+		if err != nil {
+			u.error(err)
+			break
+		}
+		u.attached = append(u.attached, images...)
+		u.info("attached %s to the next message", images[0].Name)
+
+	case "/allowed":
+		if u.approvals == nil {
+			u.info("no tool asks for approval")
+			break
+		}
+
+		if arg == "reset" {
+			u.approvals.Reset()
+			u.info("the tools will ask for approval again")
+			break
+		}
+
+		allowed := u.approvals.Allowed()
+		if len(allowed) == 0 {
+			u.info("no tool was allowed for the session")
+			break
+		}
+		u.info("allowed for the session: %s", strings.Join(allowed, ", "))
+
+	case "/clear":
+		u.agent.Reset()
+		u.entries = nil
+		u.info("Started a new conversation with %s.", u.agent.Model())
+
+	case "/model":
+		if arg == "" {
+			u.info("Current model is %s.", u.agent.Model())
+			return nil
+		}
+
+		return func() tea.Msg {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			llm := u.newLLM(arg)
+			if err := llm.Require(ctx, client.CapabilityCompletion); err != nil {
+				return infoMsg{err: fmt.Errorf("model %q: %w", arg, err)}
+			}
+
+			u.agent.SetLLM(llm)
+
+			return infoMsg{text: fmt.Sprintf("Switched to %s.", arg)}
+		}
+
+	case "/system":
+		switch arg {
+		case "":
+			u.info("System prompt:\n\n%s", u.agent.SystemPrompt())
+		case "reset":
+			u.agent.SetSystemPrompt(u.system)
+			u.info("Restored the generated system prompt.")
+		default:
+			u.agent.SetSystemPrompt(arg)
+			u.info("Replaced the system prompt for this session.")
+		}
+
+	case "/diff":
+		diff, err := u.cp.Diff()
+		switch {
+		case err != nil:
+			u.error(err)
+		case diff == "":
+			u.info("The agent hasn't changed any file.")
+		default:
+			u.markdown("```diff\n" + diff + "```")
+		}
+
+	case "/undo":
+		n := 1
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+				u.error(fmt.Errorf("undo: %q is not a positive number", arg))
+				return nil
+			}
+		}
+
+		paths, err := u.cp.Undo(n)
+		if err != nil {
+			u.error(err)
+			return nil
+		}
+		u.info("Reverted %s.", strings.Join(paths, ", "))
+
+	case "/restore":
+		turn, err := strconv.Atoi(arg)
+		if err != nil || turn < 1 {
+			u.error(errors.New("restore: pass the number of the turn, see /checkpoints"))
+			return nil
+		}
+
+		paths, err := u.cp.RestoreTurn(turn)
+		if err != nil {
+			u.error(err)
+			return nil
+		}
+		u.info("Restored %s to before turn %d.", strings.Join(paths, ", "), turn)
+
+	case "/checkpoints":
+		list := u.cp.List()
+		if len(list) == 0 {
+			u.info("No file edits recorded, this is turn %d.", u.cp.Turn())
+			return nil
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "This is turn %d.", u.cp.Turn())
+		for i, c := range list {
+			fmt.Fprintf(&b, "\n%3d  turn %-3d %s  %s %s", i+1, c.Turn, c.Time.Format("15:04:05"), c.Tool, c.Path)
+		}
+		u.info("%s", b.String())
+
+	case "/plan":
+		sub, rest, _ := strings.Cut(arg, "\n")
+		sub, more, _ := strings.Cut(strings.TrimSpace(sub), " ")
+		rest = strings.TrimSpace(more + "\n" + rest)
+
+		switch sub {
+		case "":
+			u.showPlan()
+
+		case "on", "off":
+			u.agent.SetPlanning(sub == "on")
+			if sub == "on" {
+				u.info("Planning mode is on, the agent can only read until you /approve the plan.")
+			} else {
+				u.info("Planning mode is off.")
+			}
+
+		case "clear":
+			if err := u.plan.Clear(); err != nil {
+				u.error(err)
+				return nil
+			}
+			u.info("Dropped the plan.")
+
+		case "edit":
+			var b strings.Builder
+			b.WriteString("/plan set\n")
+			for _, step := range u.plan.Steps() {
+				switch step.Status {
+				case agent.StepCompleted:
+					fmt.Fprintf(&b, "- [x] %s\n", step.Step)
+				case agent.StepInProgress:
+					fmt.Fprintf(&b, "- [ ] %s (in progress)\n", step.Step)
+				default:
+					fmt.Fprintf(&b, "- [ ] %s\n", step.Step)
+				}
+			}
+			u.input.SetValue(b.String())
+
+		case "set":
+			steps := agent.ParsePlan(rest)
+			if err := u.plan.Update("Edited by the user.", steps); err != nil {
+				u.error(err)
+				return nil
+			}
+			u.showPlan()
+
+		default:
+			u.error(fmt.Errorf("plan: unknown subcommand %q, see /help", sub))
+		}
+
+	case "/approve":
+		if len(u.plan.Steps()) == 0 {
+			u.error(errors.New("approve: there is no plan yet, see /plan on"))
+			return nil
+		}
+
+		if u.plan.Approved() && !u.agent.Planning() {
+			u.error(errors.New("approve: the plan is already approved"))
+			return nil
+		}
+
+		u.agent.SetPlanning(false)
+		u.plan.Approve()
+		return u.send(approveMessage)
+
+	case "/save":
+		path := arg
+		if path == "" {
+			path = fmt.Sprintf("transcript-%s.md", time.Now().Format("20060102-150405"))
+		}
+
+		if err := u.save(path); err != nil {
+			u.error(err)
+			return nil
+		}
+		u.info("Saved to %s.", path)
+
+	default:
+		u.error(fmt.Errorf("unknown command %s, see /help", cmd))
+	}
+
+	return nil
+}
+
+// save writes the raw conversation when the path ends in .json and a
+// markdown transcript otherwise.
+func (u *ui) save(path string) error {
+	if filepath.Ext(path) == ".json" {
+		return u.agent.Save(path)
+	}
+
+	var b strings.Builder
+	for _, e := range u.entries {
+		switch e.kind {
+		case entryUser:
+			fmt.Fprintf(&b, "## You\n\n%s\n\n", e.text.String())
+		case entryAssistant:
+			fmt.Fprintf(&b, "## %s\n\n", e.model)
+			if e.reasoning.Len() > 0 {
+				fmt.Fprintf(&b, "<details><summary>Reasoning</summary>\n\n%s\n\n</details>\n\n", e.reasoning.String())
+			}
+			fmt.Fprintf(&b, "%s\n\n", e.text.String())
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+func (u *ui) info(format string, args ...any) {
+	e := entry{kind: entryInfo, done: true}
+	fmt.Fprintf(&e.text, format, args...)
+	u.entries = append(u.entries, &e)
+}
+
+func (u *ui) showPlan() {
+	plan := u.plan.Render()
+	if plan == "" {
+		u.info("There is no plan.")
+		return
+	}
+	u.markdown("**Plan**\n\n" + plan)
+}
+
+// markdown adds a rendered entry, like an answer, that isn't part of the
+// conversation.
+func (u *ui) markdown(text string) {
+	e := entry{kind: entryAssistant, model: "agent", done: true}
+	e.text.WriteString(text)
+	u.entries = append(u.entries, &e)
+}
+
+func (u *ui) error(err error) {
+	e := entry{kind: entryError, done: true}
+	e.text.WriteString(err.Error())
+	u.entries = append(u.entries, &e)
+}
@@ -0,0 +1,159 @@
+// This program runs suites of tasks through the coding agent and compares
+// the results of two runs, to tell whether a model or prompt change made the
+// agent better.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-coding-agent/pkg/eval"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "run":
+		return runSuite(flag.Args()[1:])
+	case "diff":
+		return diffRuns(flag.Args()[1:])
+	}
+
+	usage()
+	os.Exit(2)
+
+	return nil
+}
+
+func runSuite(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	agentBin := fs.String("agent", "", "agent binary, built from ./cmd/agent when empty")
+	out := fs.String("out", "", "file to write the results to, eval-<suite>-<time>.json by default")
+	parallel := fs.Int("parallel", 1, "number of tasks run at once")
+	keep := fs.Bool("keep", false, "keep the working directories of the tasks")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return errors.New("run: missing suite file")
+	}
+
+	suite, err := eval.LoadSuite(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *agentBin == "" {
+		dir, err := os.MkdirTemp("", "eval-agent-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		*agentBin = filepath.Join(dir, "agent")
+
+		cmd := exec.CommandContext(ctx, "go", "build", "-o", *agentBin, "./cmd/agent")
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("build agent: %w", err)
+		}
+	}
+
+	if *out == "" {
+		*out = fmt.Sprintf("eval-%s-%s.json", suite.Name, time.Now().Format("20060102-150405"))
+	}
+
+	fmt.Printf("running %d tasks of %s\n", len(suite.Tasks), suite.Name)
+
+	options := []func(r *eval.Runner){
+		eval.WithParallel(*parallel),
+		eval.WithProgress(progress),
+	}
+	if *keep {
+		options = append(options, eval.WithKeep())
+	}
+
+	result := eval.NewRunner(*agentBin, fs.Args()[1:], options...).Run(ctx, suite)
+
+	if err := result.Save(*out); err != nil {
+		return fmt.Errorf("save results: %w", err)
+	}
+
+	fmt.Printf("\npassed %d/%d in %s, results in %s\n", result.Passed(), len(result.Results),
+		(time.Duration(result.Duration) * time.Millisecond).Round(time.Second), *out)
+
+	if failed := len(result.Results) - result.Passed(); failed > 0 {
+		return fmt.Errorf("%d tasks failed", failed)
+	}
+
+	return nil
+}
+
+func progress(res eval.Result) {
+	took := (time.Duration(res.DurationMS) * time.Millisecond).Round(100 * time.Millisecond)
+
+	status := "PASS"
+	if !res.Passed {
+		status = "FAIL"
+	}
+
+	fmt.Printf("%s  %s  %s, %d tokens, %d tool calls\n", status, res.Task, took, res.TotalTokens, res.ToolCalls)
+	if res.Reason != "" {
+		fmt.Printf("      %s\n", res.Reason)
+	}
+	if res.Dir != "" {
+		fmt.Printf("      kept %s\n", res.Dir)
+	}
+}
+
+func diffRuns(args []string) error {
+	if len(args) != 2 {
+		return errors.New("diff: need the two result files to compare")
+	}
+
+	before, err := eval.LoadRun(args[0])
+	if err != nil {
+		return err
+	}
+
+	after, err := eval.LoadRun(args[1])
+	if err != nil {
+		return err
+	}
+
+	return eval.WriteDiff(os.Stdout, before, after)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  %[1]s run [flags] suite.yaml [agent flags]
+  %[1]s diff before.json after.json
+
+The agent flags, like -model, are passed to the agent for every task.
+
+Run flags:
+  -agent path    agent binary, built from ./cmd/agent when empty
+  -out file      file to write the results to
+  -parallel n    number of tasks run at once, 1 by default
+  -keep          keep the working directories of the tasks
+`, os.Args[0])
+}
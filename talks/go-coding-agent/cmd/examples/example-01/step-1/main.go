@@ -4,17 +4,26 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"go-coding-agent/pkg/agent"
 	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/telemetry"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
 )
 
 var (
-	url   = "http://localhost:11434/v1/chat/completions"
-	model = "gpt-oss:20b"
+	url      = "http://localhost:11434/v1/chat/completions"
+	model    = "gpt-oss:20b"
+	exporter = ""
+	debug    = false
+
+	// Set LLM_REASONING to hidden, collapsed or full and LLM_KEEP_REASONING
+	// to send the reasoning back with the history.
+	reasoning     = agent.ReasoningFull
+	keepReasoning = false
 )
 
 func init() {
@@ -25,10 +34,35 @@ func init() {
 	if v := os.Getenv("LLM_MODEL"); v != "" {
 		model = v
 	}
+
+	exporter = os.Getenv("LLM_TELEMETRY")
+
+	debug = os.Getenv("LLM_DEBUG") != ""
+	keepReasoning = os.Getenv("LLM_KEEP_REASONING") != ""
+
+	mode, err := agent.ParseReasoningDisplay(os.Getenv("LLM_REASONING"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	reasoning = mode
 }
 
 func main() {
-	if err := run(); err != nil {
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{
+		ServiceName: "go-coding-agent-example-01-step-1",
+		Exporter:    exporter,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = run()
+
+	if err := shutdown(context.Background()); err != nil {
+		log.Println("telemetry shutdown:", err)
+	}
+
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -42,25 +76,42 @@ func run() error {
 		return scanner.Text(), true
 	}
 
-	agent, err := NewAgent(getUserMessage)
+	a, err := NewAgent(getUserMessage)
 	if err != nil {
 		return fmt.Errorf("failed to create agent: %w", err)
 	}
 
-	return agent.Run(context.TODO())
+	if err := a.llm.Require(context.TODO(), client.CapabilityCompletion); err != nil {
+		return fmt.Errorf("model %q: %w", model, err)
+	}
+
+	return a.Run(context.TODO())
 }
 
 // =============================================================================
 
 // Agent represents the chat agent that can use tools to perform tasks.
 type Agent struct {
-	sseClient      *client.SSEClient[client.ChatSSE]
+	llm            *client.LLM
 	getUserMessage func() (string, bool)
 }
 
 func NewAgent(getUserMessage func() (string, bool)) (*Agent, error) {
+	// Only warnings and errors are logged so they don't get mixed into the
+	// chat, unless LLM_DEBUG is set.
+	level := slog.LevelWarn
+	if debug {
+		level = slog.LevelDebug
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	options := []func(cln *client.Client){client.WithSlog(log)}
+	if debug {
+		options = append(options, client.WithMiddleware(client.LogBodies(log)))
+	}
+
 	agent := Agent{
-		sseClient:      client.NewSSE[client.ChatSSE](client.StdoutLogger),
+		llm:            client.NewLLM(url, model, client.WithClientOptions(options...)),
 		getUserMessage: getUserMessage,
 	}
 
@@ -68,7 +119,9 @@ func NewAgent(getUserMessage func() (string, bool)) (*Agent, error) {
 }
 
 func (a *Agent) Run(ctx context.Context) error {
-	var conversation []client.D
+	conversation := agent.Conversation{
+		KeepReasoning: keepReasoning,
+	}
 
 	fmt.Printf("Chat with %s (use 'ctrl-c' to quit)\n", model)
 
@@ -79,56 +132,59 @@ func (a *Agent) Run(ctx context.Context) error {
 			break
 		}
 
-		conversation = append(conversation, client.D{
-			"role":    "user",
-			"content": userInput,
+		conversation.Add(agent.Message{
+			Role:    agent.RoleUser,
+			Content: userInput,
 		})
 
-		d := client.D{
-			"model":       model,
-			"messages":    conversation,
-			"temperature": 0.1,
-			"top_p":       0.1,
-			"top_k":       1,
-			"stream":      true,
-		}
-
 		fmt.Printf("\u001b[93m\n%s\u001b[0m: ", model)
 
-		ch := make(chan client.ChatSSE, 100)
 		ctx, cancelContext := context.WithTimeout(ctx, time.Minute*5)
 
-		if err := a.sseClient.Do(ctx, http.MethodPost, url, d, ch); err != nil {
+		ch, err := a.llm.ChatCompletionsSSE(ctx, "",
+			client.WithConversation(conversation.D()),
+			client.WithParams(0.1, 0.1, 1),
+		)
+		if err != nil {
 			cancelContext()
 			fmt.Printf("\n\n\u001b[91mERROR:%s\u001b[0m\n\n", err)
 			continue
 		}
 
-		var chunks []string
+		// The chunks are concatenated exactly as they arrive, they already
+		// carry their own spacing.
+		var content strings.Builder
+		var thoughts strings.Builder
+
+		printer := agent.NewPrinter(os.Stdout, reasoning)
 
-		for resp := range ch {
-			if len(resp.Choices) == 0 {
-				continue
+		for delta, err := range client.Deltas(ch) {
+			if err != nil {
+				fmt.Printf("\n\n\u001b[91mERROR:%s\u001b[0m\n\n", err)
+				break
 			}
 
-			switch {
-			case resp.Choices[0].Delta.Content != "":
-				fmt.Print(resp.Choices[0].Delta.Content)
-				chunks = append(chunks, resp.Choices[0].Delta.Content)
+			if delta.Reasoning != "" {
+				printer.Reasoning(delta.Reasoning)
+				thoughts.WriteString(delta.Reasoning)
+			}
 
-			case resp.Choices[0].Delta.Reasoning != "":
-				fmt.Printf("\u001b[91m%s\u001b[0m", resp.Choices[0].Delta.Reasoning)
+			if delta.Content != "" {
+				printer.Content(delta.Content)
+				content.WriteString(delta.Content)
 			}
 		}
 
+		printer.Done()
 		cancelContext()
 
-		if len(chunks) > 0 {
+		if content.Len() > 0 {
 			fmt.Print("\n")
 
-			conversation = append(conversation, client.D{
-				"role":    "assistant",
-				"content": strings.Join(chunks, " "),
+			conversation.Add(agent.Message{
+				Role:      agent.RoleAssistant,
+				Content:   content.String(),
+				Reasoning: thoughts.String(),
 			})
 		}
 	}
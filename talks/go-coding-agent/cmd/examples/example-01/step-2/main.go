@@ -5,14 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/telemetry"
 	"log"
-	"net/http"
 	"os"
 )
 
 var (
-	url   = "http://localhost:11434/v1/chat/completions"
-	model = "gpt-oss:20b"
+	url      = "http://localhost:11434/v1/chat/completions"
+	model    = "gpt-oss:20b"
+	exporter = ""
 )
 
 func init() {
@@ -23,27 +24,46 @@ func init() {
 	if v := os.Getenv("LLM_MODEL"); v != "" {
 		model = v
 	}
+
+	exporter = os.Getenv("LLM_TELEMETRY")
 }
 
 // =============================================================================
 
 func main() {
-	if err := run(); err != nil {
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{
+		ServiceName: "go-coding-agent-example-01-step-2",
+		Exporter:    exporter,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = run()
+
+	if err := shutdown(context.Background()); err != nil {
+		log.Println("telemetry shutdown:", err)
+	}
+
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func run() error {
-	if err := weatherQuestion(context.TODO()); err != nil {
+	llm := client.NewLLM(url, model)
+	if err := llm.Require(context.TODO(), client.CapabilityTools); err != nil {
+		return fmt.Errorf("model %q: %w", model, err)
+	}
+
+	if err := weatherQuestion(context.TODO(), llm); err != nil {
 		return fmt.Errorf("weatherQuestion: %w", err)
 	}
 
 	return nil
 }
 
-func weatherQuestion(ctx context.Context) error {
-	cln := client.NewSSE[client.ChatSSE](client.StdoutLogger)
-
+func weatherQuestion(ctx context.Context, llm *client.LLM) error {
 	// -------------------------------------------------------------------------
 	// Start by asking what the weather is like in New York City
 
@@ -58,40 +78,33 @@ func weatherQuestion(ctx context.Context) error {
 		},
 	}
 
-	toolName := "tool_get_weather"
-
-	d := client.D{
-		"model":       model,
-		"messages":    conversation,
-		"temperature": 0.1,
-		"top_p":       0.1,
-		"top_k":       50,
-		"max_tokens":  32 * 1024,
-		"stream":      true,
-		"tools": []client.D{
-			{
-				"type": "function",
-				"function": client.D{
-					"name":        toolName,
-					"description": "Get the current weather for a location",
-					"parameters": client.D{
-						"type": "object",
-						"properties": client.D{
-							"location": client.D{
-								"type":        "string",
-								"description": "The location to get the weather for, e.g. San Francisco, CA",
-							},
+	tools := []client.D{
+		{
+			"type": "function",
+			"function": client.D{
+				"name":        "tool_get_weather",
+				"description": "Get the current weather for a location",
+				"parameters": client.D{
+					"type": "object",
+					"properties": client.D{
+						"location": client.D{
+							"type":        "string",
+							"description": "The location to get the weather for, e.g. San Francisco, CA",
 						},
-						"required": []string{"location"},
 					},
+					"required": []string{"location"},
 				},
 			},
 		},
-		"tool_selection": "auto",
 	}
 
-	ch := make(chan client.ChatSSE, 100)
-	if err := cln.Do(ctx, http.MethodPost, url, d, ch); err != nil {
+	ch, err := llm.ChatCompletionsSSE(ctx, "",
+		client.WithConversation(conversation),
+		client.WithParams(0.1, 0.1, 50),
+		client.WithMaxTokens(32*1024),
+		client.WithTools(tools...),
+	)
+	if err != nil {
 		return fmt.Errorf("do: %w", err)
 	}
 
@@ -101,79 +114,60 @@ func weatherQuestion(ctx context.Context) error {
 
 	fmt.Print("\n")
 
-	for resp := range ch {
+	// The tool call can come in pieces, Collect puts it back together while
+	// the text is printed as it arrives.
+	result, err := client.CollectWith(ch, func(delta client.ChatDeltaSSE) {
 		switch {
-		case len(resp.Choices[0].Delta.ToolCalls) > 0:
-			toolCall := resp.Choices[0].Delta.ToolCalls[0]
-
-			fmt.Printf("\n\n\u001b[92mModel Asking For Tool Call:\n\nToolID[%s]: %s(%s)\u001b[0m\n\n",
-				toolCall.ID,
-				toolCall.Function.Name,
-				toolCall.Function.Arguments)
-
-			argsJSON, _ := json.Marshal(toolCall.Function.Arguments)
-			conversation = append(conversation, client.D{
-				"role": "assistant",
-				"tool_calls": []client.D{
-					{
-						"id":   toolCall.ID,
-						"type": "function",
-						"function": client.D{
-							"name":      toolCall.Function.Name,
-							"arguments": string(argsJSON),
-						},
+		case delta.Content != "":
+			fmt.Print(delta.Content)
+
+		case delta.Reasoning != "":
+			fmt.Printf("\u001b[91m%s\u001b[0m", delta.Reasoning)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("stream: %w", err)
+	}
+
+	for _, toolCall := range result.ToolCalls {
+		fmt.Printf("\n\n\u001b[92mModel Asking For Tool Call:\n\nToolID[%s]: %s(%s)\u001b[0m\n\n",
+			toolCall.ID,
+			toolCall.Function.Name,
+			toolCall.Function.Arguments)
+
+		conversation = append(conversation, client.D{
+			"role": "assistant",
+			"tool_calls": []client.D{
+				{
+					"id":   toolCall.ID,
+					"type": "function",
+					"function": client.D{
+						"name":      toolCall.Function.Name,
+						"arguments": toolCall.Function.RawArguments,
 					},
 				},
-			})
-
-			resp := GetWeatherTool(ctx, toolCall)
-			conversation = append(conversation, resp)
+			},
+		})
 
-			fmt.Printf("%s\n\n", resp)
+		toolCtx, end := telemetry.StartTool(ctx, toolCall.Function.Name, toolCall.ID)
+		resp := GetWeatherTool(toolCtx, toolCall)
+		end(nil)
 
-		case resp.Choices[0].Delta.Content != "":
-			fmt.Print(resp.Choices[0].Delta.Content)
+		conversation = append(conversation, resp)
 
-		case resp.Choices[0].Delta.Reasoning != "":
-			fmt.Printf("\u001b[91m%s\u001b[0m", resp.Choices[0].Delta.Reasoning)
-		}
+		fmt.Printf("%s\n\n", resp)
 	}
 
 	// -------------------------------------------------------------------------
 	// Send the result of the tool call back to the model
 
-	d = client.D{
-		"model":       model,
-		"messages":    conversation,
-		"temperature": 0.1,
-		"top_p":       0.1,
-		"top_k":       50,
-		"max_tokens":  32 * 1024,
-		"stream":      true,
-		"tools": []client.D{
-			{
-				"type": "function",
-				"function": client.D{
-					"name":        toolName,
-					"description": "Get the current weather for a location",
-					"parameters": client.D{
-						"type": "object",
-						"properties": client.D{
-							"location": client.D{
-								"type":        "string",
-								"description": "The location to get the weather for, e.g. San Francisco, CA",
-							},
-						},
-						"required": []string{"location"},
-					},
-				},
-			},
-		},
-		"tool_selection": "auto",
-	}
-
-	ch = make(chan client.ChatSSE, 100)
-	if err := cln.Do(ctx, http.MethodPost, url, d, ch); err != nil {
+	ch, err = llm.ChatCompletionsSSE(ctx, "",
+		client.WithConversation(conversation),
+		client.WithParams(0.1, 0.1, 50),
+		client.WithMaxTokens(32*1024),
+		client.WithTools(tools...),
+	)
+	if err != nil {
 		return fmt.Errorf("do: %w", err)
 	}
 
@@ -184,19 +178,23 @@ func weatherQuestion(ctx context.Context) error {
 
 	var reasoning bool
 
-	for resp := range ch {
+	for delta, err := range client.Deltas(ch) {
+		if err != nil {
+			return fmt.Errorf("stream: %w", err)
+		}
+
 		switch {
-		case resp.Choices[0].Delta.Content != "":
+		case delta.Content != "":
 			if reasoning {
 				fmt.Print("\n\n")
 				reasoning = false
 			}
 
-			fmt.Print(resp.Choices[0].Delta.Content)
+			fmt.Print(delta.Content)
 
-		case resp.Choices[0].Delta.Reasoning != "":
+		case delta.Reasoning != "":
 			reasoning = true
-			fmt.Printf("\u001b[91m%s\u001b[0m", resp.Choices[0].Delta.Reasoning)
+			fmt.Printf("\u001b[91m%s\u001b[0m", delta.Reasoning)
 		}
 	}
 
@@ -211,7 +209,7 @@ func GetWeatherTool(ctx context.Context, toolCall client.ToolCall) (resp client.
 
 	// We are going to hardcode a result for now so we can test the tool.
 
-	location := toolCall.Function.Arguments["location"].(string)
+	location, _ := toolCall.Function.Arguments["location"].(string)
 
 	info := struct {
 		Status string         `json:"status"`
@@ -243,4 +241,4 @@ func GetWeatherTool(ctx context.Context, toolCall client.ToolCall) (resp client.
 		"tool_call_id": toolCall.ID,
 		"content":      string(d),
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,93 @@
+// This program lists the models available on the configured server along
+// with their context length and capabilities.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var url = "http://localhost:11434/v1/chat/completions"
+
+func init() {
+	if v := os.Getenv("LLM_SERVER"); v != "" {
+		url = v
+	}
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	asJSON := flag.Bool("json", false, "print the models as JSON")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cln := client.New(client.NoopLogger)
+
+	models, err := cln.ListModels(ctx, url)
+	if err != nil {
+		return fmt.Errorf("list models: %w", err)
+	}
+
+	// The context length and capabilities take a request per model, only
+	// Ollama answers them so the first failure stops asking.
+	for i, m := range models {
+		mi, err := cln.ShowModel(ctx, url, m.ID)
+		if err != nil {
+			break
+		}
+		models[i] = mi
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(models)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCONTEXT\tTOOLS\tVISION\tEMBEDDING\tCAPABILITIES")
+
+	for _, m := range models {
+		if !m.Detailed {
+			fmt.Fprintf(w, "%s\t?\t?\t?\t?\t?\n", m.ID)
+			continue
+		}
+
+		caps := make([]string, len(m.Capabilities))
+		for i, c := range m.Capabilities {
+			caps[i] = string(c)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			m.ID,
+			m.ContextLength,
+			yesNo(m.Supports(client.CapabilityTools)),
+			yesNo(m.Supports(client.CapabilityVision)),
+			yesNo(m.Supports(client.CapabilityEmbedding)),
+			strings.Join(caps, ","))
+	}
+
+	return w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,184 @@
+// This program runs a question answering service over the documents ingested
+// into it, using a local model for the embeddings and the answers. LLM_SERVER
+// can list several chat endpoints separated by commas, the questions are
+// spread across them and fall back to -fallback-model when they all fail.
+//
+//	curl -d '{"source": "facts.txt", "text": "Paris is the capital of France."}' localhost:8080/v1/documents
+//	curl -d '{"question": "What is the capital of France?"}' localhost:8080/v1/query
+//	curl -N -d '{"question": "What is the capital of France?", "stream": true}' localhost:8080/v1/query
+//	curl -X DELETE localhost:8080/v1/documents/facts.txt
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/rag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	chatURL  = "http://localhost:11434/v1/chat/completions"
+	embedURL = "http://localhost:11434/v1/embeddings"
+)
+
+func init() {
+	if v := os.Getenv("LLM_SERVER"); v != "" {
+		chatURL = v
+	}
+	if v := os.Getenv("LLM_EMBED_SERVER"); v != "" {
+		embedURL = v
+	}
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on, only local clients can connect by default")
+	chatModel := flag.String("model", "gpt-oss:20b", "model answering the questions")
+	embedModel := flag.String("embed-model", "nomic-embed-text", "model embedding the documents")
+	storeDir := flag.String("store", "rag", "directory the documents are kept in, in memory when empty")
+	topK := flag.Int("k", 4, "number of chunks retrieved for a question")
+	chunkSize := flag.Int("chunk-size", rag.DefaultChunkSize, "size of the chunks in characters")
+	overlap := flag.Int("chunk-overlap", rag.DefaultChunkOverlap, "characters shared by consecutive chunks")
+	hybrid := flag.Bool("hybrid", false, "retrieve by keywords as well as embeddings")
+	rerank := flag.Bool("rerank", false, "have the model rerank the hybrid candidates")
+	lambda := flag.Float64("mmr", 0, "diversify the hybrid results by MMR with this relevance weight, 0.7 is a good start")
+	fallbackModel := flag.String("fallback-model", "", "model answering when the chat endpoints fail or the prompt doesn't fit")
+	cacheDir := flag.String("cache", defaultCacheDir(), "directory the embeddings are cached in, so documents ingested again aren't embedded again, off when empty")
+	flag.Parse()
+
+	store, err := rag.NewStore(*storeDir)
+	if err != nil {
+		return err
+	}
+
+	var embedOptions []func(llm *client.LLM)
+	if *cacheDir != "" {
+		cache, err := client.NewDiskCache(*cacheDir, 256<<20, 0)
+		if err != nil {
+			return fmt.Errorf("embedding cache: %w", err)
+		}
+		embedOptions = append(embedOptions, client.WithCache(cache))
+	}
+
+	embed := client.NewLLM(embedURL, *embedModel, embedOptions...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := embed.Require(ctx, client.CapabilityEmbedding); err != nil {
+		return fmt.Errorf("model %q: %w", *embedModel, err)
+	}
+
+	// An endpoint down at start is only reported, the group sends it
+	// requests again once it's back.
+	var chats []*client.LLM
+	var errs []error
+	for u := range strings.SplitSeq(chatURL, ",") {
+		llm := client.NewLLM(strings.TrimSpace(u), *chatModel)
+		if err := llm.Require(ctx, client.CapabilityCompletion); err != nil {
+			log.Printf("rag: %s: model %q: %s", llm.URL(), *chatModel, err)
+			errs = append(errs, err)
+		}
+		chats = append(chats, llm)
+	}
+	if len(errs) == len(chats) {
+		return fmt.Errorf("model %q: %w", *chatModel, errors.Join(errs...))
+	}
+
+	groupOptions := []func(g *client.LLMGroup){
+		client.WithStrategy(client.LeastLoaded),
+	}
+	if *fallbackModel != "" {
+		groupOptions = append(groupOptions, client.WithFallback(client.NewLLM(chats[0].URL(), *fallbackModel)))
+	}
+
+	chat := client.NewLLMGroup(chats, groupOptions...)
+
+	options := []func(s *rag.Service){
+		rag.WithTopK(*topK),
+		rag.WithChunking(*chunkSize, *overlap),
+	}
+
+	if *hybrid {
+		hybridOptions := []func(r *rag.HybridRetriever){
+			rag.WithMMR(*lambda),
+		}
+
+		if *rerank {
+			reranker, err := rag.NewLLMReranker(chat)
+			if err != nil {
+				return err
+			}
+			hybridOptions = append(hybridOptions, rag.WithReranker(reranker))
+		}
+
+		options = append(options, rag.WithRetriever(rag.NewHybridRetriever(store, embed.EmbedText, hybridOptions...)))
+	}
+
+	svc, err := rag.NewService(store, embed.EmbedText, chat, options...)
+	if err != nil {
+		return err
+	}
+
+	srv := http.Server{
+		Addr:              *addr,
+		Handler:           svc.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		log.Printf("rag: listening on %s, %d chunks in store", *addr, len(store.Chunks()))
+		serverErrors <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErrors:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server: %w", err)
+		}
+		return nil
+
+	case sig := <-shutdown:
+		log.Printf("rag: %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return fmt.Errorf("shutdown: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// defaultCacheDir returns the directory the embeddings are cached in, empty
+// when the user has no cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "go-coding-agent", "embeddings")
+}
@@ -0,0 +1,525 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/telemetry"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// ErrMaxSteps is returned when the model keeps calling tools past the step
+// limit of a turn.
+var ErrMaxSteps = errors.New("maximum number of steps reached")
+
+// ErrTokenBudget is returned when a turn used up its token budget while the
+// model was still calling tools.
+var ErrTokenBudget = errors.New("token budget exhausted")
+
+// ErrDenied is returned for tool calls the policy doesn't let run.
+var ErrDenied = errors.New("tool call denied")
+
+// Policy decides whether a tool call can run. The error returned denies the
+// call and is sent to the model as the result. HookApproved tells a policy
+// the call was approved by a hook, which spares asking the user but doesn't
+// lift a rule denying the tool.
+type Policy func(ctx context.Context, call client.ToolCall) error
+
+// EventKind identifies the type of an Event.
+type EventKind int
+
+// Set of events emitted while a turn runs.
+const (
+	EventReasoning EventKind = iota + 1
+	EventContent
+	EventToolCall
+	EventToolResult
+	EventDone
+	EventError
+	EventWarning
+)
+
+// Event is something that happened while the agent ran a turn. Text is set
+// for reasoning and content tokens and holds the output of a tool for
+// EventToolResult, with Err set when the tool failed. EventDone carries the
+// tokens used by the whole turn and the finish reason of the last request.
+// EventWarning carries in Err something the user should know that doesn't
+// stop the turn.
+type Event struct {
+	Kind     EventKind
+	Text     string
+	ToolCall *client.ToolCall
+	Usage    *client.Usage
+	Reason   string
+	Err      error
+}
+
+// =============================================================================
+
+// Agent holds a conversation with a model and runs it one turn at a time.
+type Agent struct {
+	params    []client.Option
+	tools     map[string]Tool
+	defs      []client.D
+	maxSteps  int
+	policy    Policy
+	preHooks  []Hook
+	postHooks []Hook
+	cp        *Checkpoints
+	budget    int
+	delegate  *DelegateConfig
+	plan      *Plan
+	memories  *Memories
+
+	// turn serializes the turns and guards the conversation, mu guards the
+	// state the UI reads while a turn is running.
+	turn      sync.Mutex
+	conv      Conversation
+	mu        sync.Mutex
+	llm       *client.LLM
+	system    string
+	planning  bool
+	usage     client.Usage
+	lastUsage client.Usage
+}
+
+// New constructs an agent using the llm to talk to the model.
+func New(llm *client.LLM, options ...func(a *Agent)) *Agent {
+	a := Agent{
+		llm:      llm,
+		tools:    make(map[string]Tool),
+		maxSteps: 25,
+	}
+
+	for _, option := range options {
+		option(&a)
+	}
+
+	if a.delegate != nil {
+		a.addTools(a.delegateTool())
+	}
+
+	return &a
+}
+
+// WithLLMOptions sets the options, like sampling parameters, sent with every
+// request.
+func WithLLMOptions(options ...client.Option) func(a *Agent) {
+	return func(a *Agent) {
+		a.params = append(a.params, options...)
+	}
+}
+
+// WithTools lets the model call the tools.
+func WithTools(tools ...Tool) func(a *Agent) {
+	return func(a *Agent) {
+		a.addTools(tools...)
+	}
+}
+
+// WithMaxSteps limits the number of requests a single turn can make while
+// the model calls tools, 25 by default.
+func WithMaxSteps(n int) func(a *Agent) {
+	return func(a *Agent) {
+		a.maxSteps = n
+	}
+}
+
+// WithPlan lets the model keep the plan with the update_plan tool. Once the
+// user approved it the plan is sent with every request so long tasks stay
+// on track.
+func WithPlan(plan *Plan) func(a *Agent) {
+	return func(a *Agent) {
+		a.plan = plan
+		a.addTools(plan.Tool())
+	}
+}
+
+// WithMemory lets the model keep facts across sessions with the remember
+// and recall tools. The memories relevant to the input are recalled at the
+// start of every turn and sent with its requests.
+func WithMemory(memories *Memories) func(a *Agent) {
+	return func(a *Agent) {
+		a.memories = memories
+		a.addTools(memories.Tools()...)
+	}
+}
+
+// WithTokenBudget stops a turn that used n tokens or more before it sends
+// the results of the tools back to the model.
+func WithTokenBudget(n int) func(a *Agent) {
+	return func(a *Agent) {
+		a.budget = n
+	}
+}
+
+// WithPolicy checks every tool call with the policy before running it.
+func WithPolicy(policy Policy) func(a *Agent) {
+	return func(a *Agent) {
+		a.policy = policy
+	}
+}
+
+// WithCheckpoints numbers the turns of the checkpoints, so the files can be
+// restored to the way they were before a turn.
+func WithCheckpoints(cp *Checkpoints) func(a *Agent) {
+	return func(a *Agent) {
+		a.cp = cp
+	}
+}
+
+// WithSystemPrompt sets the system message sent ahead of the conversation.
+func WithSystemPrompt(prompt string) func(a *Agent) {
+	return func(a *Agent) {
+		a.system = prompt
+	}
+}
+
+// WithKeepReasoning sends the reasoning of earlier answers back to the model.
+func WithKeepReasoning(keep bool) func(a *Agent) {
+	return func(a *Agent) {
+		a.conv.KeepReasoning = keep
+	}
+}
+
+// Model returns the name of the model the agent talks to.
+func (a *Agent) Model() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.llm.Model()
+}
+
+// SetLLM switches the model for the following turns, keeping the history.
+func (a *Agent) SetLLM(llm *client.LLM) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.llm = llm
+}
+
+// SystemPrompt returns the system message sent ahead of the conversation.
+func (a *Agent) SystemPrompt() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.system
+}
+
+// SetSystemPrompt replaces the system message for the following turns.
+func (a *Agent) SetSystemPrompt(prompt string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.system = prompt
+}
+
+// Planning reports whether the agent is in planning mode.
+func (a *Agent) Planning() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.planning
+}
+
+// SetPlanning switches planning mode, where the model can only use the
+// read-only tools and is asked to write a plan with update_plan for the user
+// to approve. It requires WithPlan.
+func (a *Agent) SetPlanning(planning bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.planning = planning && a.plan != nil
+}
+
+// Reset clears the conversation and the token counters.
+func (a *Agent) Reset() {
+	a.turn.Lock()
+	defer a.turn.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.conv.Reset()
+	a.usage = client.Usage{}
+	a.lastUsage = client.Usage{}
+}
+
+// Usage returns the tokens used across the whole session and by the last
+// request, whose prompt tokens tell how full the context is.
+func (a *Agent) Usage() (total client.Usage, last client.Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.usage, a.lastUsage
+}
+
+// Save writes the conversation as JSON.
+func (a *Agent) Save(path string) error {
+	a.turn.Lock()
+	defer a.turn.Unlock()
+
+	data, err := json.MarshalIndent(a.conv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// Turn sends the user input to the model and streams the answer back through
+// emit. Tools the model asks for are run and their results sent back until
+// the model answers without calling any. When the context is canceled the
+// partial answer is kept in the history and the context error is returned.
+func (a *Agent) Turn(ctx context.Context, input string, emit func(Event)) error {
+	return a.turnWith(ctx, Message{Role: RoleUser, Content: input}, emit)
+}
+
+func (a *Agent) turnWith(ctx context.Context, msg Message, emit func(Event)) error {
+	a.turn.Lock()
+	defer a.turn.Unlock()
+
+	if a.cp != nil {
+		a.cp.nextTurn()
+	}
+
+	a.conv.Add(msg)
+
+	// Recalling is a bonus, a turn isn't failed because the embedding model
+	// is unavailable.
+	var recalled string
+	if a.memories != nil {
+		recalled, _ = a.memories.relevant(ctx, msg.Content)
+	}
+
+	var total client.Usage
+
+	for step := 0; ; step++ {
+		if a.maxSteps > 0 && step == a.maxSteps {
+			emit(Event{Kind: EventError, Usage: &total, Reason: "max_steps", Err: ErrMaxSteps})
+			return ErrMaxSteps
+		}
+
+		if a.budget > 0 && total.TotalTokens >= a.budget {
+			emit(Event{Kind: EventError, Usage: &total, Reason: "token_budget", Err: ErrTokenBudget})
+			return ErrTokenBudget
+		}
+
+		msg, usage, reason, err := a.stream(ctx, recalled, emit)
+
+		if msg.Content != "" || msg.Reasoning != "" || len(msg.ToolCalls) > 0 {
+			a.conv.Add(msg)
+		}
+
+		if usage != nil {
+			a.record(*usage)
+			addUsage(&total, *usage)
+		}
+
+		if err != nil {
+			emit(Event{Kind: EventError, Usage: &total, Err: err})
+			return err
+		}
+
+		if len(msg.ToolCalls) == 0 {
+			emit(Event{Kind: EventDone, Usage: &total, Reason: reason})
+			return nil
+		}
+
+		// Every call gets a result, even when canceled, so the history stays
+		// valid for the next turn.
+		for _, tc := range msg.ToolCalls {
+			emit(Event{Kind: EventToolCall, ToolCall: &tc})
+
+			result, err := a.call(ctx, tc)
+			emit(Event{Kind: EventToolResult, ToolCall: &tc, Text: result, Err: err})
+
+			if err != nil {
+				result = "error: " + err.Error()
+			}
+
+			a.conv.Add(Message{
+				Role:       RoleTool,
+				Content:    result,
+				ToolCallID: tc.ID,
+			})
+		}
+	}
+}
+
+func (a *Agent) addTools(tools ...Tool) {
+	for _, t := range tools {
+		a.tools[t.Name] = t
+	}
+
+	a.defs = a.defs[:0]
+	for _, name := range slices.Sorted(maps.Keys(a.tools)) {
+		a.defs = append(a.defs, a.tools[name].D())
+	}
+}
+
+// toolDefs returns the definitions of the tools offered to the model, only
+// the read-only ones while planning.
+func (a *Agent) toolDefs(planning bool) []client.D {
+	if !planning {
+		return a.defs
+	}
+
+	var defs []client.D
+	for _, name := range slices.Sorted(maps.Keys(a.tools)) {
+		if t := a.tools[name]; t.ReadOnly {
+			defs = append(defs, t.D())
+		}
+	}
+
+	return defs
+}
+
+// call runs the tool the model asked for.
+func (a *Agent) call(ctx context.Context, tc client.ToolCall) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	tool, exists := a.tools[tc.Function.Name]
+	if !exists {
+		return "", fmt.Errorf("unknown tool %q", tc.Function.Name)
+	}
+
+	if !tool.ReadOnly && a.Planning() {
+		return "", fmt.Errorf("%w: %s changes things and the agent is planning", ErrDenied, tc.Function.Name)
+	}
+
+	args, approved, err := a.runPreHooks(ctx, tc)
+	if err != nil {
+		return "", err
+	}
+
+	// The policy and the user judge the call that will actually run.
+	tc.Function.Arguments = args
+
+	if a.policy != nil {
+		pctx := ctx
+		if approved {
+			pctx = context.WithValue(ctx, hookApprovedKey{}, true)
+		}
+
+		if err := a.policy(pctx, tc); err != nil {
+			return "", err
+		}
+	}
+
+	ctx, end := telemetry.StartTool(ctx, tc.Function.Name, tc.ID)
+	result, err := tool.Run(ctx, args)
+	end(err)
+
+	return a.runPostHooks(ctx, tc, result, err)
+}
+
+// stream sends the conversation, with the memories recalled for the turn,
+// and assembles the answer with client.CollectWith, emitting the chunks as
+// they arrive.
+func (a *Agent) stream(ctx context.Context, recalled string, emit func(Event)) (Message, *client.Usage, string, error) {
+	a.mu.Lock()
+	llm := a.llm
+	system := a.system
+	planning := a.planning
+	a.mu.Unlock()
+
+	// The memories and the plan aren't part of the history. The memories
+	// are background like the system prompt and go next to it, the plan is
+	// added at the end of every request in its latest version.
+	var messages []client.D
+	if system != "" {
+		messages = append(messages, client.D{"role": RoleSystem, "content": system})
+	}
+	if recalled != "" {
+		messages = append(messages, client.D{"role": RoleSystem, "content": recalled})
+	}
+	messages = append(messages, a.conv.D()...)
+
+	switch {
+	case planning:
+		messages = append(messages, client.D{"role": RoleSystem, "content": planningPrompt})
+	case a.plan != nil && a.plan.Approved():
+		if plan := a.plan.Render(); plan != "" {
+			messages = append(messages, client.D{"role": RoleSystem, "content": executingPrompt + "\n\n" + plan})
+		}
+	}
+
+	options := append([]client.Option{client.WithConversation(messages)}, a.params...)
+	if defs := a.toolDefs(planning); len(defs) > 0 {
+		options = append(options, client.WithTools(defs...))
+	}
+
+	ch, err := llm.ChatCompletionsSSE(ctx, "", options...)
+	if err != nil {
+		return Message{}, nil, "", fmt.Errorf("chat: %w", err)
+	}
+
+	result, err := client.CollectWith(ch, func(delta client.ChatDeltaSSE) {
+		if delta.Reasoning != "" {
+			emit(Event{Kind: EventReasoning, Text: delta.Reasoning})
+		}
+
+		if delta.Content != "" {
+			emit(Event{Kind: EventContent, Text: delta.Content})
+		}
+	})
+
+	// The channel is closed without an error when the context is canceled,
+	// which can leave half a tool call that fails to parse.
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	msg := Message{
+		Role:      RoleAssistant,
+		Content:   result.Content,
+		Reasoning: result.Reasoning,
+	}
+
+	// A canceled answer may hold half a tool call, which can't be replayed.
+	if err == nil {
+		for i, tc := range result.ToolCalls {
+			if tc.ID == "" {
+				tc.ID = fmt.Sprintf("call_%d", i)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+	}
+
+	return msg, result.Usage, result.FinishReason, err
+}
+
+func (a *Agent) record(u client.Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastUsage = u
+	addUsage(&a.usage, u)
+}
+
+// addSessionUsage counts the tokens used on behalf of the agent, like by its
+// sub-agents, without touching the size of its own context.
+func (a *Agent) addSessionUsage(u client.Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	addUsage(&a.usage, u)
+}
+
+func addUsage(total *client.Usage, u client.Usage) {
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.TotalTokens += u.TotalTokens
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chatServer is a chat completions server answering with the streams it's
+// given, one per request, and keeping the requests it received.
+type chatServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []client.D
+	streams  [][]string
+}
+
+func newChatServer(t *testing.T, streams ...[]string) *chatServer {
+	t.Helper()
+
+	s := chatServer{streams: streams}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	return &s
+}
+
+func (s *chatServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/chat/completions" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body client.D
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, body)
+	stream := []string{`{"choices":[{"index":0,"delta":{"content":"done"},"finish_reason":"stop"}]}`}
+	if len(s.streams) > 0 {
+		stream, s.streams = s.streams[0], s.streams[1:]
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range stream {
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// messages returns the messages of the nth request as role: content lines.
+func (s *chatServer) messages(n int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	msgs, _ := s.requests[n]["messages"].([]any)
+	for _, m := range msgs {
+		m, _ := m.(map[string]any)
+		fmt.Fprintf(&b, "%s: %v\n", m["role"], m["content"])
+	}
+
+	return b.String()
+}
+
+func (s *chatServer) llm() *client.LLM {
+	// The server doesn't report capabilities, which is warned about.
+	return client.NewLLM(s.URL+"/v1/chat/completions", "test", client.WithClientOptions(client.WithSlog(nil)))
+}
+
+func turn(t *testing.T, a *Agent, input string) []Event {
+	t.Helper()
+
+	var events []Event
+	if err := a.Turn(context.Background(), input, func(e Event) { events = append(events, e) }); err != nil {
+		t.Fatalf("turn: %s", err)
+	}
+
+	return events
+}
+
+// =============================================================================
+
+func TestFragmentedToolCall(t *testing.T) {
+	srv := newChatServer(t, []string{
+		`{"choices":[{"index":0,"delta":{"content":"Reading."}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"echo","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"te"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"xt\":\"hi\"}"}}]},"finish_reason":"tool_calls"}]}`,
+	})
+
+	var got string
+	echo := Tool{
+		Name:       "echo",
+		ReadOnly:   true,
+		Parameters: object(client.D{"text": str("The text.")}, "text"),
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			got = argString(args, "text")
+			return got, nil
+		},
+	}
+
+	a := New(srv.llm(), WithTools(echo))
+
+	var content string
+	for _, e := range turn(t, a, "Say hi.") {
+		if e.Kind == EventContent {
+			content += e.Text
+		}
+	}
+
+	if got != "hi" {
+		t.Errorf("the tool got %q, want the joined arguments", got)
+	}
+
+	if content != "Reading.done" {
+		t.Errorf("got content %q, want every chunk emitted", content)
+	}
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCheckpoint is returned when there is nothing to undo.
+var ErrNoCheckpoint = errors.New("no checkpoint to undo")
+
+// Checkpoint records the content and mode a file had before a tool changed
+// it.
+type Checkpoint struct {
+	Turn    int
+	Tool    string
+	Path    string
+	Existed bool
+	Before  []byte
+	Mode    os.FileMode
+	Time    time.Time
+}
+
+// Checkpoints is the snapshot store of the files the tools changed. It's
+// kept in memory only: the checkpoints are lost when the session ends, so
+// the changes of an earlier session can't be undone. Files changed by
+// commands the model runs aren't recorded.
+type Checkpoints struct {
+	root string
+
+	mu   sync.Mutex
+	turn int
+	list []Checkpoint
+}
+
+// NewCheckpoints constructs a store for the files under root.
+func NewCheckpoints(root string) *Checkpoints {
+	return &Checkpoints{
+		root: root,
+	}
+}
+
+// Turn returns the number of the current turn, starting at 1.
+func (cp *Checkpoints) Turn() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.turn
+}
+
+// List returns the checkpoints from the oldest to the newest.
+func (cp *Checkpoints) List() []Checkpoint {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return slices.Clone(cp.list)
+}
+
+// Diff returns the changes made by the tools to every file since its first
+// checkpoint, as a unified diff.
+func (cp *Checkpoints) Diff() (string, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	var b strings.Builder
+	for _, c := range cp.originals(0) {
+		after, err := os.ReadFile(filepath.Join(cp.root, c.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("read: %w", err)
+		}
+
+		b.WriteString(unifiedDiff(c.Path, string(c.Before), string(after)))
+	}
+
+	return b.String(), nil
+}
+
+// Undo reverts the last n changes, newest first, and returns the files it
+// restored.
+func (cp *Checkpoints) Undo(n int) ([]string, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if len(cp.list) == 0 {
+		return nil, ErrNoCheckpoint
+	}
+
+	n = min(n, len(cp.list))
+	undo := cp.list[len(cp.list)-n:]
+
+	var paths []string
+	for _, c := range slices.Backward(undo) {
+		if err := cp.restore(c); err != nil {
+			return paths, err
+		}
+		paths = append(paths, c.Path)
+		cp.list = cp.list[:len(cp.list)-1]
+	}
+
+	return paths, nil
+}
+
+// RestoreTurn puts the files back the way they were before the turn started
+// and returns the files it restored.
+func (cp *Checkpoints) RestoreTurn(turn int) ([]string, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	idx := slices.IndexFunc(cp.list, func(c Checkpoint) bool { return c.Turn >= turn })
+	if idx == -1 {
+		return nil, fmt.Errorf("no changes since turn %d", turn)
+	}
+
+	var paths []string
+	for _, c := range cp.originals(idx) {
+		if err := cp.restore(c); err != nil {
+			return paths, err
+		}
+		paths = append(paths, c.Path)
+	}
+
+	cp.list = cp.list[:idx]
+
+	return paths, nil
+}
+
+// =============================================================================
+
+// nextTurn is called by the agent when a turn starts.
+func (cp *Checkpoints) nextTurn() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.turn++
+}
+
+// record saves the content of the file before the tool changes it.
+func (cp *Checkpoints) record(tool string, path string) error {
+	rel, err := filepath.Rel(cp.root, path)
+	if err != nil {
+		return fmt.Errorf("rel: %w", err)
+	}
+
+	var mode os.FileMode
+	info, err := os.Stat(path)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.list = append(cp.list, Checkpoint{
+		Turn:    cp.turn,
+		Tool:    tool,
+		Path:    rel,
+		Existed: err == nil,
+		Before:  data,
+		Mode:    mode,
+		Time:    time.Now(),
+	})
+
+	return nil
+}
+
+// originals returns the oldest checkpoint of every file from the index on,
+// which holds the content the file had at that point.
+func (cp *Checkpoints) originals(from int) []Checkpoint {
+	seen := make(map[string]bool)
+
+	var list []Checkpoint
+	for _, c := range cp.list[from:] {
+		if !seen[c.Path] {
+			seen[c.Path] = true
+			list = append(list, c)
+		}
+	}
+
+	return list
+}
+
+func (cp *Checkpoints) restore(c Checkpoint) error {
+	path := filepath.Join(cp.root, c.Path)
+
+	if !c.Existed {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("restore %s: %w", c.Path, err)
+		}
+		return nil
+	}
+
+	if err := os.WriteFile(path, c.Before, c.Mode); err != nil {
+		return fmt.Errorf("restore %s: %w", c.Path, err)
+	}
+
+	// WriteFile only sets the mode of a file it creates.
+	if err := os.Chmod(path, c.Mode); err != nil {
+		return fmt.Errorf("restore %s: %w", c.Path, err)
+	}
+
+	return nil
+}
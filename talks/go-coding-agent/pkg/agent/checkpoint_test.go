@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// edit changes the file the way a tool does, recording it first.
+func edit(t *testing.T, cp *Checkpoints, path string, content string) {
+	t.Helper()
+
+	if err := cp.record("write_file", path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestUndo(t *testing.T) {
+	root := t.TempDir()
+	cp := NewCheckpoints(root)
+	cp.nextTurn()
+
+	path := filepath.Join(root, "main.go")
+	if err := os.WriteFile(path, []byte("v1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	edit(t, cp, path, "v2\n")
+	edit(t, cp, path, "v3\n")
+
+	created := filepath.Join(root, "new.go")
+	edit(t, cp, created, "new\n")
+
+	paths, err := cp.Undo(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 2 || paths[0] != "new.go" || paths[1] != "main.go" {
+		t.Errorf("got restored %v, want newest first", paths)
+	}
+
+	if got := read(t, path); got != "v2\n" {
+		t.Errorf("got %q, want the content before the last edit", got)
+	}
+
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("the file created by the tool should be removed, got %v", err)
+	}
+
+	if _, err := cp.Undo(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cp.Undo(1); !errors.Is(err, ErrNoCheckpoint) {
+		t.Errorf("got %v, want ErrNoCheckpoint", err)
+	}
+}
+
+func TestRestoreKeepsMode(t *testing.T) {
+	root := t.TempDir()
+	cp := NewCheckpoints(root)
+
+	path := filepath.Join(root, "run.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tool writes the file back with another mode.
+	if err := cp.record("write_file", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("echo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cp.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0o755 {
+		t.Errorf("got mode %v, want %v", mode, os.FileMode(0o755))
+	}
+}
+
+func TestRestoreTurn(t *testing.T) {
+	root := t.TempDir()
+	cp := NewCheckpoints(root)
+
+	a := filepath.Join(root, "a.txt")
+	b := filepath.Join(root, "b.txt")
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte("original\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cp.nextTurn()
+	edit(t, cp, a, "turn 1\n")
+
+	cp.nextTurn()
+	edit(t, cp, a, "turn 2\n")
+	edit(t, cp, b, "turn 2\n")
+
+	cp.nextTurn()
+	edit(t, cp, a, "turn 3\n")
+
+	if _, err := cp.RestoreTurn(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := read(t, a)+read(t, b), "turn 1\noriginal\n"; got != want {
+		t.Errorf("got %q, want the files as they were before turn 2", got)
+	}
+
+	if n := len(cp.List()); n != 1 {
+		t.Errorf("got %d checkpoints, want the one of turn 1 left", n)
+	}
+
+	if _, err := cp.RestoreTurn(2); err == nil {
+		t.Error("expected an error for a turn without changes")
+	}
+}
+
+func TestCheckpointDiff(t *testing.T) {
+	root := t.TempDir()
+	cp := NewCheckpoints(root)
+
+	path := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(path, []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	edit(t, cp, path, "two\n")
+	edit(t, cp, path, "three\n")
+	edit(t, cp, filepath.Join(root, "b.txt"), "new\n")
+
+	got, err := cp.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+three\n" +
+		"--- a/b.txt\n+++ b/b.txt\n@@ -0,0 +1 @@\n+new\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if strings.Contains(got, "two") {
+		t.Error("the diff should be against the first checkpoint of the file")
+	}
+}
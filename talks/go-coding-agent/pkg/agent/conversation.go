@@ -0,0 +1,125 @@
+// Package agent provides support for running a coding agent on top of an
+// OpenAI-compatible chat API.
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-coding-agent/pkg/client"
+)
+
+// Set of roles a message can have.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message is a single message in a conversation. The reasoning the model
+// produced is kept apart from the content so it can be displayed and
+// replayed independently.
+type Message struct {
+	Role       string            `json:"role"`
+	Content    string            `json:"content,omitempty"`
+	Reasoning  string            `json:"reasoning,omitempty"`
+	ToolCalls  []client.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	Images     []Image           `json:"images,omitempty"`
+}
+
+// Conversation is the ordered history of messages exchanged with the model.
+type Conversation struct {
+	Messages []Message `json:"messages"`
+
+	// KeepReasoning sends the reasoning of earlier assistant messages back
+	// to the server. By default it's stripped, which saves context and is
+	// what most models are trained to expect.
+	KeepReasoning bool `json:"keep_reasoning"`
+}
+
+// Add appends the messages to the conversation.
+func (c *Conversation) Add(msgs ...Message) {
+	c.Messages = append(c.Messages, msgs...)
+}
+
+// Reset removes every message.
+func (c *Conversation) Reset() {
+	c.Messages = nil
+}
+
+// D converts the conversation into the messages sent to the server.
+func (c *Conversation) D() []client.D {
+	msgs := make([]client.D, 0, len(c.Messages))
+	for _, m := range c.Messages {
+		d := client.D{
+			"role":    m.Role,
+			"content": m.Content,
+		}
+
+		if len(m.Images) > 0 {
+			parts := []client.D{{"type": "text", "text": m.Content}}
+			for _, img := range m.Images {
+				parts = append(parts, client.ImagePart(img.MIMEType, img.Data))
+			}
+			d["content"] = parts
+		}
+
+		if c.KeepReasoning && m.Reasoning != "" {
+			d["reasoning"] = m.Reasoning
+		}
+
+		if len(m.ToolCalls) > 0 {
+			calls := make([]client.D, len(m.ToolCalls))
+			for i, tc := range m.ToolCalls {
+				args, err := json.Marshal(tc.Function.Arguments)
+				if err != nil {
+					args = []byte("{}")
+				}
+
+				calls[i] = client.D{
+					"id":   tc.ID,
+					"type": "function",
+					"function": client.D{
+						"name":      tc.Function.Name,
+						"arguments": string(args),
+					},
+				}
+			}
+			d["tool_calls"] = calls
+		}
+
+		if m.ToolCallID != "" {
+			d["tool_call_id"] = m.ToolCallID
+		}
+
+		msgs = append(msgs, d)
+	}
+
+	return msgs
+}
+
+// =============================================================================
+
+// ReasoningDisplay decides how the model's reasoning is shown to the user.
+type ReasoningDisplay string
+
+// Set of reasoning display modes.
+const (
+	ReasoningHidden    ReasoningDisplay = "hidden"
+	ReasoningCollapsed ReasoningDisplay = "collapsed"
+	ReasoningFull      ReasoningDisplay = "full"
+)
+
+// ParseReasoningDisplay parses the display mode, defaulting to full when the
+// string is empty.
+func ParseReasoningDisplay(s string) (ReasoningDisplay, error) {
+	switch ReasoningDisplay(s) {
+	case "":
+		return ReasoningFull, nil
+	case ReasoningHidden, ReasoningCollapsed, ReasoningFull:
+		return ReasoningDisplay(s), nil
+	}
+
+	return "", fmt.Errorf("unknown reasoning display %q, use hidden, collapsed or full", s)
+}
@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DelegateToolName is the name of the tool that runs sub-agents.
+const DelegateToolName = "delegate_task"
+
+// delegatePrompt is the system prompt of the sub-agents.
+const delegatePrompt = `You are a sub-agent working on a single task handed over by another agent,
+which only sees your final answer. Use the tools to complete the task, then
+answer with a short summary of what you did and found: the files involved,
+the changes made and anything left undone. Don't ask questions, nobody will
+answer them.`
+
+// DelegateConfig sets the limits of the sub-agents started by delegate_task.
+type DelegateConfig struct {
+	// Tools the sub-agents can be given, the tools of the parent when nil.
+	// The sub-agents can never delegate themselves nor touch the plan.
+	Tools []Tool
+
+	// MaxTokens is the token budget of every sub-agent, 50000 by default.
+	MaxTokens int
+
+	// MaxSteps limits the requests of every sub-agent, 15 by default.
+	MaxSteps int
+
+	// MaxParallel is the number of sub-agents running at once, 4 by default.
+	// The sub-agents given a tool that isn't read-only run one at a time,
+	// since they share the workspace.
+	MaxParallel int
+}
+
+// WithDelegation adds the delegate_task tool, which hands tasks to sub-agents
+// with their own conversation so the parent only gets their summaries.
+func WithDelegation(cfg DelegateConfig) func(a *Agent) {
+	return func(a *Agent) {
+		a.delegate = &cfg
+	}
+}
+
+// =============================================================================
+
+// delegateTool builds the tool once the options of the agent are applied.
+func (a *Agent) delegateTool() Tool {
+	cfg := *a.delegate
+
+	if cfg.Tools == nil {
+		cfg.Tools = slices.Collect(maps.Values(a.tools))
+	}
+	cfg.Tools = slices.DeleteFunc(slices.Clone(cfg.Tools), func(t Tool) bool {
+		return t.Name == DelegateToolName || t.Name == PlanToolName
+	})
+	slices.SortFunc(cfg.Tools, func(x, y Tool) int { return strings.Compare(x.Name, y.Name) })
+
+	if cfg.MaxTokens == 0 {
+		cfg.MaxTokens = 50_000
+	}
+	if cfg.MaxSteps == 0 {
+		cfg.MaxSteps = 15
+	}
+	if cfg.MaxParallel == 0 {
+		cfg.MaxParallel = 4
+	}
+
+	names := make([]string, len(cfg.Tools))
+	for i, t := range cfg.Tools {
+		names[i] = t.Name
+	}
+
+	return Tool{
+		Name: DelegateToolName,
+		Description: "Hand self-contained tasks to sub-agents that work with their own context and report back a summary. " +
+			"Use it for searches or changes that would need many tool calls. Read-only tasks run in parallel, " +
+			"tasks that can change files run one after the other.",
+		Parameters: object(client.D{
+			"tasks": client.D{
+				"type":        "array",
+				"description": "The tasks to delegate.",
+				"items": object(client.D{
+					"task": str("What the sub-agent must do, with all the context it needs since it can't see this conversation."),
+					"tools": client.D{
+						"type":        "array",
+						"description": "The tools the sub-agent may use, all of them when empty.",
+						"items":       client.D{"type": "string", "enum": names},
+					},
+				}, "task"),
+			},
+		}, "tasks"),
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return a.runDelegates(ctx, cfg, args)
+		},
+	}
+}
+
+type delegateTask struct {
+	task  string
+	tools []Tool
+}
+
+// writes reports whether the sub-agent can change the workspace, having a
+// tool that isn't read-only.
+func (t delegateTask) writes() bool {
+	return slices.ContainsFunc(t.tools, func(tool Tool) bool { return !tool.ReadOnly })
+}
+
+type delegateResult struct {
+	summary string
+	usage   client.Usage
+	err     error
+}
+
+func (a *Agent) runDelegates(ctx context.Context, cfg DelegateConfig, args map[string]any) (string, error) {
+	tasks, err := parseTasks(cfg.Tools, args)
+	if err != nil {
+		return "", err
+	}
+
+	results := make([]delegateResult, len(tasks))
+	sem := make(chan struct{}, cfg.MaxParallel)
+
+	// The sub-agents that can change files take turns, so two of them never
+	// edit the same file at once. The read-only ones run alongside.
+	writer := make(chan struct{}, 1)
+
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Go(func() {
+			if t.writes() {
+				select {
+				case writer <- struct{}{}:
+					defer func() { <-writer }()
+				case <-ctx.Done():
+					results[i].err = ctx.Err()
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+
+			results[i] = a.runDelegate(ctx, cfg, t)
+		})
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	for i, r := range results {
+		a.addSessionUsage(r.usage)
+
+		fmt.Fprintf(&b, "## Task %d (%d tokens)\n\n", i+1, r.usage.TotalTokens)
+		if r.summary != "" {
+			b.WriteString(r.summary)
+			b.WriteString("\n\n")
+		}
+		if r.err != nil {
+			fmt.Fprintf(&b, "The sub-agent stopped early: %s\n\n", r.err)
+		}
+	}
+
+	return strings.TrimSpace(b.String()), ctx.Err()
+}
+
+// runDelegate runs a sub-agent to completion and keeps its last answer.
+func (a *Agent) runDelegate(ctx context.Context, cfg DelegateConfig, t delegateTask) delegateResult {
+	a.mu.Lock()
+	llm := a.llm
+	a.mu.Unlock()
+
+	child := New(llm,
+		WithLLMOptions(a.params...),
+		WithSystemPrompt(delegatePrompt),
+		WithTools(t.tools...),
+		WithMaxSteps(cfg.MaxSteps),
+		WithTokenBudget(cfg.MaxTokens),
+		WithPolicy(a.policy),
+		WithPreToolHooks(a.preHooks...),
+		WithPostToolHooks(a.postHooks...),
+	)
+
+	var answer strings.Builder
+	var usage client.Usage
+
+	err := child.Turn(ctx, t.task, func(e Event) {
+		switch e.Kind {
+		case EventContent:
+			answer.WriteString(e.Text)
+		case EventToolCall:
+			answer.Reset()
+		case EventDone, EventError:
+			if e.Usage != nil {
+				usage = *e.Usage
+			}
+		}
+	})
+
+	return delegateResult{
+		summary: strings.TrimSpace(answer.String()),
+		usage:   usage,
+		err:     err,
+	}
+}
+
+func parseTasks(available []Tool, args map[string]any) ([]delegateTask, error) {
+	list, _ := args["tasks"].([]any)
+	if len(list) == 0 {
+		return nil, errors.New("no tasks given")
+	}
+
+	var tasks []delegateTask
+	for i, v := range list {
+		m, _ := v.(map[string]any)
+
+		task := delegateTask{
+			task:  strings.TrimSpace(argString(m, "task")),
+			tools: available,
+		}
+		if task.task == "" {
+			return nil, fmt.Errorf("task %d has no description", i+1)
+		}
+
+		if names, _ := m["tools"].([]any); len(names) > 0 {
+			task.tools = nil
+			for _, n := range names {
+				name, _ := n.(string)
+
+				idx := slices.IndexFunc(available, func(t Tool) bool { return t.Name == name })
+				if idx == -1 {
+					return nil, fmt.Errorf("task %d: tool %q isn't available to sub-agents", i+1, name)
+				}
+				task.tools = append(task.tools, available[idx])
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
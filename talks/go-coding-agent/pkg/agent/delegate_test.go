@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrency is a tool counting how many calls to it run at once.
+type concurrency struct {
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (c *concurrency) tool(name string, readOnly bool) Tool {
+	return Tool{
+		Name:       name,
+		ReadOnly:   readOnly,
+		Parameters: object(client.D{}),
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			c.mu.Lock()
+			c.running++
+			c.most = max(c.most, c.running)
+			c.mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			c.mu.Lock()
+			c.running--
+			c.mu.Unlock()
+
+			return "ok", nil
+		},
+	}
+}
+
+// delegateServer answers a sub-agent's first request with a call to the tool
+// named in its task and the next one with a summary.
+func delegateServer(t *testing.T) *client.LLM {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var body struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content any    `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		chunk := `{"choices":[{"index":0,"delta":{"content":"done"},"finish_reason":"stop"}]}`
+
+		last := body.Messages[len(body.Messages)-1]
+		if task, _ := last.Content.(string); last.Role == "user" {
+			name := strings.Fields(task)[1]
+			chunk = fmt.Sprintf(`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":%q,"arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`, name)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+	}))
+	t.Cleanup(srv.Close)
+
+	return client.NewLLM(srv.URL+"/v1/chat/completions", "test", client.WithClientOptions(client.WithSlog(nil)))
+}
+
+func TestDelegatesWritingTakeTurns(t *testing.T) {
+	var writes, reads concurrency
+
+	a := New(delegateServer(t),
+		WithTools(writes.tool("edit", false), reads.tool("search", true)),
+		WithDelegation(DelegateConfig{}),
+	)
+
+	task := func(tool string) map[string]any {
+		return map[string]any{"task": "call " + tool + " once", "tools": []any{tool}}
+	}
+
+	args := map[string]any{
+		"tasks": []any{task("edit"), task("search"), task("edit"), task("search"), task("edit")},
+	}
+
+	out, err := a.delegateTool().Run(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(out, "done"); n != 5 {
+		t.Errorf("got %d summaries, want 5:\n%s", n, out)
+	}
+
+	if writes.most != 1 {
+		t.Errorf("got %d writing sub-agents at once, want 1", writes.most)
+	}
+
+	if reads.most != 2 {
+		t.Errorf("got %d read-only sub-agents at once, want 2", reads.most)
+	}
+}
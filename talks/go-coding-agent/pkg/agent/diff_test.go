@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{
+			name:   "equal",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+		},
+		{
+			name:   "change",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			after:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want:   "--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:   "new file",
+			before: "",
+			after:  "a\nb\n",
+			want:   "--- a/f\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:   "deleted file",
+			before: "a\n",
+			after:  "",
+			want:   "--- a/f\n+++ b/f\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:   "newline added",
+			before: "a\nb",
+			after:  "a\nb\n",
+			want:   "--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:   "append without newline",
+			before: "a\n",
+			after:  "a\nb",
+			want:   "--- a/f\n+++ b/f\n@@ -1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:   "hunks merged",
+			before: "1\n2\n3\n4\n5\n6\n7\n",
+			after:  "one\n2\n3\n4\n5\n6\nseven\n",
+			want:   "--- a/f\n+++ b/f\n@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f", tt.before, tt.after); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	var before, after strings.Builder
+	for i := range 20 {
+		line := string(rune('a' + i))
+		before.WriteString(line + "\n")
+		if i == 1 || i == 17 {
+			line = strings.ToUpper(line)
+		}
+		after.WriteString(line + "\n")
+	}
+
+	got := unifiedDiff("f", before.String(), after.String())
+
+	if n := strings.Count(got, "@@ -"); n != 2 {
+		t.Errorf("got %d hunks, want 2 for changes far apart:\n%s", n, got)
+	}
+
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@\n") || !strings.Contains(got, "@@ -15,6 +15,6 @@\n") {
+		t.Errorf("got wrong ranges:\n%s", got)
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitTools returns read-only tools over the git repository holding root.
+func GitTools(root string) []Tool {
+	g := gitRepo{root: root}
+
+	return []Tool{
+		{
+			Name:        "git_status",
+			ReadOnly:    true,
+			Description: "Show the branch and the changed, staged and untracked files of the git repository.",
+			Parameters:  object(client.D{}),
+			Run:         g.status,
+		},
+		{
+			Name:        "git_diff",
+			ReadOnly:    true,
+			Description: "Show the uncommitted changes of the git repository, or the staged ones.",
+			Parameters: object(client.D{
+				"path":   str("Limit the diff to this path."),
+				"staged": client.D{"type": "boolean", "description": "Show the staged changes instead of the unstaged ones."},
+			}),
+			Run: g.diff,
+		},
+		{
+			Name:        "git_log",
+			ReadOnly:    true,
+			Description: "Show the latest commits of the git repository.",
+			Parameters: object(client.D{
+				"path":  str("Limit the log to the commits touching this path."),
+				"count": client.D{"type": "integer", "description": "The number of commits to show, 10 by default."},
+			}),
+			Run: g.log,
+		},
+	}
+}
+
+// =============================================================================
+
+type gitRepo struct {
+	root string
+}
+
+func (g gitRepo) status(ctx context.Context, args map[string]any) (string, error) {
+	return g.run(ctx, "status", "--short", "--branch")
+}
+
+func (g gitRepo) diff(ctx context.Context, args map[string]any) (string, error) {
+	gitArgs := []string{"diff", "--no-color", "--no-ext-diff", "--no-textconv"}
+	if staged, _ := args["staged"].(bool); staged {
+		gitArgs = append(gitArgs, "--staged")
+	}
+
+	if path := argString(args, "path"); path != "" {
+		gitArgs = append(gitArgs, "--", path)
+	}
+
+	out, err := g.run(ctx, gitArgs...)
+	if err == nil && out == "" {
+		return "no changes", nil
+	}
+
+	return out, err
+}
+
+func (g gitRepo) log(ctx context.Context, args map[string]any) (string, error) {
+	count := argInt(args, "count")
+	if count <= 0 {
+		count = 10
+	}
+
+	gitArgs := []string{"log", "--no-color", "-n", strconv.Itoa(min(count, 100)), "--format=%h %ad %an%n    %s", "--date=short"}
+	if path := argString(args, "path"); path != "" {
+		gitArgs = append(gitArgs, "--", path)
+	}
+
+	return g.run(ctx, gitArgs...)
+}
+
+func (g gitRepo) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.root
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	return truncate(stdout.String()), nil
+}
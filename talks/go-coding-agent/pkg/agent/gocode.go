@@ -0,0 +1,601 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// maxResults caps the number of locations or errors a Go tool returns.
+const maxResults = 50
+
+// GoTools returns tools that answer questions about the Go code under root by
+// loading and type-checking it, without building it.
+func GoTools(root string) []Tool {
+	w := &goWorkspace{root: root}
+
+	position := func(what string) client.D {
+		return client.D{
+			"file": str("The Go file, relative to the working directory."),
+			"line": client.D{"type": "integer", "description": "The line, starting at 1."},
+			"name": str(what),
+		}
+	}
+
+	return []Tool{
+		{
+			Name:        "go_definition",
+			ReadOnly:    true,
+			Description: "Find where the Go identifier used on a line is declared and show its declaration.",
+			Parameters:  object(position("The identifier on the line."), "file", "line", "name"),
+			Run:         w.definition,
+		},
+		{
+			Name:        "go_references",
+			ReadOnly:    true,
+			Description: "Find every use of the Go identifier declared or used on a line, across the workspace.",
+			Parameters:  object(position("The identifier on the line."), "file", "line", "name"),
+			Run:         w.references,
+		},
+		{
+			Name:        "go_symbols",
+			ReadOnly:    true,
+			Description: "List the package level declarations of a Go package with their signatures.",
+			Parameters: object(client.D{
+				"package": str("The directory of the package relative to the working directory, or its import path."),
+			}, "package"),
+			Run: w.symbols,
+		},
+		{
+			Name:        "go_type",
+			ReadOnly:    true,
+			Description: "Show the type of a Go expression written on a line.",
+			Parameters:  object(position("The expression exactly as written on the line, like cfg.Endpoints or x."), "file", "line", "name"),
+			Run:         w.typeOf,
+		},
+		{
+			Name:        "go_errors",
+			ReadOnly:    true,
+			Description: "Type-check the Go packages and list the compile errors, without building.",
+			Parameters: object(client.D{
+				"pattern": str("The packages to check, ./... by default."),
+			}),
+			Run: w.errors,
+		},
+	}
+}
+
+// =============================================================================
+
+// goWorkspace keeps the loaded packages until a Go file changes.
+type goWorkspace struct {
+	root string
+
+	mu          sync.Mutex
+	fingerprint string
+	pkgs        []*packages.Package
+	fset        *token.FileSet
+	dirs        map[string]dirListing
+}
+
+// dirListing is the Go files and subdirectories of a directory, valid as
+// long as the modification time of the directory doesn't change.
+type dirListing struct {
+	modTime time.Time
+	files   []string
+	dirs    []string
+}
+
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+	packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports
+
+// load returns the packages of the workspace, type-checked from source.
+func (w *goWorkspace) load(ctx context.Context) ([]*packages.Package, *token.FileSet, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	fp, err := w.fingerprintFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if w.pkgs != nil && fp == w.fingerprint {
+		return w.pkgs, w.fset, nil
+	}
+
+	pkgs, fset, err := w.loadPattern(ctx, "./...")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.pkgs, w.fset, w.fingerprint = pkgs, fset, fp
+
+	return pkgs, fset, nil
+}
+
+func (w *goWorkspace) loadPattern(ctx context.Context, pattern string) ([]*packages.Package, *token.FileSet, error) {
+	fset := token.NewFileSet()
+
+	cfg := packages.Config{
+		Mode:    loadMode,
+		Context: ctx,
+		Dir:     w.root,
+		Fset:    fset,
+		Tests:   true,
+	}
+
+	pkgs, err := packages.Load(&cfg, pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load: %w", err)
+	}
+
+	return pkgs, fset, nil
+}
+
+// fingerprintFiles summarizes the size and modification time of every Go
+// file, which is much cheaper than loading the packages again. The listings
+// of the directories are kept, only the directories changed since they were
+// listed are read again. The caller holds the lock.
+func (w *goWorkspace) fingerprintFiles() (string, error) {
+	if w.dirs == nil {
+		w.dirs = make(map[string]dirListing)
+	}
+
+	var b strings.Builder
+	seen := make(map[string]bool)
+
+	if err := w.fingerprintDir(w.root, &b, seen); err != nil {
+		return "", fmt.Errorf("walk: %w", err)
+	}
+
+	// Forget the directories that are gone.
+	maps.DeleteFunc(w.dirs, func(dir string, _ dirListing) bool { return !seen[dir] })
+
+	return b.String(), nil
+}
+
+func (w *goWorkspace) fingerprintDir(dir string, b *strings.Builder, seen map[string]bool) error {
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err) && dir != w.root:
+		return nil
+	case err != nil:
+		return err
+	}
+	seen[dir] = true
+
+	listing, exists := w.dirs[dir]
+	if !exists || !listing.modTime.Equal(info.ModTime()) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		listing = dirListing{modTime: info.ModTime()}
+		for _, e := range entries {
+			name := e.Name()
+			switch {
+			case e.IsDir():
+				if !strings.HasPrefix(name, ".") && name != "vendor" && name != "testdata" {
+					listing.dirs = append(listing.dirs, filepath.Join(dir, name))
+				}
+			case strings.HasSuffix(name, ".go") || name == "go.mod":
+				listing.files = append(listing.files, filepath.Join(dir, name))
+			}
+		}
+
+		w.dirs[dir] = listing
+	}
+
+	// A file changed in place doesn't change the directory, every file is
+	// checked still.
+	for _, file := range listing.files {
+		info, err := os.Stat(file)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		}
+
+		fmt.Fprintf(b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	for _, sub := range listing.dirs {
+		if err := w.fingerprintDir(sub, b, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+func (w *goWorkspace) definition(ctx context.Context, args map[string]any) (string, error) {
+	pkgs, fset, err := w.load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	pkg, ident, err := w.findIdent(pkgs, fset, args)
+	if err != nil {
+		return "", err
+	}
+
+	obj := pkg.TypesInfo.ObjectOf(ident)
+	if obj == nil {
+		return "", fmt.Errorf("%s has no declaration", ident.Name)
+	}
+
+	var b strings.Builder
+	b.WriteString(types.ObjectString(obj, qualifier(pkg.Types)))
+	b.WriteString("\n")
+
+	if !obj.Pos().IsValid() {
+		b.WriteString("declared by the language")
+		return b.String(), nil
+	}
+
+	pos := fset.Position(obj.Pos())
+	fmt.Fprintf(&b, "declared at %s\n", w.location(pos))
+
+	if line := make(sourceFiles).line(pos); line != "" {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+
+	return b.String(), nil
+}
+
+func (w *goWorkspace) references(ctx context.Context, args map[string]any) (string, error) {
+	pkgs, fset, err := w.load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	pkg, ident, err := w.findIdent(pkgs, fset, args)
+	if err != nil {
+		return "", err
+	}
+
+	obj := pkg.TypesInfo.ObjectOf(ident)
+	if obj == nil {
+		return "", fmt.Errorf("%s has no declaration", ident.Name)
+	}
+
+	// Every package sees its own copy of the objects it imports, so the
+	// objects are matched by where they are declared.
+	key := objectKey(fset, obj)
+
+	seen := make(map[string]bool)
+	src := make(sourceFiles)
+	var refs []string
+
+	for _, p := range pkgs {
+		if p.TypesInfo == nil {
+			continue
+		}
+
+		for id, o := range p.TypesInfo.Uses {
+			if o == nil || objectKey(fset, o) != key {
+				continue
+			}
+
+			pos := fset.Position(id.Pos())
+			loc := w.location(pos)
+			if seen[loc] {
+				continue
+			}
+			seen[loc] = true
+
+			refs = append(refs, fmt.Sprintf("%s: %s", loc, src.line(pos)))
+		}
+	}
+
+	if len(refs) == 0 {
+		return fmt.Sprintf("no references to %s", ident.Name), nil
+	}
+
+	slices.Sort(refs)
+
+	return limitLines(refs, fmt.Sprintf("%d references to %s", len(refs), ident.Name)), nil
+}
+
+func (w *goWorkspace) symbols(ctx context.Context, args map[string]any) (string, error) {
+	pkgs, _, err := w.load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	want := argString(args, "package")
+	dir, _ := filepath.Abs(filepath.Join(w.root, want))
+
+	var pkg *packages.Package
+	for _, p := range pkgs {
+		if p.Types == nil || strings.HasSuffix(p.ID, ".test") || strings.Contains(p.ID, " [") {
+			continue
+		}
+
+		if p.PkgPath == want || (len(p.GoFiles) > 0 && filepath.Dir(p.GoFiles[0]) == dir) {
+			pkg = p
+			break
+		}
+	}
+
+	if pkg == nil {
+		return "", fmt.Errorf("package %q not found in the workspace", want)
+	}
+
+	qual := qualifier(pkg.Types)
+	scope := pkg.Types.Scope()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s // %s\n", pkg.Name, pkg.PkgPath)
+
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+
+		tn, ok := obj.(*types.TypeName)
+		if !ok {
+			b.WriteString(types.ObjectString(obj, qual))
+			b.WriteString("\n")
+			continue
+		}
+
+		// Struct fields are listed one per line, without their tags.
+		st, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			b.WriteString(types.ObjectString(obj, qual))
+			b.WriteString("\n")
+		} else {
+			fmt.Fprintf(&b, "type %s struct\n", tn.Name())
+			for f := range st.Fields() {
+				fmt.Fprintf(&b, "    %s %s\n", f.Name(), types.TypeString(f.Type(), qual))
+			}
+		}
+
+		named, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+
+		for m := range named.Methods() {
+			fmt.Fprintf(&b, "    %s\n", types.ObjectString(m, qual))
+		}
+	}
+
+	return truncate(b.String()), nil
+}
+
+func (w *goWorkspace) typeOf(ctx context.Context, args map[string]any) (string, error) {
+	pkgs, fset, err := w.load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	file, line, expr := w.abs(argString(args, "file")), argInt(args, "line"), argString(args, "name")
+
+	for _, p := range pkgs {
+		f := syntaxFile(p, fset, file)
+		if f == nil || p.TypesInfo == nil {
+			continue
+		}
+
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read: %w", err)
+		}
+
+		var found ast.Expr
+		ast.Inspect(f, func(n ast.Node) bool {
+			if found != nil || n == nil {
+				return false
+			}
+
+			e, ok := n.(ast.Expr)
+			if !ok || fset.Position(n.Pos()).Line != line {
+				return true
+			}
+
+			start, end := fset.Position(e.Pos()).Offset, fset.Position(e.End()).Offset
+			if end <= len(src) && string(src[start:end]) == expr {
+				found = e
+			}
+
+			return true
+		})
+
+		if found == nil {
+			continue
+		}
+
+		tv, ok := p.TypesInfo.Types[found]
+		if !ok {
+			if id, isIdent := found.(*ast.Ident); isIdent {
+				if obj := p.TypesInfo.ObjectOf(id); obj != nil {
+					return types.ObjectString(obj, qualifier(p.Types)), nil
+				}
+			}
+			return "", fmt.Errorf("no type information for %s", expr)
+		}
+
+		kind := "value"
+		switch {
+		case tv.IsType():
+			kind = "type"
+		case tv.Value != nil:
+			kind = "constant " + tv.Value.String()
+		}
+
+		return fmt.Sprintf("%s: %s (%s)", expr, types.TypeString(tv.Type, qualifier(p.Types)), kind), nil
+	}
+
+	return "", fmt.Errorf("expression %q not found on %s:%d", expr, argString(args, "file"), line)
+}
+
+func (w *goWorkspace) errors(ctx context.Context, args map[string]any) (string, error) {
+	pattern := argString(args, "pattern")
+	if pattern == "" {
+		pattern = "./..."
+	}
+
+	pkgs, _, err := w.loadPattern(ctx, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	seen := make(map[string]bool)
+	var errs []string
+
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		for _, e := range p.Errors {
+			msg := e.Msg
+			if e.Pos != "" && e.Pos != "-" {
+				msg = w.relative(e.Pos) + ": " + msg
+			}
+
+			if !seen[msg] {
+				seen[msg] = true
+				errs = append(errs, msg)
+			}
+		}
+	})
+
+	if len(errs) == 0 {
+		return "no errors", nil
+	}
+
+	return limitLines(errs, fmt.Sprintf("%d errors", len(errs))), nil
+}
+
+// =============================================================================
+
+// findIdent returns the identifier with the name on the line of the file.
+func (w *goWorkspace) findIdent(pkgs []*packages.Package, fset *token.FileSet, args map[string]any) (*packages.Package, *ast.Ident, error) {
+	file, line, name := w.abs(argString(args, "file")), argInt(args, "line"), argString(args, "name")
+
+	for _, p := range pkgs {
+		f := syntaxFile(p, fset, file)
+		if f == nil || p.TypesInfo == nil {
+			continue
+		}
+
+		var found *ast.Ident
+		ast.Inspect(f, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && found == nil && id.Name == name && fset.Position(id.Pos()).Line == line {
+				found = id
+			}
+			return found == nil
+		})
+
+		if found != nil {
+			return p, found, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("identifier %q not found on %s:%d", name, argString(args, "file"), line)
+}
+
+func (w *goWorkspace) abs(file string) string {
+	if filepath.IsAbs(file) {
+		return filepath.Clean(file)
+	}
+	return filepath.Join(w.root, file)
+}
+
+func (w *goWorkspace) location(pos token.Position) string {
+	return fmt.Sprintf("%s:%d:%d", w.relative(pos.Filename), pos.Line, pos.Column)
+}
+
+func (w *goWorkspace) relative(path string) string {
+	if rel, err := filepath.Rel(w.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// qualifier writes the types of other packages with the package name only,
+// which is shorter and how they appear in the code.
+func qualifier(pkg *types.Package) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+}
+
+func syntaxFile(p *packages.Package, fset *token.FileSet, file string) *ast.File {
+	for _, f := range p.Syntax {
+		if fset.Position(f.Pos()).Filename == file {
+			return f
+		}
+	}
+	return nil
+}
+
+func objectKey(fset *token.FileSet, obj types.Object) string {
+	pos := fset.Position(obj.Pos())
+
+	var pkg string
+	if obj.Pkg() != nil {
+		pkg = obj.Pkg().Path()
+	}
+
+	return fmt.Sprintf("%s.%s %s:%d", pkg, obj.Name(), pos.Filename, pos.Line)
+}
+
+// sourceFiles holds the lines of the files read for a result, so a file
+// with many references in it is read once.
+type sourceFiles map[string][]string
+
+// line returns the source line at the position, trimmed, empty when the
+// file can't be read.
+func (src sourceFiles) line(pos token.Position) string {
+	lines, exists := src[pos.Filename]
+	if !exists {
+		data, err := os.ReadFile(pos.Filename)
+		if err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		src[pos.Filename] = lines
+	}
+
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+
+	return strings.TrimSpace(lines[pos.Line-1])
+}
+
+func limitLines(lines []string, header string) string {
+	var more string
+	if len(lines) > maxResults {
+		more = fmt.Sprintf("\n[%d more not shown]", len(lines)-maxResults)
+		lines = lines[:maxResults]
+	}
+
+	return truncate(header + "\n" + strings.Join(lines, "\n") + more)
+}
+
+func argInt(args map[string]any, name string) int {
+	switch v := args[name].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
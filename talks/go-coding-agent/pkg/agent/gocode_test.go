@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// module writes the files, relative to a new directory, and returns it.
+func module(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestFingerprint(t *testing.T) {
+	root := module(t, map[string]string{
+		"go.mod":           "module example\n",
+		"main.go":          "package main\n",
+		"lib/lib.go":       "package lib\n",
+		"README.md":        "readme\n",
+		".git/x.go":        "package x\n",
+		"testdata/bad.go":  "not go\n",
+		"vendor/v/v.go":    "package v\n",
+		"lib/inner/in.go":  "package inner\n",
+		"lib/inner/in.txt": "text\n",
+	})
+
+	w := goWorkspace{root: root}
+
+	fingerprint := func() string {
+		t.Helper()
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		fp, err := w.fingerprintFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	fp := fingerprint()
+	if n := strings.Count(fp, "\n"); n != 4 {
+		t.Errorf("got %d files, want go.mod and the 3 Go files outside the skipped directories:\n%s", n, fp)
+	}
+
+	if again := fingerprint(); again != fp {
+		t.Errorf("the fingerprint changed without any change:\n%s\n%s", fp, again)
+	}
+
+	changes := []struct {
+		name   string
+		change func() error
+	}{
+		{"edit", func() error {
+			return os.WriteFile(filepath.Join(root, "lib/inner/in.go"), []byte("package inner\n\nvar X int\n"), 0o644)
+		}},
+		{"add", func() error {
+			return os.WriteFile(filepath.Join(root, "lib/more.go"), []byte("package lib\n"), 0o644)
+		}},
+		{"add directory", func() error {
+			return os.MkdirAll(filepath.Join(root, "cmd/tool"), 0o755)
+		}},
+		{"add in new directory", func() error {
+			return os.WriteFile(filepath.Join(root, "cmd/tool/main.go"), []byte("package main\n"), 0o644)
+		}},
+		{"remove directory", func() error {
+			return os.RemoveAll(filepath.Join(root, "lib/inner"))
+		}},
+	}
+
+	for _, c := range changes {
+		if err := c.change(); err != nil {
+			t.Fatal(err)
+		}
+
+		next := fingerprint()
+		if next == fp && c.name != "add directory" {
+			t.Errorf("%s: the fingerprint didn't change", c.name)
+		}
+		fp = next
+	}
+
+	if strings.Contains(fp, "inner") || !strings.Contains(fp, filepath.Join("cmd", "tool", "main.go")) {
+		t.Errorf("got\n%s", fp)
+	}
+
+	if _, exists := w.dirs[filepath.Join(root, "lib/inner")]; exists {
+		t.Error("the listing of a removed directory is kept")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	root := module(t, map[string]string{
+		"go.mod": "module example\n\ngo 1.22\n",
+		"main.go": `package main
+
+func greet() string { return "hi" }
+
+func main() {
+	println(greet())
+	println(greet())
+}
+`,
+	})
+
+	w := goWorkspace{root: root}
+	ctx := context.Background()
+
+	got, err := w.references(ctx, map[string]any{"file": "main.go", "line": float64(3), "name": "greet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "2 references to greet\nmain.go:6:10: println(greet())\nmain.go:7:10: println(greet())"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// The packages are loaded again once a file changes.
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc greet() string { return \"hi\" }\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = w.references(ctx, map[string]any{"file": "main.go", "line": float64(3), "name": "greet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "no references to greet" {
+		t.Errorf("got %q after the change", got)
+	}
+}
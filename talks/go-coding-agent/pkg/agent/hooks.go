@@ -0,0 +1,306 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set of events a hook is called for.
+const (
+	HookPreTool  = "pre_tool"
+	HookPostTool = "post_tool"
+)
+
+// Set of decisions a hook can return.
+const (
+	DecisionApprove = "approve"
+	DecisionDeny    = "deny"
+)
+
+// HookInput describes the tool call a hook is called for. Output and Error
+// are only set after the tool ran. Command hooks receive it as JSON on
+// stdin.
+type HookInput struct {
+	Event     string         `json:"event"`
+	Tool      string         `json:"tool"`
+	CallID    string         `json:"call_id"`
+	Arguments map[string]any `json:"arguments"`
+	Output    string         `json:"output,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// HookResult is what a hook decided, the zero value leaves the call alone.
+//
+// Before the tool runs, approve spares asking the user, see HookApproved, and
+// deny stops the call, the reason being sent to the model. Arguments replaces the
+// arguments of the call. After the tool runs, Output replaces its output, or
+// its error which makes the call succeed, and deny turns the result into an
+// error.
+type HookResult struct {
+	Decision  string         `json:"decision,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	Output    *string        `json:"output,omitempty"`
+}
+
+// Hook is called around the tool calls. An error fails the call.
+type Hook func(ctx context.Context, in HookInput) (HookResult, error)
+
+type hookApprovedKey struct{}
+
+// HookApproved reports whether a pre-tool hook approved the call the policy
+// is called for.
+func HookApproved(ctx context.Context) bool {
+	approved, _ := ctx.Value(hookApprovedKey{}).(bool)
+	return approved
+}
+
+// WithPreToolHooks calls the hooks, in order, before every tool call. The
+// first one to approve or deny the call decides.
+func WithPreToolHooks(hooks ...Hook) func(a *Agent) {
+	return func(a *Agent) {
+		a.preHooks = append(a.preHooks, hooks...)
+	}
+}
+
+// WithPostToolHooks calls the hooks, in order, with the result of every tool
+// call.
+func WithPostToolHooks(hooks ...Hook) func(a *Agent) {
+	return func(a *Agent) {
+		a.postHooks = append(a.postHooks, hooks...)
+	}
+}
+
+// ForTools only calls the hook for the named tools, for all of them when no
+// name is given.
+func ForTools(hook Hook, names ...string) Hook {
+	if len(names) == 0 {
+		return hook
+	}
+
+	return func(ctx context.Context, in HookInput) (HookResult, error) {
+		if !slices.Contains(names, in.Tool) {
+			return HookResult{}, nil
+		}
+		return hook(ctx, in)
+	}
+}
+
+// CommandHook runs the command with sh -c in dir for every call, writing the
+// HookInput as JSON to its stdin. The command answers with a HookResult as
+// JSON on stdout, or nothing to leave the call alone. A command exiting with
+// an error denies the call with its output as the reason.
+func CommandHook(command string, dir string) Hook {
+	return func(ctx context.Context, in HookInput) (HookResult, error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		data, err := json.Marshal(in)
+		if err != nil {
+			return HookResult{}, fmt.Errorf("marshal: %w", err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return HookResult{}, fmt.Errorf("hook %q: %w", command, err)
+			}
+
+			reason := strings.TrimSpace(stderr.String() + "\n" + stdout.String())
+			if reason == "" {
+				reason = fmt.Sprintf("hook %q exited with status %d", command, exitErr.ExitCode())
+			}
+
+			return HookResult{Decision: DecisionDeny, Reason: reason}, nil
+		}
+
+		if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+			return HookResult{}, nil
+		}
+
+		var res HookResult
+		if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+			return HookResult{}, fmt.Errorf("hook %q: decode answer: %w", command, err)
+		}
+
+		return res, nil
+	}
+}
+
+// =============================================================================
+
+// Answer is the user's answer to an approval request.
+type Answer int
+
+// Set of answers to an approval request.
+const (
+	AllowOnce Answer = iota + 1
+	AllowAlways
+	Deny
+)
+
+// Asker asks the user whether the tool call can run. The reason comes with
+// a denial and is sent to the model.
+type Asker func(ctx context.Context, call client.ToolCall) (answer Answer, reason string, err error)
+
+// Approvals asks the user before running tools and remembers the tools
+// allowed for the rest of the session.
+type Approvals struct {
+	ask Asker
+
+	mu     sync.Mutex
+	always map[string]bool
+}
+
+// NewApprovals constructs approvals asking the user with ask.
+func NewApprovals(ask Asker) *Approvals {
+	return &Approvals{
+		ask:    ask,
+		always: make(map[string]bool),
+	}
+}
+
+// Check asks the user about the call unless the tool was allowed for the
+// session, returning an error wrapping ErrDenied when the user refuses.
+func (ap *Approvals) Check(ctx context.Context, call client.ToolCall) error {
+	name := call.Function.Name
+
+	ap.mu.Lock()
+	allowed := ap.always[name]
+	ap.mu.Unlock()
+
+	if allowed {
+		return nil
+	}
+
+	answer, reason, err := ap.ask(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	switch answer {
+	case AllowOnce:
+		return nil
+
+	case AllowAlways:
+		ap.mu.Lock()
+		ap.always[name] = true
+		ap.mu.Unlock()
+		return nil
+	}
+
+	if reason == "" {
+		return fmt.Errorf("%w: the user refused to run %s", ErrDenied, name)
+	}
+	return fmt.Errorf("%w: the user refused to run %s: %s", ErrDenied, name, reason)
+}
+
+// Allowed returns the tools allowed for the session.
+func (ap *Approvals) Allowed() []string {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	var names []string
+	for name := range ap.always {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Reset forgets the tools allowed for the session.
+func (ap *Approvals) Reset() {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	clear(ap.always)
+}
+
+// =============================================================================
+
+// runPreHooks returns the arguments to run the tool with and whether a hook
+// approved the call.
+func (a *Agent) runPreHooks(ctx context.Context, tc client.ToolCall) (map[string]any, bool, error) {
+	args := tc.Function.Arguments
+
+	for _, hook := range a.preHooks {
+		res, err := hook(ctx, HookInput{
+			Event:     HookPreTool,
+			Tool:      tc.Function.Name,
+			CallID:    tc.ID,
+			Arguments: args,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("pre-tool hook: %w", err)
+		}
+
+		if res.Arguments != nil {
+			args = res.Arguments
+		}
+
+		switch res.Decision {
+		case DecisionApprove:
+			return args, true, nil
+		case DecisionDeny:
+			return nil, false, denied(tc.Function.Name, res.Reason)
+		}
+	}
+
+	return args, false, nil
+}
+
+// runPostHooks lets the hooks replace the result of the call.
+func (a *Agent) runPostHooks(ctx context.Context, tc client.ToolCall, result string, err error) (string, error) {
+	for _, hook := range a.postHooks {
+		in := HookInput{
+			Event:     HookPostTool,
+			Tool:      tc.Function.Name,
+			CallID:    tc.ID,
+			Arguments: tc.Function.Arguments,
+			Output:    result,
+		}
+		if err != nil {
+			in.Error = err.Error()
+		}
+
+		res, hookErr := hook(ctx, in)
+		if hookErr != nil {
+			return "", fmt.Errorf("post-tool hook: %w", hookErr)
+		}
+
+		if res.Output != nil {
+			result = *res.Output
+			err = nil
+		}
+
+		if res.Decision == DecisionDeny {
+			return "", denied(tc.Function.Name, res.Reason)
+		}
+	}
+
+	return result, err
+}
+
+func denied(tool string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("%w: %s was blocked by a hook", ErrDenied, tool)
+	}
+	return fmt.Errorf("%w: %s: %s", ErrDenied, tool, reason)
+}
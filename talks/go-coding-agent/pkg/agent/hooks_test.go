@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"slices"
+	"testing"
+)
+
+// recorder is a tool keeping the arguments it's called with.
+type recorder struct {
+	calls []map[string]any
+}
+
+func (r *recorder) tool() Tool {
+	return Tool{
+		Name:       "run_command",
+		Parameters: object(client.D{"command": str("The command.")}, "command"),
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			r.calls = append(r.calls, args)
+			return "ok", nil
+		},
+	}
+}
+
+// rules is a policy denying the tools in deny and asking, with a refusal,
+// for the tools in ask unless a hook approved them.
+func rules(deny []string, ask []string, asked *int) Policy {
+	return func(ctx context.Context, call client.ToolCall) error {
+		switch name := call.Function.Name; {
+		case slices.Contains(deny, name):
+			return fmt.Errorf("%w: %s is not allowed", ErrDenied, name)
+
+		case slices.Contains(ask, name) && !HookApproved(ctx):
+			*asked++
+			return fmt.Errorf("%w: the user refused", ErrDenied)
+		}
+
+		return nil
+	}
+}
+
+func approve(ctx context.Context, in HookInput) (HookResult, error) {
+	return HookResult{Decision: DecisionApprove}, nil
+}
+
+func command(cmd string) client.ToolCall {
+	return client.ToolCall{ID: "call_1", Function: client.Function{Name: "run_command", Arguments: map[string]any{"command": cmd}}}
+}
+
+func TestPreHookApproveSkipsAsking(t *testing.T) {
+	var r recorder
+	var asked int
+
+	a := New(nil,
+		WithTools(r.tool()),
+		WithPreToolHooks(approve),
+		WithPolicy(rules(nil, []string{"run_command"}, &asked)),
+	)
+
+	if _, err := a.call(context.Background(), command("go test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if asked != 0 || len(r.calls) != 1 {
+		t.Errorf("got %d questions and %d calls, want the approved call to run without asking", asked, len(r.calls))
+	}
+
+	// Without the hook the user is asked.
+	a = New(nil, WithTools(r.tool()), WithPolicy(rules(nil, []string{"run_command"}, &asked)))
+	if _, err := a.call(context.Background(), command("go test")); !errors.Is(err, ErrDenied) || asked != 1 {
+		t.Errorf("got %v after %d questions, want the user asked", err, asked)
+	}
+}
+
+func TestPreHookApproveKeepsDenyRules(t *testing.T) {
+	var r recorder
+	var asked int
+
+	a := New(nil,
+		WithTools(r.tool()),
+		WithPreToolHooks(approve),
+		WithPolicy(rules([]string{"run_command"}, nil, &asked)),
+	)
+
+	if _, err := a.call(context.Background(), command("rm -rf /")); !errors.Is(err, ErrDenied) {
+		t.Errorf("got %v, want the deny rule to apply", err)
+	}
+
+	if len(r.calls) != 0 {
+		t.Error("the denied tool ran")
+	}
+}
+
+func TestPreHookDeny(t *testing.T) {
+	var r recorder
+	var later bool
+
+	a := New(nil,
+		WithTools(r.tool()),
+		WithPreToolHooks(
+			func(ctx context.Context, in HookInput) (HookResult, error) {
+				return HookResult{Decision: DecisionDeny, Reason: "no network"}, nil
+			},
+			func(ctx context.Context, in HookInput) (HookResult, error) {
+				later = true
+				return HookResult{}, nil
+			},
+		),
+	)
+
+	_, err := a.call(context.Background(), command("curl example.com"))
+	if !errors.Is(err, ErrDenied) || err.Error() != "tool call denied: run_command: no network" {
+		t.Errorf("got %v, want the hook's reason", err)
+	}
+
+	if later || len(r.calls) != 0 {
+		t.Errorf("got later hook called %t and %d calls, want the deny to stop the call", later, len(r.calls))
+	}
+}
+
+func TestPreHookRewritesArguments(t *testing.T) {
+	var r recorder
+	var checked map[string]any
+
+	a := New(nil,
+		WithTools(r.tool()),
+		WithPreToolHooks(
+			func(ctx context.Context, in HookInput) (HookResult, error) {
+				return HookResult{Arguments: map[string]any{"command": in.Arguments["command"].(string) + " -short"}}, nil
+			},
+			func(ctx context.Context, in HookInput) (HookResult, error) {
+				if in.Arguments["command"] != "go test -short" {
+					return HookResult{}, fmt.Errorf("got %v", in.Arguments)
+				}
+				return HookResult{}, nil
+			},
+		),
+		WithPolicy(func(ctx context.Context, call client.ToolCall) error {
+			checked = call.Function.Arguments
+			return nil
+		}),
+	)
+
+	if _, err := a.call(context.Background(), command("go test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if checked["command"] != "go test -short" || r.calls[0]["command"] != "go test -short" {
+		t.Errorf("got policy %v and tool %v, want both to see the rewritten call", checked, r.calls[0])
+	}
+}
+
+func TestApprovalsCheck(t *testing.T) {
+	var answers []Answer
+	var asked []string
+
+	ap := NewApprovals(func(ctx context.Context, call client.ToolCall) (Answer, string, error) {
+		asked = append(asked, call.Function.Name)
+		answer := answers[0]
+		answers = answers[1:]
+
+		if answer == Deny {
+			return Deny, "not now", nil
+		}
+		return answer, "", nil
+	})
+
+	call := func(name string) client.ToolCall {
+		return client.ToolCall{Function: client.Function{Name: name}}
+	}
+	ctx := context.Background()
+
+	answers = []Answer{AllowOnce, AllowOnce}
+	for range 2 {
+		if err := ap.Check(ctx, call("write_file")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answers = []Answer{AllowAlways}
+	for range 3 {
+		if err := ap.Check(ctx, call("edit_file")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answers = []Answer{Deny}
+	err := ap.Check(ctx, call("run_command"))
+	if !errors.Is(err, ErrDenied) || err.Error() != "tool call denied: the user refused to run run_command: not now" {
+		t.Errorf("got %v, want the refusal with its reason", err)
+	}
+
+	want := []string{"write_file", "write_file", "edit_file", "run_command"}
+	if !slices.Equal(asked, want) {
+		t.Errorf("got asked %v, want %v", asked, want)
+	}
+
+	if got := ap.Allowed(); !slices.Equal(got, []string{"edit_file"}) {
+		t.Errorf("got allowed %v", got)
+	}
+
+	ap.Reset()
+
+	answers = []Answer{AllowOnce}
+	if err := ap.Check(ctx, call("edit_file")); err != nil || len(asked) != 5 {
+		t.Errorf("got %v with %d questions, want the user asked again after a reset", err, len(asked))
+	}
+}
+
+func TestPostHookReplacesError(t *testing.T) {
+	output := "no such file, create it with write_file"
+
+	var seen []HookInput
+	a := New(nil, WithPostToolHooks(
+		func(ctx context.Context, in HookInput) (HookResult, error) {
+			seen = append(seen, in)
+			return HookResult{Output: &output}, nil
+		},
+		func(ctx context.Context, in HookInput) (HookResult, error) {
+			seen = append(seen, in)
+			return HookResult{}, nil
+		},
+	))
+
+	tc := client.ToolCall{ID: "call_1", Function: client.Function{Name: "read_file"}}
+
+	result, err := a.runPostHooks(context.Background(), tc, "", errors.New("open x.txt: no such file"))
+	if err != nil {
+		t.Fatalf("got error %q, want the hook's output to replace it", err)
+	}
+	if result != output {
+		t.Errorf("got %q, want %q", result, output)
+	}
+
+	if seen[0].Error == "" || seen[1].Error != "" || seen[1].Output != output {
+		t.Errorf("got hook inputs %+v, want the second hook to see the replaced result", seen)
+	}
+}
+
+func TestPostHookDeny(t *testing.T) {
+	a := New(nil, WithPostToolHooks(func(ctx context.Context, in HookInput) (HookResult, error) {
+		return HookResult{Decision: DecisionDeny, Reason: "secret in output"}, nil
+	}))
+
+	tc := client.ToolCall{ID: "call_1", Function: client.Function{Name: "read_file"}}
+
+	if _, err := a.runPostHooks(context.Background(), tc, "AWS_SECRET=x", nil); !errors.Is(err, ErrDenied) {
+		t.Errorf("got %v, want ErrDenied", err)
+	}
+}
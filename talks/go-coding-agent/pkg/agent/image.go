@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Set of limits applied to the images before they're sent. Larger images
+// cost more tokens than they're worth and many servers refuse them.
+const (
+	MaxImageSide  = 1568
+	MaxImageBytes = 4 << 20
+)
+
+// ErrNoVision is returned when images are sent to a model that can't see
+// them.
+var ErrNoVision = errors.New("model doesn't accept images")
+
+// Image is a picture attached to a user message.
+type Image struct {
+	Name     string `json:"name,omitempty"`
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// LoadImage reads the image file, see NewImage.
+func LoadImage(path string) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, fmt.Errorf("image: %w", err)
+	}
+
+	return NewImage(filepath.Base(path), data)
+}
+
+// NewImage detects the type of the image from its content and downscales it
+// when it's larger than MaxImageSide or MaxImageBytes. GIF and WebP images
+// are converted to PNG since not every server accepts them, an animated GIF
+// keeping its first frame.
+func NewImage(name string, data []byte) (Image, error) {
+	mimeType := http.DetectContentType(data)
+
+	var decode func(r *bytes.Reader) (image.Image, error)
+	switch mimeType {
+	case "image/png":
+		decode = func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) }
+	case "image/jpeg":
+		decode = func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) }
+	case "image/gif":
+		decode = func(r *bytes.Reader) (image.Image, error) { return gif.Decode(r) }
+	case "image/webp":
+		decode = func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) }
+	default:
+		return Image{}, fmt.Errorf("image %s: unsupported type %s, use PNG, JPEG, GIF or WebP", name, mimeType)
+	}
+
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("image %s: decode: %w", name, err)
+	}
+
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+
+	if longest <= MaxImageSide && len(data) <= MaxImageBytes && (mimeType == "image/png" || mimeType == "image/jpeg") {
+		return Image{Name: name, MIMEType: mimeType, Data: data}, nil
+	}
+
+	// Keep shrinking until the encoded image fits, JPEG noise can keep a
+	// large photo over the limit at the first size.
+	side := min(longest, MaxImageSide)
+	for {
+		scaled := img
+		if side < longest {
+			scaled = downscale(img, side)
+		}
+
+		// JPEG stays JPEG, the other formats become PNG which keeps the
+		// transparency and sharp edges of screenshots.
+		var buf bytes.Buffer
+		switch mimeType {
+		case "image/jpeg":
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+		default:
+			mimeType = "image/png"
+			err = png.Encode(&buf, scaled)
+		}
+		if err != nil {
+			return Image{}, fmt.Errorf("image %s: encode: %w", name, err)
+		}
+
+		if buf.Len() <= MaxImageBytes || side <= 256 {
+			return Image{Name: name, MIMEType: mimeType, Data: buf.Bytes()}, nil
+		}
+
+		side = side * 3 / 4
+	}
+}
+
+// downscale resizes the image so its longer side is side pixels.
+func downscale(img image.Image, side int) image.Image {
+	b := img.Bounds()
+
+	w, h := side, b.Dy()*side/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*side/b.Dy(), side
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	return dst
+}
+
+// =============================================================================
+
+// TurnWithImages runs a turn with the images attached to the input. It fails
+// with ErrNoVision when the model is known not to accept images and emits an
+// EventWarning when nobody knows, the server not reporting what its models
+// can do.
+func (a *Agent) TurnWithImages(ctx context.Context, input string, images []Image, emit func(Event)) error {
+	if len(images) > 0 {
+		a.mu.Lock()
+		llm := a.llm
+		a.mu.Unlock()
+
+		if err := llm.Require(ctx, client.CapabilityVision); errors.Is(err, client.ErrNotSupported) {
+			err = fmt.Errorf("%w: %s is text only", ErrNoVision, llm.Model())
+			emit(Event{Kind: EventError, Err: err})
+			return err
+		}
+
+		// The images still go out, the model may well see them.
+		if mi, err := llm.ModelInfo(ctx); err != nil || !mi.Detailed {
+			err := fmt.Errorf("%w: %s may not accept images", client.ErrCapsUnknown, llm.Model())
+			emit(Event{Kind: EventWarning, Err: err})
+		}
+	}
+
+	return a.turnWith(ctx, Message{Role: RoleUser, Content: input, Images: images}, emit)
+}
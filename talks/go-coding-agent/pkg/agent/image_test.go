@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// picture returns an image of the size, noisy so it doesn't compress away.
+func picture(w int, h int) image.Image {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(r.IntN(256)), uint8(x), uint8(y), 255})
+		}
+	}
+
+	return img
+}
+
+func encode(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNewImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		img      image.Image
+		format   string
+		mimeType string
+		side     int
+		same     bool
+	}{
+		{"small png", picture(40, 20), "png", "image/png", 40, true},
+		{"small jpeg", picture(40, 20), "jpeg", "image/jpeg", 40, true},
+		{"small gif", picture(40, 20), "gif", "image/png", 40, false},
+		{"wide png", picture(2000, 100), "png", "image/png", MaxImageSide, false},
+		{"tall jpeg", picture(50, 1800), "jpeg", "image/jpeg", MaxImageSide, false},
+		{"large gif", picture(1600, 30), "gif", "image/png", MaxImageSide, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encode(t, tt.img, tt.format)
+
+			img, err := NewImage(tt.name, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img.MIMEType != tt.mimeType {
+				t.Errorf("got %s, want %s", img.MIMEType, tt.mimeType)
+			}
+
+			if same := bytes.Equal(img.Data, data); same != tt.same {
+				t.Errorf("got the data unchanged %t, want %t", same, tt.same)
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if "image/"+format != tt.mimeType {
+				t.Errorf("got data in %s, labeled %s", format, img.MIMEType)
+			}
+
+			if side := max(cfg.Width, cfg.Height); side != tt.side {
+				t.Errorf("got a longer side of %d, want %d", side, tt.side)
+			}
+		})
+	}
+}
+
+func TestNewImageUnsupported(t *testing.T) {
+	if _, err := NewImage("notes.txt", []byte("not an image")); err == nil {
+		t.Error("expected an error for text")
+	}
+}
+
+func TestConversationImages(t *testing.T) {
+	data := encode(t, picture(4, 4), "png")
+
+	var conv Conversation
+	conv.Add(Message{Role: RoleUser, Content: "What's this?", Images: []Image{{MIMEType: "image/png", Data: data}}})
+
+	parts, ok := conv.D()[0]["content"].([]client.D)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("got content %v, want the text and the image", conv.D()[0]["content"])
+	}
+
+	if parts[0]["text"] != "What's this?" {
+		t.Errorf("got text part %v", parts[0])
+	}
+
+	want := client.ImagePart("image/png", data)
+	if got := parts[1]["image_url"].(client.D)["url"]; got != want["image_url"].(client.D)["url"] {
+		t.Errorf("got url %.40v, want the one the client sends", got)
+	}
+}
+
+func TestTurnWithImagesCapabilities(t *testing.T) {
+	images := []Image{{MIMEType: "image/png", Data: encode(t, picture(4, 4), "png")}}
+
+	tests := []struct {
+		name    string
+		caps    string
+		err     error
+		warning error
+	}{
+		{"unknown", "", nil, client.ErrCapsUnknown},
+		{"text only", `["completion"]`, ErrNoVision, nil},
+		{"vision", `["completion", "vision"]`, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := newChatServer(t)
+
+			// The chat server doesn't know the model, an empty caps
+			// leaves it that way.
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/show" && tt.caps != "" {
+					fmt.Fprintf(w, `{"capabilities": %s}`, tt.caps)
+					return
+				}
+				chat.handle(w, r)
+			}))
+			t.Cleanup(srv.Close)
+
+			llm := client.NewLLM(srv.URL+"/v1/chat/completions", "test", client.WithClientOptions(client.WithSlog(nil)))
+
+			var warnings []error
+			err := New(llm).TurnWithImages(context.Background(), "What's this?", images, func(e Event) {
+				if e.Kind == EventWarning {
+					warnings = append(warnings, e.Err)
+				}
+			})
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+
+			switch {
+			case tt.warning == nil && len(warnings) != 0:
+				t.Errorf("got warnings %v, want none", warnings)
+			case tt.warning != nil && (len(warnings) != 1 || !errors.Is(warnings[0], tt.warning)):
+				t.Errorf("got warnings %v, want %v", warnings, tt.warning)
+			}
+
+			if sent := len(chat.requests) == 1; sent != (tt.err == nil) {
+				t.Errorf("got the images sent %t, want %t", sent, tt.err == nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set of names of the memory tools.
+const (
+	RememberToolName = "remember"
+	RecallToolName   = "recall"
+)
+
+// ErrNoMemory is returned for a memory id the store doesn't hold.
+var ErrNoMemory = errors.New("memory not found")
+
+// memoryPrompt introduces the memories recalled for a turn.
+const memoryPrompt = `Memories from earlier sessions that may be relevant, most relevant first.
+Use them when they help and don't mention them otherwise. Save new lasting
+facts about the user or the project with remember.`
+
+// Set of thresholds on the cosine similarity of two memories.
+const (
+	// duplicateScore is how close a new memory has to be to an old one to
+	// replace it instead of being added.
+	duplicateScore = 0.95
+
+	// minRelevance is how close a memory has to be to the input to be
+	// recalled with every turn.
+	minRelevance = 0.5
+)
+
+// Memory is a fact kept across sessions.
+type Memory struct {
+	ID        int       `json:"id"`
+	Text      string    `json:"text"`
+	Embedding []float64 `json:"embedding"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Recalled is a memory found for a query with its similarity to it.
+type Recalled struct {
+	Memory
+	Score float64
+}
+
+// Embedder turns a text into an embedding, client.LLM.EmbedText does.
+type Embedder func(ctx context.Context, text string) ([]float64, error)
+
+// =============================================================================
+
+// Memories keeps the facts the model chose to remember, with their
+// embeddings so the ones relevant to a turn can be found. They're persisted
+// to a file on every change.
+type Memories struct {
+	path   string
+	embed  Embedder
+	recall int
+
+	mu     sync.Mutex
+	list   []Memory
+	nextID int
+}
+
+// NewMemories constructs the memories persisted at the path, loading the
+// ones already there. An empty path keeps them in memory. The embedder is
+// only called when a memory is added, edited or searched for.
+func NewMemories(path string, embed Embedder) (*Memories, error) {
+	m := Memories{
+		path:   path,
+		embed:  embed,
+		recall: 3,
+		nextID: 1,
+	}
+
+	if path == "" {
+		return &m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return &m, nil
+	case err != nil:
+		return nil, fmt.Errorf("read memories: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &m.list); err != nil {
+		return nil, fmt.Errorf("decode memories %s: %w", path, err)
+	}
+
+	for _, mem := range m.list {
+		m.nextID = max(m.nextID, mem.ID+1)
+	}
+
+	return &m, nil
+}
+
+// SetRecall sets the number of memories recalled with every turn, 3 by
+// default. Zero turns it off, leaving the model to call recall.
+func (m *Memories) SetRecall(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recall = n
+}
+
+// List returns the memories, oldest first.
+func (m *Memories) List() []Memory {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.list)
+}
+
+// Remember adds the text as a memory. A memory saying nearly the same thing
+// is replaced instead, so repeating a fact doesn't pile up copies.
+func (m *Memories) Remember(ctx context.Context, text string) (Memory, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Memory{}, errors.New("memory is empty")
+	}
+
+	embedding, err := m.embed(ctx, text)
+	if err != nil {
+		return Memory{}, fmt.Errorf("embed memory: %w", err)
+	}
+	embedding = normalize(embedding)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	for i, mem := range m.list {
+		if cosine(mem.Embedding, embedding) >= duplicateScore {
+			m.list[i].Text = text
+			m.list[i].Embedding = embedding
+			m.list[i].Updated = now
+			return m.list[i], m.save()
+		}
+	}
+
+	mem := Memory{
+		ID:        m.nextID,
+		Text:      text,
+		Embedding: embedding,
+		Created:   now,
+		Updated:   now,
+	}
+	m.list = append(m.list, mem)
+	m.nextID++
+
+	return mem, m.save()
+}
+
+// Recall returns the k memories closest to the query.
+func (m *Memories) Recall(ctx context.Context, query string, k int) ([]Recalled, error) {
+	if len(m.List()) == 0 {
+		return nil, nil
+	}
+
+	embedding, err := m.embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	embedding = normalize(embedding)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := make([]Recalled, len(m.list))
+	for i, mem := range m.list {
+		found[i] = Recalled{Memory: mem, Score: cosine(mem.Embedding, embedding)}
+	}
+
+	slices.SortStableFunc(found, func(a, b Recalled) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+
+	return found[:min(k, len(found))], nil
+}
+
+// Edit replaces the text of the memory.
+func (m *Memories) Edit(ctx context.Context, id int, text string) (Memory, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Memory{}, errors.New("memory is empty, delete it instead")
+	}
+
+	// Check the id first, not to embed the text for nothing.
+	m.mu.Lock()
+	i := m.position(id)
+	m.mu.Unlock()
+
+	if i == -1 {
+		return Memory{}, fmt.Errorf("%w: %d", ErrNoMemory, id)
+	}
+
+	embedding, err := m.embed(ctx, text)
+	if err != nil {
+		return Memory{}, fmt.Errorf("embed memory: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// It may have been deleted while the text was embedded.
+	if i = m.position(id); i == -1 {
+		return Memory{}, fmt.Errorf("%w: %d", ErrNoMemory, id)
+	}
+
+	m.list[i].Text = text
+	m.list[i].Embedding = normalize(embedding)
+	m.list[i].Updated = time.Now()
+
+	return m.list[i], m.save()
+}
+
+// Delete removes the memory.
+func (m *Memories) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.position(id)
+	if i == -1 {
+		return fmt.Errorf("%w: %d", ErrNoMemory, id)
+	}
+
+	m.list = slices.Delete(m.list, i, i+1)
+
+	return m.save()
+}
+
+// Tools returns the remember and recall tools.
+func (m *Memories) Tools() []Tool {
+	return []Tool{
+		{
+			Name:        RememberToolName,
+			Description: "Remember a lasting fact for future sessions, like a preference of the user or a convention of the project. Write it as a short standalone sentence.",
+			Parameters: object(client.D{
+				"fact": str("The fact to remember."),
+			}, "fact"),
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				mem, err := m.Remember(ctx, argString(args, "fact"))
+				if err != nil {
+					return "", err
+				}
+
+				return fmt.Sprintf("remembered as memory %d", mem.ID), nil
+			},
+		},
+		{
+			Name:        RecallToolName,
+			ReadOnly:    true,
+			Description: "Search the memories saved in earlier sessions for the facts most relevant to the query.",
+			Parameters: object(client.D{
+				"query": str("What to look for."),
+				"limit": client.D{
+					"type":        "integer",
+					"description": "The number of memories to return, 5 when not set.",
+				},
+			}, "query"),
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				limit := argInt(args, "limit")
+				if limit <= 0 {
+					limit = 5
+				}
+
+				found, err := m.Recall(ctx, argString(args, "query"), limit)
+				if err != nil {
+					return "", err
+				}
+
+				if len(found) == 0 {
+					return "no memories saved yet", nil
+				}
+
+				return formatMemories(found), nil
+			},
+		},
+	}
+}
+
+// relevant returns the memories recalled for the input as a system message,
+// empty when none is close enough.
+func (m *Memories) relevant(ctx context.Context, input string) (string, error) {
+	m.mu.Lock()
+	k := m.recall
+	m.mu.Unlock()
+
+	if k <= 0 || strings.TrimSpace(input) == "" {
+		return "", nil
+	}
+
+	found, err := m.Recall(ctx, input, k)
+	if err != nil {
+		return "", err
+	}
+
+	found = slices.DeleteFunc(found, func(r Recalled) bool { return r.Score < minRelevance })
+	if len(found) == 0 {
+		return "", nil
+	}
+
+	return memoryPrompt + "\n\n" + formatMemories(found), nil
+}
+
+// position returns the index of the memory in the list, -1 when there is
+// none with the id. The caller holds the lock.
+func (m *Memories) position(id int) int {
+	return slices.IndexFunc(m.list, func(mem Memory) bool { return mem.ID == id })
+}
+
+func (m *Memories) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m.list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode memories: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("save memories: %w", err)
+	}
+
+	if err := os.WriteFile(m.path, data, 0o600); err != nil {
+		return fmt.Errorf("save memories: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// formatMemories lists the memories with their id and the day they were
+// last updated, which tells the model how current they are.
+func formatMemories(found []Recalled) string {
+	var b strings.Builder
+	for _, r := range found {
+		fmt.Fprintf(&b, "- [%d, %s] %s\n", r.ID, r.Updated.Format(time.DateOnly), r.Text)
+	}
+
+	return b.String()
+}
+
+func normalize(v []float64) []float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+
+	if sum == 0 {
+		return v
+	}
+
+	norm := math.Sqrt(sum)
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+
+	return out
+}
+
+// cosine is the cosine similarity of two normalized vectors.
+func cosine(a []float64, b []float64) float64 {
+	var sum float64
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// embed is a bag of words embedder, texts sharing words are close.
+func embed(ctx context.Context, text string) ([]float64, error) {
+	v := make([]float64, 64)
+	for _, w := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		h.Write([]byte(strings.Trim(w, ".,?")))
+		v[h.Sum32()%64]++
+	}
+
+	return v, nil
+}
+
+func TestRememberDeduplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.json")
+
+	m, err := NewMemories(path, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	first, err := m.Remember(ctx, "The user prefers tabs over spaces.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := m.Remember(ctx, "the user prefers tabs over spaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != first.ID {
+		t.Errorf("a repeated fact got id %d, want it to update memory %d", again.ID, first.ID)
+	}
+
+	if _, err := m.Remember(ctx, "The project builds with make."); err != nil {
+		t.Fatal(err)
+	}
+
+	if list := m.List(); len(list) != 2 || list[0].Text != "the user prefers tabs over spaces" {
+		t.Fatalf("got memories %+v, want the updated fact and the new one", list)
+	}
+
+	// The memories are persisted and the ids keep counting after a reload.
+	m, err = NewMemories(path, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem, err := m.Remember(ctx, "Deploys happen on Fridays.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.List()) != 3 || mem.ID != 3 {
+		t.Errorf("got %d memories and id %d after a reload, want 3 and 3", len(m.List()), mem.ID)
+	}
+}
+
+func TestRecall(t *testing.T) {
+	m, _ := NewMemories("", embed)
+
+	ctx := context.Background()
+	for _, text := range []string{"The project builds with make.", "The user prefers tabs over spaces.", "Deploys happen on Fridays."} {
+		if _, err := m.Remember(ctx, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := m.Recall(ctx, "does the user prefer tabs or spaces", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 || found[0].Text != "The user prefers tabs over spaces." {
+		t.Fatalf("got %+v, want the tabs fact first", found)
+	}
+
+	if found[0].Score < found[1].Score {
+		t.Errorf("got scores %f then %f, want the most relevant first", found[0].Score, found[1].Score)
+	}
+}
+
+func TestRecalledNextToSystemPrompt(t *testing.T) {
+	m, _ := NewMemories("", embed)
+	if _, err := m.Remember(context.Background(), "The user prefers tabs over spaces."); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newChatServer(t)
+	a := New(srv.llm(), WithSystemPrompt("You are a coding agent."), WithMemory(m))
+
+	turn(t, a, "Does the user prefer tabs over spaces?")
+
+	msgs := strings.Split(strings.TrimSpace(srv.messages(0)), "\n")
+	if len(msgs) < 3 || msgs[0] != "system: You are a coding agent." || !strings.HasPrefix(msgs[1], "system: "+strings.Split(memoryPrompt, "\n")[0]) {
+		t.Fatalf("the memories don't follow the system prompt:\n%s", srv.messages(0))
+	}
+
+	if last := msgs[len(msgs)-1]; last != "user: Does the user prefer tabs over spaces?" {
+		t.Errorf("got last message %q, want the user's", last)
+	}
+}
+
+func TestRememberIsNotReadOnly(t *testing.T) {
+	m, _ := NewMemories("", embed)
+
+	for _, tool := range m.Tools() {
+		if tool.Name == RememberToolName && tool.ReadOnly {
+			t.Error("remember writes to disk, it can't be read only")
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PlanToolName is the name of the tool the model maintains the plan with.
+const PlanToolName = "update_plan"
+
+// Set of statuses of a plan step.
+const (
+	StepPending    = "pending"
+	StepInProgress = "in_progress"
+	StepCompleted  = "completed"
+)
+
+// planningPrompt is sent while the agent is in planning mode.
+const planningPrompt = `You are in planning mode. Explore the code with the read-only tools, then
+call update_plan with the list of steps needed to complete the request, all
+pending, and answer with a short explanation of the plan. Don't change
+anything, the user reviews the plan before it's carried out.`
+
+// executingPrompt is sent with the plan once it's approved.
+const executingPrompt = `Carry out the plan below one step at a time. Mark a step in_progress with
+update_plan before working on it and completed once it's done, keeping a
+single step in progress. Change the plan with update_plan when it turns out
+to be wrong.`
+
+// PlanStep is a single step of a plan.
+type PlanStep struct {
+	Step   string `json:"step"`
+	Status string `json:"status"`
+}
+
+// Plan is the list of steps the model works through. It's persisted to a
+// file on every change so a long task can be resumed. The model is only
+// asked to carry out the plan once the user approved it in this session,
+// a plan loaded from the file waits for approval again.
+type Plan struct {
+	path string
+
+	mu          sync.Mutex
+	steps       []PlanStep
+	explanation string
+	approved    bool
+}
+
+// NewPlan constructs a plan persisted at the path, loading the plan already
+// there. An empty path keeps the plan in memory.
+func NewPlan(path string) (*Plan, error) {
+	p := Plan{
+		path: path,
+	}
+
+	if path == "" {
+		return &p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return &p, nil
+	case err != nil:
+		return nil, fmt.Errorf("read plan: %w", err)
+	}
+
+	var doc planDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode plan %s: %w", path, err)
+	}
+
+	p.steps = doc.Steps
+	p.explanation = doc.Explanation
+
+	return &p, nil
+}
+
+type planDoc struct {
+	Explanation string     `json:"explanation,omitempty"`
+	Steps       []PlanStep `json:"steps"`
+}
+
+// Steps returns the steps of the plan.
+func (p *Plan) Steps() []PlanStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.steps)
+}
+
+// Approved reports whether the user approved the plan in this session.
+func (p *Plan) Approved() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.approved && len(p.steps) > 0
+}
+
+// Approve marks the plan as approved, to be sent with every request until
+// all its steps are completed.
+func (p *Plan) Approve() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.approved = true
+}
+
+// Update replaces the plan and persists it. An approved plan whose steps are
+// all completed is done and cleared.
+func (p *Plan) Update(explanation string, steps []PlanStep) error {
+	var inProgress int
+	for i, s := range steps {
+		if strings.TrimSpace(s.Step) == "" {
+			return fmt.Errorf("step %d is empty", i+1)
+		}
+
+		switch s.Status {
+		case StepPending, StepCompleted:
+		case StepInProgress:
+			inProgress++
+		default:
+			return fmt.Errorf("step %d: unknown status %q, use pending, in_progress or completed", i+1, s.Status)
+		}
+	}
+
+	if inProgress > 1 {
+		return errors.New("only one step can be in progress")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	done := len(steps) > 0 && !slices.ContainsFunc(steps, func(s PlanStep) bool { return s.Status != StepCompleted })
+	if p.approved && done {
+		return p.clear()
+	}
+
+	p.steps = slices.Clone(steps)
+	p.explanation = explanation
+
+	return p.save()
+}
+
+// Clear removes every step.
+func (p *Plan) Clear() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.clear()
+}
+
+func (p *Plan) clear() error {
+	p.steps = nil
+	p.explanation = ""
+	p.approved = false
+
+	if p.path == "" {
+		return nil
+	}
+
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove plan: %w", err)
+	}
+
+	return nil
+}
+
+// Render returns the plan as a markdown checklist, empty when there is no
+// plan.
+func (p *Plan) Render() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.steps) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if p.explanation != "" {
+		b.WriteString(p.explanation)
+		b.WriteString("\n\n")
+	}
+
+	for _, s := range p.steps {
+		switch s.Status {
+		case StepCompleted:
+			fmt.Fprintf(&b, "- [x] %s\n", s.Step)
+		case StepInProgress:
+			fmt.Fprintf(&b, "- [ ] **%s** (in progress)\n", s.Step)
+		default:
+			fmt.Fprintf(&b, "- [ ] %s\n", s.Step)
+		}
+	}
+
+	return b.String()
+}
+
+// ParsePlan reads a checklist, one step per line, as written by Render or
+// by the user when editing a plan. Lines starting with "- [x]" are
+// completed, the ones ending with "(in progress)" in progress and the
+// others pending.
+func ParsePlan(text string) []PlanStep {
+	var steps []PlanStep
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+
+		status := StepPending
+		switch {
+		case strings.HasPrefix(line, "- [x]"), strings.HasPrefix(line, "- [X]"):
+			status = StepCompleted
+			line = line[len("- [x]"):]
+		case strings.HasPrefix(line, "- [ ]"):
+			line = line[len("- [ ]"):]
+		case strings.HasPrefix(line, "- "):
+			line = line[len("- "):]
+		}
+
+		if rest, found := strings.CutSuffix(strings.TrimSpace(line), "(in progress)"); found {
+			line = rest
+			if status == StepPending {
+				status = StepInProgress
+			}
+		}
+
+		line = strings.Trim(strings.TrimSpace(line), "*")
+		if line == "" {
+			continue
+		}
+
+		steps = append(steps, PlanStep{Step: line, Status: status})
+	}
+
+	return steps
+}
+
+// Tool returns the update_plan tool.
+func (p *Plan) Tool() Tool {
+	return Tool{
+		Name:        PlanToolName,
+		Description: "Replace the plan with the list of steps needed to complete the task and their status.",
+		ReadOnly:    true,
+		Parameters: object(client.D{
+			"explanation": str("A short explanation of the plan or of why it changed."),
+			"plan": client.D{
+				"type":        "array",
+				"description": "Every step of the plan, in order.",
+				"items": object(client.D{
+					"step": str("What the step does."),
+					"status": client.D{
+						"type": "string",
+						"enum": []string{StepPending, StepInProgress, StepCompleted},
+					},
+				}, "step", "status"),
+			},
+		}, "plan"),
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			list, _ := args["plan"].([]any)
+
+			steps := make([]PlanStep, 0, len(list))
+			for _, v := range list {
+				m, _ := v.(map[string]any)
+				steps = append(steps, PlanStep{
+					Step:   argString(m, "step"),
+					Status: argString(m, "status"),
+				})
+			}
+
+			if err := p.Update(argString(args, "explanation"), steps); err != nil {
+				return "", err
+			}
+
+			if len(steps) > 0 && len(p.Steps()) == 0 {
+				return "every step is completed, the plan is done", nil
+			}
+
+			return "plan updated", nil
+		},
+	}
+}
+
+func (p *Plan) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(planDoc{Explanation: p.explanation, Steps: p.steps}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode plan: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return fmt.Errorf("save plan: %w", err)
+	}
+
+	if err := os.WriteFile(p.path, data, 0o644); err != nil {
+		return fmt.Errorf("save plan: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePlanRoundTrip(t *testing.T) {
+	p, _ := NewPlan("")
+
+	steps := []PlanStep{
+		{Step: "Read the code", Status: StepCompleted},
+		{Step: "Fix the bug", Status: StepInProgress},
+		{Step: "Run the tests", Status: StepPending},
+	}
+	if err := p.Update("Three steps.", steps); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := p.Render()
+	got := ParsePlan(strings.TrimPrefix(rendered, "Three steps.\n\n"))
+	if !slices.Equal(got, steps) {
+		t.Errorf("got %+v from\n%s\nwant %+v", got, rendered, steps)
+	}
+}
+
+func TestStalePlanIsNotSent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"steps": [{"step": "Delete everything", "status": "pending"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := NewPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newChatServer(t)
+	a := New(srv.llm(), WithPlan(plan))
+
+	turn(t, a, "What time is it?")
+	if msgs := srv.messages(0); strings.Contains(msgs, "Delete everything") {
+		t.Fatalf("a plan from an earlier session was sent before it was approved:\n%s", msgs)
+	}
+
+	plan.Approve()
+
+	turn(t, a, "Go on.")
+	if msgs := srv.messages(1); !strings.Contains(msgs, executingPrompt) || !strings.Contains(msgs, "Delete everything") {
+		t.Fatalf("the approved plan wasn't sent:\n%s", msgs)
+	}
+}
+
+func TestPlanClearedWhenDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	plan, err := NewPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := []PlanStep{{Step: "One", Status: StepCompleted}, {Step: "Two", Status: StepCompleted}}
+
+	// A plan that isn't approved is only being written.
+	if err := plan.Update("", done); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps()) != 2 {
+		t.Fatalf("an unapproved plan was cleared")
+	}
+
+	plan.Approve()
+	if err := plan.Update("", done[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Steps()) != 0 || plan.Approved() {
+		t.Errorf("the finished plan is still there: %+v", plan.Steps())
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the plan file is still there: %v", err)
+	}
+}
+
+func TestPlanRejectsTwoInProgress(t *testing.T) {
+	p, _ := NewPlan("")
+
+	err := p.Update("", []PlanStep{{Step: "a", Status: StepInProgress}, {Step: "b", Status: StepInProgress}})
+	if err == nil {
+		t.Error("expected an error with two steps in progress")
+	}
+}
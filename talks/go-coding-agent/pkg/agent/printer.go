@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Printer writes a streamed response to a terminal, showing the reasoning
+// according to the display mode. Content is written exactly as received.
+type Printer struct {
+	w       io.Writer
+	display ReasoningDisplay
+
+	reasoning bool
+	start     time.Time
+	chars     int
+}
+
+// NewPrinter constructs a printer for a single response.
+func NewPrinter(w io.Writer, display ReasoningDisplay) *Printer {
+	return &Printer{
+		w:       w,
+		display: display,
+	}
+}
+
+// Reasoning handles a reasoning token.
+func (p *Printer) Reasoning(s string) {
+	if !p.reasoning {
+		p.reasoning = true
+		p.start = time.Now()
+		p.chars = 0
+
+		if p.display == ReasoningCollapsed {
+			fmt.Fprint(p.w, "\u001b[91mthinking...\u001b[0m")
+		}
+	}
+
+	p.chars += len(s)
+
+	if p.display == ReasoningFull {
+		fmt.Fprintf(p.w, "\u001b[91m%s\u001b[0m", s)
+	}
+}
+
+// Content handles a content token.
+func (p *Printer) Content(s string) {
+	p.endReasoning()
+	fmt.Fprint(p.w, s)
+}
+
+// Done is called once the stream ends.
+func (p *Printer) Done() {
+	p.endReasoning()
+}
+
+func (p *Printer) endReasoning() {
+	if !p.reasoning {
+		return
+	}
+	p.reasoning = false
+
+	switch p.display {
+	case ReasoningCollapsed:
+		fmt.Fprintf(p.w, "\u001b[91m (%s, %d chars)\u001b[0m\n", time.Since(p.start).Round(100*time.Millisecond), p.chars)
+	case ReasoningFull:
+		fmt.Fprint(p.w, "\n\n")
+	}
+}
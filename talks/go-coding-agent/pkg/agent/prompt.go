@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// InstructionFiles are the names of the files holding project instructions
+// for the agent, looked for in the go-coding-agent directory of the user's
+// config directory and in every directory from the working directory up to
+// the root of the repository.
+var InstructionFiles = []string{"AGENTS.md"}
+
+// DefaultSystemPrompt is the template of the system prompt. It's executed
+// with an Environment.
+const DefaultSystemPrompt = `You are a coding agent working in the user's project. You read, search and
+edit files and run commands with the tools you're given to complete the
+user's requests.
+
+- Look at the relevant code before changing it and follow its conventions.
+- Make the smallest change that does what was asked.
+- After editing, build or test the code when the project allows it.
+- Keep answers short. Say what you changed and anything left undone.
+{{- if .Tools}}
+
+Tools: {{join .Tools ", "}}
+{{- end}}
+
+Environment:
+- Working directory: {{.Dir}}
+- OS: {{.OS}}/{{.Arch}}
+- Date: {{.Date}}
+{{- if .GitBranch}}
+- Git branch: {{.GitBranch}}
+{{- end}}
+{{- range .Instructions}}
+
+Instructions from {{.Path}}:
+
+{{.Content}}
+{{- end}}
+`
+
+// Instruction is the content of a project instruction file.
+type Instruction struct {
+	Path    string
+	Content string
+}
+
+// Environment holds the facts about where the agent runs that go into the
+// system prompt.
+type Environment struct {
+	Dir          string
+	OS           string
+	Arch         string
+	Date         string
+	GitBranch    string
+	Tools        []string
+	Instructions []Instruction
+}
+
+// LoadEnvironment gathers the facts about the directory the agent works in.
+func LoadEnvironment(ctx context.Context, dir string, tools []Tool) (Environment, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Environment{}, fmt.Errorf("abs: %w", err)
+	}
+
+	instructions, err := LoadInstructions(dir)
+	if err != nil {
+		return Environment{}, err
+	}
+
+	env := Environment{
+		Dir:          dir,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Date:         time.Now().Format("Monday, 2006-01-02"),
+		GitBranch:    gitBranch(ctx, dir),
+		Instructions: instructions,
+	}
+
+	for _, t := range tools {
+		env.Tools = append(env.Tools, t.Name)
+	}
+	slices.Sort(env.Tools)
+
+	return env, nil
+}
+
+// LoadInstructions reads the instruction files from the directory up to the
+// root of the git repository, or the filesystem when there is none, and the
+// user's own files. The user's files come first, then the outermost project
+// files so the closer ones can refine them.
+func LoadInstructions(dir string) ([]Instruction, error) {
+	var instructions []Instruction
+
+	for {
+		found, err := readInstructions(dir)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(found, instructions...)
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	if config, err := os.UserConfigDir(); err == nil {
+		found, err := readInstructions(filepath.Join(config, "go-coding-agent"))
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(found, instructions...)
+	}
+
+	return instructions, nil
+}
+
+// readInstructions reads the instruction files of the directory in the order
+// of InstructionFiles.
+func readInstructions(dir string) ([]Instruction, error) {
+	var instructions []Instruction
+
+	for _, name := range InstructionFiles {
+		path := filepath.Join(dir, name)
+
+		data, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("read: %w", err)
+		}
+
+		instructions = append(instructions, Instruction{
+			Path:    path,
+			Content: strings.TrimSpace(string(data)),
+		})
+	}
+
+	return instructions, nil
+}
+
+// BuildSystemPrompt executes the template, DefaultSystemPrompt when empty,
+// with the environment.
+func BuildSystemPrompt(tmpl string, env Environment) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultSystemPrompt
+	}
+
+	t, err := template.New("system").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse: %w", err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, env); err != nil {
+		return "", fmt.Errorf("execute: %w", err)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+func gitBranch(ctx context.Context, dir string) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
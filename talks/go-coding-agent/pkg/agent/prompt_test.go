@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadInstructions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("AppData", filepath.Join(home, "AppData"))
+
+	config, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file above the repository isn't read.
+	outer := t.TempDir()
+	repo := filepath.Join(outer, "repo")
+	cwd := filepath.Join(repo, "cmd", "tool")
+
+	files := map[string]string{
+		filepath.Join(config, "go-coding-agent", "AGENTS.md"): "user",
+		filepath.Join(outer, "AGENTS.md"):                     "outside",
+		filepath.Join(repo, "AGENTS.md"):                      "\nrepo\n\n",
+		filepath.Join(cwd, "AGENTS.md"):                       "tool",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	instructions, err := LoadInstructions(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Instruction{
+		{Path: filepath.Join(config, "go-coding-agent", "AGENTS.md"), Content: "user"},
+		{Path: filepath.Join(repo, "AGENTS.md"), Content: "repo"},
+		{Path: filepath.Join(cwd, "AGENTS.md"), Content: "tool"},
+	}
+
+	if len(instructions) != len(want) {
+		t.Fatalf("got %+v, want %+v", instructions, want)
+	}
+	for i := range want {
+		if instructions[i] != want[i] {
+			t.Errorf("got instruction %d %+v, want %+v", i, instructions[i], want[i])
+		}
+	}
+
+	prompt, err := BuildSystemPrompt("", Environment{Dir: cwd, OS: "linux", Arch: "amd64", Date: "Monday, 2026-10-19", Instructions: instructions})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tail := "Instructions from " + want[0].Path + ":\n\nuser\n\n" +
+		"Instructions from " + want[1].Path + ":\n\nrepo\n\n" +
+		"Instructions from " + want[2].Path + ":\n\ntool"
+
+	if !strings.HasSuffix(prompt, tail) {
+		t.Errorf("got prompt:\n%s\nwant it to end with:\n%s", prompt, tail)
+	}
+}
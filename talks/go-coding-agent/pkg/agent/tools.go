@@ -0,0 +1,288 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tool is a function the model can ask the agent to run.
+type Tool struct {
+	Name        string
+	Description string
+
+	// Parameters is the JSON schema of the arguments.
+	Parameters client.D
+
+	// ReadOnly tools don't change anything, so they're offered while the
+	// agent is planning.
+	ReadOnly bool
+
+	Run func(ctx context.Context, args map[string]any) (string, error)
+}
+
+// D converts the tool into the definition sent to the server.
+func (t Tool) D() client.D {
+	return client.D{
+		"type": "function",
+		"function": client.D{
+			"name":        t.Name,
+			"description": t.Description,
+			"parameters":  t.Parameters,
+		},
+	}
+}
+
+// =============================================================================
+
+// maxOutput caps what a tool sends back to the model so one large file or
+// noisy command can't fill the context.
+const maxOutput = 64 * 1024
+
+// CodingTools returns the tools to read, search and edit the files under
+// root and to run commands there. The edits are recorded in the checkpoints
+// when they aren't nil.
+func CodingTools(root string, cp *Checkpoints) []Tool {
+	ws := workspace{root: root, cp: cp}
+
+	return []Tool{
+		{
+			Name:        "read_file",
+			ReadOnly:    true,
+			Description: "Read the contents of a file relative to the working directory.",
+			Parameters: object(client.D{
+				"path": str("The path of the file to read."),
+			}, "path"),
+			Run: ws.readFile,
+		},
+		{
+			Name:        "list_files",
+			ReadOnly:    true,
+			Description: "List the files under a directory relative to the working directory. Directories end with a slash.",
+			Parameters: object(client.D{
+				"path": str("The directory to list, the working directory when empty."),
+			}),
+			Run: ws.listFiles,
+		},
+		{
+			Name:        "edit_file",
+			Description: "Replace old_str with new_str in a file. The old_str must appear exactly once. When old_str is empty a new file is created with new_str as its content, an existing file is never overwritten.",
+			Parameters: object(client.D{
+				"path":    str("The path of the file to edit."),
+				"old_str": str("The text to replace."),
+				"new_str": str("The text to replace it with."),
+			}, "path", "new_str"),
+			Run: ws.editFile,
+		},
+		{
+			Name:        "run_command",
+			Description: "Run a shell command in the working directory and return its combined output.",
+			Parameters: object(client.D{
+				"command": str("The command to run with sh -c."),
+			}, "command"),
+			Run: ws.runCommand,
+		},
+	}
+}
+
+func object(properties client.D, required ...string) client.D {
+	if required == nil {
+		required = []string{}
+	}
+
+	return client.D{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func str(description string) client.D {
+	return client.D{
+		"type":        "string",
+		"description": description,
+	}
+}
+
+// =============================================================================
+
+type workspace struct {
+	root string
+	cp   *Checkpoints
+}
+
+// path resolves the path against the root, refusing to leave it.
+func (ws workspace) path(p string) (string, error) {
+	full := filepath.Join(ws.root, p)
+
+	rel, err := filepath.Rel(ws.root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the working directory", p)
+	}
+
+	return full, nil
+}
+
+func (ws workspace) readFile(ctx context.Context, args map[string]any) (string, error) {
+	path, err := ws.path(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(string(data)), nil
+}
+
+func (ws workspace) listFiles(ctx context.Context, args map[string]any) (string, error) {
+	dir, err := ws.path(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			rel += "/"
+		}
+
+		b.WriteString(rel)
+		b.WriteString("\n")
+
+		if b.Len() > maxOutput {
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(b.String()), nil
+}
+
+func (ws workspace) editFile(ctx context.Context, args map[string]any) (string, error) {
+	path, err := ws.path(argString(args, "path"))
+	if err != nil {
+		return "", err
+	}
+
+	oldStr := argString(args, "old_str")
+	newStr := argString(args, "new_str")
+
+	if oldStr == "" {
+		if _, err := os.Stat(path); err == nil {
+			return "", errors.New("the file already exists, give old_str to edit it")
+		}
+
+		if err := ws.checkpoint(path); err != nil {
+			return "", err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+
+		if err := os.WriteFile(path, []byte(newStr), 0o644); err != nil {
+			return "", err
+		}
+
+		return "created " + argString(args, "path"), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	switch n := strings.Count(string(data), oldStr); n {
+	case 0:
+		return "", errors.New("old_str not found in the file")
+	case 1:
+	default:
+		return "", fmt.Errorf("old_str found %d times, add context to make it unique", n)
+	}
+
+	if err := ws.checkpoint(path); err != nil {
+		return "", err
+	}
+
+	content := strings.Replace(string(data), oldStr, newStr, 1)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return "", err
+	}
+
+	return "edited " + argString(args, "path"), nil
+}
+
+func (ws workspace) checkpoint(path string) error {
+	if ws.cp == nil {
+		return nil
+	}
+	return ws.cp.record("edit_file", path)
+}
+
+func (ws workspace) runCommand(ctx context.Context, args map[string]any) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", argString(args, "command"))
+	cmd.Dir = ws.root
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+
+	// A failing command is an answer for the model, not an error of the tool.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return truncate(fmt.Sprintf("%s\nexit status %d", out.String(), exitErr.ExitCode())), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(out.String()), nil
+}
+
+// =============================================================================
+
+func argString(args map[string]any, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func truncate(s string) string {
+	if len(s) <= maxOutput {
+		return s
+	}
+
+	return s[:maxOutput] + "\n[output truncated]"
+}
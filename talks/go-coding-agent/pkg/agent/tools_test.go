@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditFileCreates(t *testing.T) {
+	ws := workspace{root: t.TempDir()}
+	ctx := context.Background()
+
+	if _, err := ws.editFile(ctx, map[string]any{"path": "a/new.go", "new_str": "package a\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, filepath.Join(ws.root, "a", "new.go")); got != "package a\n" {
+		t.Errorf("got %q, want the new file", got)
+	}
+
+	// An empty old_str never overwrites a file.
+	_, err := ws.editFile(ctx, map[string]any{"path": "a/new.go", "new_str": ""})
+	if err == nil {
+		t.Fatal("got no error, want the existing file refused")
+	}
+
+	if got := read(t, filepath.Join(ws.root, "a", "new.go")); got != "package a\n" {
+		t.Errorf("got %q, want the file unchanged", got)
+	}
+}
+
+func TestGitDiffSkipsExternalDrivers(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+
+	git("init", "-q")
+	git("config", "diff.external", "false")
+	git("config", "diff.fake.textconv", "false")
+
+	if err := os.WriteFile(filepath.Join(root, ".gitattributes"), []byte("*.txt diff=fake\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-qm", "one")
+
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := gitRepo{root: root}.diff(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "-one") || !strings.Contains(out, "+two") {
+		t.Errorf("got %q, want git's own diff", out)
+	}
+}
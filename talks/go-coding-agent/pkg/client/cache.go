@@ -0,0 +1,356 @@
+package client
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores responses keyed by CacheKey. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Stats() CacheStats
+}
+
+// CacheStats reports how a cache has been performing.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int64 `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// CacheKey returns the content address for a request. Maps are marshaled with
+// sorted keys so the same request always produces the same key.
+func CacheKey(model string, body D) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write(data)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// =============================================================================
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache is an in-memory LRU cache.
+type MemoryCache struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// NewMemoryCache constructs an LRU cache. A zero maxEntries, maxBytes or ttl
+// means no limit for that dimension.
+func NewMemoryCache(maxEntries int, maxBytes int64, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.items[key]
+	if !exists {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	e := el.Value.(*memoryEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+
+	return e.value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxBytes > 0 && int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	if el, exists := c.items[key]; exists {
+		e := el.Value.(*memoryEntry)
+		c.bytes += int64(len(value) - len(e.value))
+		e.value = value
+		e.expires = expires
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+		c.bytes += int64(len(value))
+	}
+
+	for c.ll.Len() > 0 && ((c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   int64(c.ll.Len()),
+		Bytes:     c.bytes,
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*memoryEntry)
+	delete(c.items, e.key)
+	c.bytes -= int64(len(e.value))
+}
+
+// =============================================================================
+
+// DiskCache stores one file per key in a directory so cached responses
+// survive restarts. The files are named after the key with the diskCacheExt
+// extension, other files in the directory are left alone. The directory is scanned once when the cache is opened,
+// after that the entries and their size are tracked in memory, so files
+// written to it by another process aren't counted. The write time of an
+// entry is used for the TTL and to evict the oldest entries when the size
+// limit is reached.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// diskCacheExt is the extension of the files of a DiskCache.
+const diskCacheExt = ".llmcache"
+
+type diskEntry struct {
+	key     string
+	size    int64
+	written time.Time
+}
+
+// NewDiskCache constructs a cache rooted at dir, creating it if needed. A zero
+// maxBytes or ttl means no limit for that dimension.
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	c := DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+
+	infos, err := c.scan()
+	if err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	// The newest entry goes to the front, like after a Set.
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	for _, info := range infos {
+		key := strings.TrimSuffix(info.Name(), diskCacheExt)
+		c.items[key] = c.ll.PushFront(&diskEntry{key: key, size: info.Size(), written: info.ModTime()})
+		c.bytes += info.Size()
+	}
+
+	c.evict()
+
+	return &c, nil
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	el, exists := c.items[key]
+	if exists && c.ttl > 0 && time.Since(el.Value.(*diskEntry).written) > c.ttl {
+		c.remove(el)
+		exists = false
+	}
+	c.mu.Unlock()
+
+	if !exists {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		// The file was removed behind the cache's back.
+		c.mu.Lock()
+		if el, exists := c.items[key]; exists {
+			c.remove(el)
+		}
+		c.mu.Unlock()
+
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+
+	return data, true
+}
+
+func (c *DiskCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Write to a temp file and rename so readers never see a partial entry.
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+
+	_, werr := tmp.Write(value)
+	cerr := tmp.Close()
+	if err := errors.Join(werr, cerr); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	size := int64(len(value))
+
+	if el, exists := c.items[key]; exists {
+		e := el.Value.(*diskEntry)
+		c.bytes += size - e.size
+		e.size = size
+		e.written = time.Now()
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&diskEntry{key: key, size: size, written: time.Now()})
+		c.bytes += size
+	}
+
+	c.evict()
+}
+
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   int64(c.ll.Len()),
+		Bytes:     c.bytes,
+	}
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+diskCacheExt)
+}
+
+func (c *DiskCache) scan() ([]fs.FileInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(c.dir, "*"+diskCacheExt))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(matches))
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// evict removes the oldest entries until the cache fits in maxBytes. The
+// caller holds the lock.
+func (c *DiskCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	for c.ll.Len() > 0 && c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove drops the entry and its file. The caller holds the lock.
+func (c *DiskCache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*diskEntry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+
+	os.Remove(c.path(e.key))
+}
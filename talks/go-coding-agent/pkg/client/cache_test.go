@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMemoryCacheLRU(t *testing.T) {
+	c := NewMemoryCache(2, 0, 0)
+
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+
+	// Reading a makes b the least recently used.
+	if _, found := c.Get("a"); !found {
+		t.Fatal("a should be cached")
+	}
+
+	c.Set("c", []byte("3"))
+
+	if _, found := c.Get("b"); found {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found := c.Get(key); !found {
+			t.Errorf("%s should be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	want := CacheStats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2, Bytes: 2}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
+
+func TestMemoryCacheBytes(t *testing.T) {
+	c := NewMemoryCache(0, 10, 0)
+
+	c.Set("a", []byte("12345"))
+	c.Set("b", []byte("12345"))
+	c.Set("a", []byte("123"))
+
+	if stats := c.Stats(); stats.Bytes != 8 || stats.Entries != 2 {
+		t.Errorf("got stats %+v after replacing a, want 8 bytes in 2 entries", stats)
+	}
+
+	c.Set("c", []byte("12345"))
+
+	if _, found := c.Get("b"); found {
+		t.Error("b should have been evicted to fit c")
+	}
+
+	// A value larger than the cache isn't stored at all.
+	c.Set("big", make([]byte, 11))
+	if _, found := c.Get("big"); found {
+		t.Error("a value over the limit was cached")
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	c := NewMemoryCache(0, 0, time.Millisecond)
+
+	c.Set("a", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+
+	if _, found := c.Get("a"); found {
+		t.Error("an expired entry was returned")
+	}
+
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("got %d entries, want the expired one removed", stats.Entries)
+	}
+}
+
+// =============================================================================
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+
+	// Other files in the directory aren't entries.
+	other := filepath.Join(dir, "notes.json")
+	if err := os.WriteFile(other, []byte("1234567890"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewDiskCache(dir, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("a", []byte("12345"))
+	c.Set("b", []byte("12345"))
+	c.Set("c", []byte("12345"))
+
+	if _, err := os.Stat(filepath.Join(dir, "a"+diskCacheExt)); !os.IsNotExist(err) {
+		t.Errorf("the oldest entry is still on disk: %v", err)
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the other file was removed: %v", err)
+	}
+
+	want := CacheStats{Evictions: 1, Entries: 2, Bytes: 10}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	data, found := c.Get("c")
+	if !found || string(data) != "12345" {
+		t.Errorf("got %q, %t for c", data, found)
+	}
+}
+
+func TestDiskCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("a", []byte("12345"))
+	time.Sleep(10 * time.Millisecond)
+	c.Set("b", []byte("12345"))
+
+	// Opening it again with a lower limit finds the entries and evicts the
+	// oldest.
+	c, err = NewDiskCache(dir, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := c.Get("a"); found {
+		t.Error("a should have been evicted when the cache was opened")
+	}
+	if data, found := c.Get("b"); !found || string(data) != "12345" {
+		t.Errorf("got %q, %t for b", data, found)
+	}
+
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != 5 {
+		t.Errorf("got stats %+v, want 1 entry of 5 bytes", stats)
+	}
+}
+
+func TestDiskCacheTTL(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("a", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+
+	if _, found := c.Get("a"); found {
+		t.Error("an expired entry was returned")
+	}
+
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("got stats %+v, want the expired entry removed", stats)
+	}
+}
+
+// =============================================================================
+
+func TestCachedEmbedding(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{"data": [{"embedding": [0.5, 0.25]}]}`)
+	}))
+	defer srv.Close()
+
+	cache, err := NewDiskCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	llm := NewLLM(srv.URL, "embed", WithCache(cache), WithClientOptions(WithSlog(log), WithTelemetry(tp, noopmetric.NewMeterProvider())))
+
+	for range 2 {
+		v, err := llm.EmbedText(context.Background(), "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v[0] != 0.5 {
+			t.Fatalf("got embedding %v", v)
+		}
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want the second embedding from the cache", n)
+	}
+
+	// The hit is traced and logged like a request.
+	ended := spans.Ended()
+	if len(ended) != 2 || !slices.Contains(ended[1].Attributes(), attribute.Bool("gen_ai.response.cached", true)) {
+		t.Errorf("got %d spans, want the second marked cached", len(ended))
+	}
+
+	if !strings.Contains(buf.String(), `msg="client: cache hit"`) {
+		t.Errorf("got log:\n%s\nwant the cache hit", buf.String())
+	}
+}
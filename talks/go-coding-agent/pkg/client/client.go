@@ -9,9 +9,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,16 @@ const version = "v1.0.0"
 
 var ErrUnauthorized = errors.New("api understands the request but refuses to authorize it")
 
+// StatusError is returned when the api responds with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("error: status: %d response: %s", err.StatusCode, err.Message)
+}
+
 var defaultClient = http.Client{
 	Transport: &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -37,29 +48,21 @@ var defaultClient = http.Client{
 
 // =============================================================================
 
-type Logger func(context.Context, string, ...any)
-
-var NoopLogger = func(ctx context.Context, msg string, v ...any) {}
-
-var StdoutLogger = func(ctx context.Context, msg string, v ...any) {
-	s := fmt.Sprintf("msg: %s", msg)
-	for i := 0; i < len(v); i = i + 2 {
-		s = s + fmt.Sprintf(", %s: %v", v[i], v[i+1])
-	}
-	log.Println(s)
-}
-
-// =============================================================================
-
 type Client struct {
-	log  Logger
-	http *http.Client
+	log        *slog.Logger
+	http       *http.Client
+	userAgent  string
+	middleware []Middleware
+	tel        *telemetry
+	limiter    *Limiter
 }
 
 func New(log Logger, options ...func(cln *Client)) *Client {
 	cln := Client{
-		log:  log,
-		http: &defaultClient,
+		log:       slog.New(requestIDHandler{NewLoggerHandler(log, slog.LevelInfo)}),
+		http:      &defaultClient,
+		userAgent: fmt.Sprintf("Ardan Labs AI Training Sample Go Client: %s", version),
+		tel:       defaultTelemetry(),
 	}
 
 	for _, option := range options {
@@ -75,13 +78,33 @@ func WithClient(http *http.Client) func(cln *Client) {
 	}
 }
 
-func (cln *Client) Do(ctx context.Context, method string, endpoint string, body D, v any) error {
+func (cln *Client) Do(ctx context.Context, method string, endpoint string, body D, v any) (err error) {
+	ctx = ensureRequestID(ctx)
+	start := time.Now()
+
+	ctx, call := cln.tel.startCall(ctx, method, endpoint, body, false)
+
+	var status int
+	var usage *Usage
+	defer func() {
+		call.end(ctx, status, usage, err)
+		cln.logCall(ctx, "client: do", method, endpoint, status, start, usage, err)
+	}()
+
+	release, err := cln.acquire(ctx, body)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	resp, err := do(ctx, cln, method, endpoint, body)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	status = resp.StatusCode
+
 	if resp.StatusCode == http.StatusNoContent {
 		return nil
 	}
@@ -91,6 +114,12 @@ func (cln *Client) Do(ctx context.Context, method string, endpoint string, body
 		return fmt.Errorf("client: copy error: %w", err)
 	}
 
+	meta := parseMeta(data)
+	for _, choice := range meta.Choices {
+		call.finish(choice.FinishReason)
+	}
+	usage = meta.Usage
+
 	switch d := v.(type) {
 	case *string:
 		*d = string(data)
@@ -104,6 +133,20 @@ func (cln *Client) Do(ctx context.Context, method string, endpoint string, body
 	return nil
 }
 
+// cacheHit records a response served from the cache like Do records a call,
+// so the hit shows in the traces and the logs though no request went out.
+// The tokens of the cached response weren't used again and aren't counted.
+func (cln *Client) cacheHit(ctx context.Context, method string, endpoint string, body D) {
+	ctx = ensureRequestID(ctx)
+	start := time.Now()
+
+	ctx, call := cln.tel.startCall(ctx, method, endpoint, body, false)
+	call.cached()
+	call.end(ctx, 0, nil, nil)
+
+	cln.logCall(ctx, "client: cache hit", method, endpoint, 0, start, nil, nil)
+}
+
 // =============================================================================
 
 type SSEClient[T any] struct {
@@ -119,14 +162,37 @@ func NewSSE[T any](log Logger, options ...func(cln *Client)) *SSEClient[T] {
 }
 
 func (cln *SSEClient[T]) Do(ctx context.Context, method string, endpoint string, body D, ch chan T) error {
+	ctx = ensureRequestID(ctx)
+	start := time.Now()
+
+	ctx, call := cln.tel.startCall(ctx, method, endpoint, body, true)
+
+	release, err := cln.acquire(ctx, body)
+	if err != nil {
+		call.end(ctx, 0, nil, err)
+		cln.logCall(ctx, "sseclient: do", method, endpoint, 0, start, nil, err)
+		return err
+	}
+
 	resp, err := do(ctx, cln.Client, method, endpoint, body)
 	if err != nil {
+		release()
+		call.end(ctx, 0, nil, err)
+		cln.logCall(ctx, "sseclient: do", method, endpoint, 0, start, nil, err)
 		return err
 	}
 
 	go func(ctx context.Context) {
+		var usage *Usage
+		var err error
+
+		// The channel is closed last so the call is recorded by the time
+		// the caller sees the end of the stream.
 		defer func() {
 			resp.Body.Close()
+			release()
+			call.end(ctx, resp.StatusCode, usage, err)
+			cln.logCall(ctx, "sseclient: do", method, endpoint, resp.StatusCode, start, usage, err)
 			close(ch)
 		}()
 
@@ -134,13 +200,34 @@ func (cln *SSEClient[T]) Do(ctx context.Context, method string, endpoint string,
 		for scanner.Scan() {
 			line := scanner.Text()
 
-			if line == "" || line == "data: [DONE]" {
+			if !strings.HasPrefix(line, "data: ") || line == "data: [DONE]" {
 				continue
 			}
 
+			var chunk []byte
+			chunk, err = cln.streamChunk(ctx, []byte(line[6:]))
+			if err != nil {
+				err = fmt.Errorf("on stream chunk: %w", err)
+				return
+			}
+
+			if chunk == nil {
+				continue
+			}
+
+			meta := parseMeta(chunk)
+			if meta.Usage != nil {
+				usage = meta.Usage
+			}
+
+			call.chunk(ctx)
+			for _, choice := range meta.Choices {
+				call.finish(choice.FinishReason)
+			}
+
 			var v T
-			if err := json.Unmarshal([]byte(line[6:]), &v); err != nil {
-				cln.log(ctx, "sseclient: rawRequest:", "Unmarshal", err, "line", line[6:])
+			if err = json.Unmarshal(chunk, &v); err != nil {
+				err = fmt.Errorf("unmarshal: line: %s: %w", chunk, err)
 				return
 			}
 
@@ -148,10 +235,12 @@ func (cln *SSEClient[T]) Do(ctx context.Context, method string, endpoint string,
 			case ch <- v:
 
 			case <-ctx.Done():
-				cln.log(ctx, "sseclient: rawRequest:", "Context", ctx.Err().Error())
+				err = ctx.Err()
 				return
 			}
 		}
+
+		err = scanner.Err()
 	}(ctx)
 
 	return nil
@@ -159,7 +248,7 @@ func (cln *SSEClient[T]) Do(ctx context.Context, method string, endpoint string,
 
 // =============================================================================
 
-func do(ctx context.Context, cln *Client, method string, endpoint string, body any) (*http.Response, error) {
+func do(ctx context.Context, cln *Client, method string, endpoint string, body D) (*http.Response, error) {
 	var statusCode int
 
 	var b bytes.Buffer
@@ -177,9 +266,10 @@ func do(ctx context.Context, cln *Client, method string, endpoint string, body a
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", fmt.Sprintf("Ardan Labs AI Training Sample Go Client: %s", version))
+	req.Header.Set("User-Agent", cln.userAgent)
+	req.Header.Set("X-Request-ID", RequestID(ctx))
 
-	resp, err := cln.http.Do(req)
+	resp, err := cln.roundTrip()(req)
 	if err != nil {
 		return nil, fmt.Errorf("do: error: %w", err)
 	}
@@ -193,6 +283,7 @@ func do(ctx context.Context, cln *Client, method string, endpoint string, body a
 
 	default:
 		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
 			return nil, fmt.Errorf("readall: error: %w", err)
 		}
@@ -202,12 +293,66 @@ func do(ctx context.Context, cln *Client, method string, endpoint string, body a
 			return nil, ErrUnauthorized
 
 		default:
-			var err Error
-			if err := json.Unmarshal(data, &err); err != nil {
-				return nil, fmt.Errorf("decoding: response: %s, error: %w ", string(data), err)
+			var apiErr Error
+			if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Err.Message == "" {
+				return nil, &StatusError{StatusCode: statusCode, Message: string(data)}
 			}
 
-			return nil, fmt.Errorf("error: status: %d response: %s", statusCode, err.Err.Message)
+			return nil, &StatusError{StatusCode: statusCode, Message: apiErr.Err.Message}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// logCall writes a single log entry describing a completed call.
+func (cln *Client) logCall(ctx context.Context, msg string, method string, endpoint string, status int, start time.Time, usage *Usage, err error) {
+	attrs := []any{
+		"method", method,
+		"endpoint", endpoint,
+		"latency", time.Since(start).Round(time.Millisecond),
+	}
+
+	var se *StatusError
+	if status == 0 && errors.As(err, &se) {
+		status = se.StatusCode
+	}
+
+	if status != 0 {
+		attrs = append(attrs, "status", status)
+	}
+
+	if usage != nil {
+		attrs = append(attrs,
+			"prompt_tokens", usage.PromptTokens,
+			"completion_tokens", usage.CompletionTokens,
+			"total_tokens", usage.TotalTokens)
+	}
+
+	if err != nil {
+		cln.log.ErrorContext(ctx, msg, append(attrs, "err", err)...)
+		return
+	}
+
+	cln.log.InfoContext(ctx, msg, attrs...)
+}
+
+// meta is the part of a response body the client looks at for logging and
+// telemetry, regardless of the type the caller decodes into.
+type meta struct {
+	Usage   *Usage `json:"usage"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// parseMeta extracts the token usage and finish reason from a response body,
+// if present.
+func parseMeta(data []byte) meta {
+	var m meta
+	if !bytes.Contains(data, []byte(`"usage"`)) && !bytes.Contains(data, []byte(`"finish_reason"`)) {
+		return m
+	}
+
+	json.Unmarshal(data, &m)
+
+	return m
+}
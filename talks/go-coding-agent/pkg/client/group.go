@@ -0,0 +1,361 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoEndpoint is returned when every endpoint in a group is unhealthy.
+var ErrNoEndpoint = errors.New("no healthy endpoint available")
+
+// IsContextOverflow reports whether the error is the server rejecting a
+// request because the prompt doesn't fit in the model's context window.
+func IsContextOverflow(err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	msg := strings.ToLower(se.Message)
+	for _, s := range []string{"context length", "context_length", "maximum context", "context window", "too many tokens"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// =============================================================================
+
+// Strategy decides the order in which the endpoints of a group are tried.
+type Strategy int
+
+// Set of routing strategies.
+const (
+	RoundRobin Strategy = iota
+	LeastLoaded
+)
+
+// BreakerState is the circuit breaker state of an endpoint.
+type BreakerState int
+
+// Set of breaker states. An open breaker lets a single probe through once the
+// cooldown has passed, moving to half-open until that probe completes.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// EndpointHealth reports the state of a single endpoint in a group.
+type EndpointHealth struct {
+	URL      string `json:"url"`
+	Model    string `json:"model"`
+	Fallback bool   `json:"fallback"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	InFlight int64  `json:"in_flight"`
+}
+
+type member struct {
+	llm      *LLM
+	fallback bool
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a request can be sent to the endpoint.
+func (m *member) allow(cooldown time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case BreakerOpen:
+		if time.Since(m.openedAt) < cooldown {
+			return false
+		}
+		m.state = BreakerHalfOpen
+		return true
+
+	case BreakerHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+func (m *member) success() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = BreakerClosed
+	m.failures = 0
+}
+
+func (m *member) failure(maxFailures int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures++
+	if m.state == BreakerHalfOpen || m.failures >= maxFailures {
+		m.state = BreakerOpen
+		m.openedAt = time.Now()
+	}
+}
+
+// release gives back a half-open probe slot when the call was canceled or
+// rejected and didn't tell us anything about the endpoint's health.
+func (m *member) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state == BreakerHalfOpen {
+		m.state = BreakerOpen
+	}
+}
+
+// =============================================================================
+
+// LLMGroup spreads requests across several endpoints. Endpoints that keep
+// failing are taken out of rotation by a circuit breaker. A request failing
+// on an endpoint is tried on the next one, and when every primary endpoint
+// fails, or the prompt overflows the primary model's context, the fallback
+// endpoints are tried in order.
+type LLMGroup struct {
+	primary     []*member
+	fallback    []*member
+	strategy    Strategy
+	maxFailures int
+	cooldown    time.Duration
+	next        atomic.Uint64
+}
+
+// NewLLMGroup constructs a group over the primary endpoints.
+func NewLLMGroup(llms []*LLM, options ...func(g *LLMGroup)) *LLMGroup {
+	g := LLMGroup{
+		strategy:    RoundRobin,
+		maxFailures: 3,
+		cooldown:    30 * time.Second,
+	}
+
+	for _, llm := range llms {
+		g.primary = append(g.primary, &member{llm: llm})
+	}
+
+	for _, option := range options {
+		option(&g)
+	}
+
+	return &g
+}
+
+// WithStrategy sets the routing strategy, RoundRobin by default.
+func WithStrategy(strategy Strategy) func(g *LLMGroup) {
+	return func(g *LLMGroup) {
+		g.strategy = strategy
+	}
+}
+
+// WithCircuitBreaker sets the number of consecutive failures that open an
+// endpoint's breaker and how long it stays open. Defaults to 3 and 30s.
+func WithCircuitBreaker(maxFailures int, cooldown time.Duration) func(g *LLMGroup) {
+	return func(g *LLMGroup) {
+		g.maxFailures = maxFailures
+		g.cooldown = cooldown
+	}
+}
+
+// WithFallback adds endpoints, usually serving a different model, that are
+// only used when the primary endpoints can't serve the request.
+func WithFallback(llms ...*LLM) func(g *LLMGroup) {
+	return func(g *LLMGroup) {
+		for _, llm := range llms {
+			g.fallback = append(g.fallback, &member{llm: llm, fallback: true})
+		}
+	}
+}
+
+// Health returns the state of every endpoint in the group.
+func (g *LLMGroup) Health() []EndpointHealth {
+	var health []EndpointHealth
+	for _, m := range slices.Concat(g.primary, g.fallback) {
+		m.mu.Lock()
+		health = append(health, EndpointHealth{
+			URL:      m.llm.url,
+			Model:    m.llm.model,
+			Fallback: m.fallback,
+			State:    m.state.String(),
+			Failures: m.failures,
+			InFlight: m.inFlight.Load(),
+		})
+		m.mu.Unlock()
+	}
+
+	return health
+}
+
+// ChatCompletions sends the chat request to the first endpoint able to
+// serve it.
+func (g *LLMGroup) ChatCompletions(ctx context.Context, text string, options ...Option) (ChatResult, error) {
+	return groupDo(ctx, g, func(m *member) (ChatResult, error) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		return m.llm.ChatCompletions(ctx, text, options...)
+	})
+}
+
+// ChatCompletionsSSE starts the stream on the first endpoint able to serve
+// it. Once the stream has started it can't fail over.
+func (g *LLMGroup) ChatCompletionsSSE(ctx context.Context, content string, options ...Option) (chan ChatSSE, error) {
+	return groupDo(ctx, g, func(m *member) (chan ChatSSE, error) {
+		// The endpoint stays counted as loaded until the stream is drained.
+		m.inFlight.Add(1)
+
+		ch, err := m.llm.ChatCompletionsSSE(ctx, content, options...)
+		if err != nil {
+			m.inFlight.Add(-1)
+			return nil, err
+		}
+
+		out := make(chan ChatSSE, cap(ch))
+		go func() {
+			defer func() {
+				m.inFlight.Add(-1)
+				close(out)
+			}()
+
+			for resp := range ch {
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return out, nil
+	})
+}
+
+// EmbedText embeds the input using the first endpoint able to serve it.
+func (g *LLMGroup) EmbedText(ctx context.Context, input string) ([]float64, error) {
+	return groupDo(ctx, g, func(m *member) ([]float64, error) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		return m.llm.EmbedText(ctx, input)
+	})
+}
+
+// candidates returns the primary endpoints in the order the strategy wants
+// them tried, followed by the fallback endpoints.
+func (g *LLMGroup) candidates() ([]*member, []*member) {
+	primary := slices.Clone(g.primary)
+
+	switch g.strategy {
+	case LeastLoaded:
+		slices.SortStableFunc(primary, func(a, b *member) int {
+			return int(a.inFlight.Load() - b.inFlight.Load())
+		})
+
+	default:
+		if n := len(primary); n > 0 {
+			start := int(g.next.Add(1)-1) % n
+			primary = slices.Concat(primary[start:], primary[:start])
+		}
+	}
+
+	return primary, g.fallback
+}
+
+// groupDo calls fn with each endpoint in turn until one succeeds. fn counts
+// the requests in flight on the endpoint, since a stream holds it longer
+// than the call.
+func groupDo[T any](ctx context.Context, g *LLMGroup, fn func(m *member) (T, error)) (T, error) {
+	var zero T
+	var errs []error
+
+	primary, fallback := g.candidates()
+	overflowed := make(map[string]bool)
+
+	for _, m := range slices.Concat(primary, fallback) {
+		if overflowed[m.llm.model] || !m.allow(g.cooldown) {
+			continue
+		}
+
+		v, err := fn(m)
+
+		if err == nil {
+			m.success()
+			return v, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s[%s]: %w", m.llm.url, m.llm.model, err))
+
+		switch {
+		case ctx.Err() != nil:
+			m.release()
+			return zero, ctx.Err()
+
+		case IsContextOverflow(err):
+			// The endpoint is healthy, but every endpoint serving this
+			// model will overflow as well.
+			m.success()
+			overflowed[m.llm.model] = true
+
+		case unhealthy(err):
+			m.failure(g.maxFailures)
+
+		default:
+			// A rejected request says nothing about the endpoint's health,
+			// so the breaker is left as it was, but another endpoint or
+			// model may accept it, like when the model isn't installed on
+			// this server.
+			m.release()
+		}
+	}
+
+	if len(errs) == 0 {
+		return zero, ErrNoEndpoint
+	}
+
+	return zero, errors.Join(errs...)
+}
+
+// unhealthy reports whether the error counts against the endpoint for its
+// circuit breaker: the server failing, being overloaded or unreachable.
+func unhealthy(err error) bool {
+	if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrNotSupported) {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= http.StatusInternalServerError || se.StatusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// endpoint is a chat server answering every request with the status and,
+// on success, the answer. It counts the requests it gets.
+type endpoint struct {
+	*httptest.Server
+	requests atomic.Int64
+}
+
+func newEndpoint(t *testing.T, status int, answer string) *endpoint {
+	t.Helper()
+
+	var e endpoint
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.requests.Add(1)
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"error": {"message": %q}}`, answer)
+			return
+		}
+
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, answer)
+	}))
+	t.Cleanup(e.Close)
+
+	return &e
+}
+
+func (e *endpoint) llm(model string) *LLM {
+	return NewLLM(e.URL, model, WithClientOptions(WithSlog(nil)))
+}
+
+func TestGroupFallsBackOnClientError(t *testing.T) {
+	missing := newEndpoint(t, http.StatusNotFound, "model not found")
+	ok := newEndpoint(t, http.StatusOK, "hello")
+
+	g := NewLLMGroup([]*LLM{missing.llm("m"), ok.llm("m")})
+
+	result, err := g.ChatCompletions(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("got %s, want the second endpoint's answer", err)
+	}
+	if result.Content != "hello" {
+		t.Errorf("got %q", result.Content)
+	}
+
+	// The endpoint answered, it's not counted as failing.
+	if h := g.Health()[0]; h.State != "closed" || h.Failures != 0 {
+		t.Errorf("got health %+v for the endpoint rejecting the request", h)
+	}
+}
+
+func TestGroupCircuitBreaker(t *testing.T) {
+	broken := newEndpoint(t, http.StatusInternalServerError, "boom")
+	ok := newEndpoint(t, http.StatusOK, "hello")
+
+	g := NewLLMGroup([]*LLM{broken.llm("m"), ok.llm("m")}, WithCircuitBreaker(2, time.Hour))
+
+	for range 6 {
+		if _, err := g.ChatCompletions(context.Background(), "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Round robin starts every other request on the broken endpoint, which
+	// is left alone once it failed twice.
+	if n := broken.requests.Load(); n != 2 {
+		t.Errorf("the broken endpoint got %d requests, want 2", n)
+	}
+
+	if h := g.Health()[0]; h.State != "open" {
+		t.Errorf("got state %s, want open", h.State)
+	}
+}
+
+func TestGroupBreakerProbe(t *testing.T) {
+	broken := newEndpoint(t, http.StatusInternalServerError, "boom")
+
+	g := NewLLMGroup([]*LLM{broken.llm("m")}, WithCircuitBreaker(1, 10*time.Millisecond))
+
+	if _, err := g.ChatCompletions(context.Background(), "hi"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := g.ChatCompletions(context.Background(), "hi"); !errors.Is(err, ErrNoEndpoint) {
+		t.Fatalf("got %v while the breaker is open, want ErrNoEndpoint", err)
+	}
+
+	// After the cooldown a single probe goes through.
+	time.Sleep(20 * time.Millisecond)
+
+	g.ChatCompletions(context.Background(), "hi")
+	if n := broken.requests.Load(); n != 2 {
+		t.Errorf("got %d requests, want the probe after the cooldown", n)
+	}
+}
+
+func TestGroupRejectedKeepsBreaker(t *testing.T) {
+	statuses := []int{http.StatusInternalServerError, http.StatusNotFound, http.StatusInternalServerError}
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests.Add(1)-1])
+		fmt.Fprint(w, `{"error": {"message": "boom"}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	g := NewLLMGroup([]*LLM{NewLLM(srv.URL, "m", WithClientOptions(WithSlog(nil)))}, WithCircuitBreaker(2, time.Hour))
+
+	for range statuses {
+		g.ChatCompletions(context.Background(), "hi")
+	}
+
+	// The rejected request in between doesn't clear the first failure.
+	if h := g.Health()[0]; h.State != "open" || h.Failures != 2 {
+		t.Errorf("got health %+v, want the breaker open after two failures", h)
+	}
+}
+
+func TestGroupContextOverflow(t *testing.T) {
+	small1 := newEndpoint(t, http.StatusBadRequest, "maximum context length is 8192 tokens")
+	small2 := newEndpoint(t, http.StatusOK, "small")
+	big := newEndpoint(t, http.StatusOK, "big")
+
+	g := NewLLMGroup([]*LLM{small1.llm("small"), small2.llm("small")}, WithFallback(big.llm("big")), WithStrategy(LeastLoaded))
+
+	result, err := g.ChatCompletions(context.Background(), "a long prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The other endpoint serving the same model would overflow as well.
+	if result.Content != "big" || small2.requests.Load() != 0 {
+		t.Errorf("got %q with %d requests to the other small endpoint, want the fallback's answer", result.Content, small2.requests.Load())
+	}
+}
+
+func TestGroupStreamInFlight(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {\"content\": \"hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	g := NewLLMGroup([]*LLM{NewLLM(srv.URL, "m", WithClientOptions(WithSlog(nil)))})
+
+	ch, err := g.ChatCompletionsSSE(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := g.Health()[0].InFlight; n != 1 {
+		t.Errorf("got %d in flight while streaming, want 1", n)
+	}
+
+	result, err := Collect(ch)
+	if err != nil || result.Content != "hi" {
+		t.Fatalf("got %q, %v", result.Content, err)
+	}
+
+	if n := g.Health()[0].InFlight; n != 0 {
+		t.Errorf("got %d in flight after the stream, want 0", n)
+	}
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Priority decides which queued request gets the next free slot.
+type Priority int
+
+// Set of priorities. Requests default to PriorityInteractive so a batch job
+// has to opt in to PriorityBackground to stay out of the agent's way.
+const (
+	PriorityBackground Priority = iota
+	PriorityInteractive
+)
+
+func (p Priority) String() string {
+	if p == PriorityBackground {
+		return "background"
+	}
+	return "interactive"
+}
+
+const priorityKey ctxKey = 2
+
+// ContextWithPriority sets the priority used when the request has to wait
+// for a slot in the limiter.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey, p)
+}
+
+// PriorityFromContext returns the priority stored in the context.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey).(Priority); ok {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// =============================================================================
+
+// LimiterStats reports how long requests waited for a slot.
+type LimiterStats struct {
+	InFlight  int           `json:"in_flight"`
+	Queued    int           `json:"queued"`
+	Admitted  int64         `json:"admitted"`
+	Waited    int64         `json:"waited"`
+	Canceled  int64         `json:"canceled"`
+	TotalWait time.Duration `json:"total_wait"`
+	MaxWait   time.Duration `json:"max_wait"`
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	model    string
+	ready    chan struct{}
+	admitted bool
+}
+
+// Limiter bounds the number of requests in flight, overall and per model.
+// Requests that can't run right away wait in a queue ordered by priority and
+// then arrival.
+type Limiter struct {
+	maxInFlight int
+	modelLimits map[string]int
+
+	mu            sync.Mutex
+	inFlight      int
+	modelInFlight map[string]int
+	queue         []*waiter
+	seq           uint64
+	stats         LimiterStats
+}
+
+// NewLimiter constructs a limiter allowing maxInFlight concurrent requests.
+// A maxInFlight of 0 means no overall limit.
+func NewLimiter(maxInFlight int, options ...func(l *Limiter)) *Limiter {
+	l := Limiter{
+		maxInFlight:   maxInFlight,
+		modelLimits:   make(map[string]int),
+		modelInFlight: make(map[string]int),
+	}
+
+	for _, option := range options {
+		option(&l)
+	}
+
+	return &l
+}
+
+// WithModelLimit bounds the concurrent requests for a single model.
+func WithModelLimit(model string, n int) func(l *Limiter) {
+	return func(l *Limiter) {
+		l.modelLimits[model] = n
+	}
+}
+
+// WithLimiter makes the client wait for a slot in the limiter before sending
+// a request. For streams the slot is held until the stream ends.
+func WithLimiter(l *Limiter) func(cln *Client) {
+	return func(cln *Client) {
+		cln.limiter = l
+	}
+}
+
+// acquire waits for a slot when the client has a limiter.
+func (cln *Client) acquire(ctx context.Context, body D) (func(), error) {
+	if cln.limiter == nil {
+		return func() {}, nil
+	}
+
+	model, _ := body["model"].(string)
+
+	release, wait, err := cln.limiter.Acquire(ctx, model)
+	cln.tel.queued(ctx, model, PriorityFromContext(ctx), wait)
+
+	if err != nil {
+		return nil, fmt.Errorf("limiter: %w", err)
+	}
+
+	return release, nil
+}
+
+// Acquire waits for a slot for the model, honoring the priority set in the
+// context. The returned function must be called to release the slot. If the
+// context is canceled while waiting the context error is returned.
+func (l *Limiter) Acquire(ctx context.Context, model string) (func(), time.Duration, error) {
+	start := time.Now()
+
+	l.mu.Lock()
+
+	l.seq++
+	w := waiter{
+		priority: PriorityFromContext(ctx),
+		seq:      l.seq,
+		model:    model,
+		ready:    make(chan struct{}),
+	}
+
+	i, _ := slices.BinarySearchFunc(l.queue, &w, compareWaiters)
+	l.queue = slices.Insert(l.queue, i, &w)
+	l.dispatch()
+
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		// The slot may have been handed over right as the context was
+		// canceled, in which case we have to give it back.
+		if w.admitted {
+			l.release(model)
+		} else {
+			l.queue = slices.DeleteFunc(l.queue, func(q *waiter) bool { return q == &w })
+		}
+
+		l.stats.Canceled++
+
+		return nil, time.Since(start), ctx.Err()
+	}
+
+	wait := time.Since(start)
+
+	l.mu.Lock()
+	if wait > time.Millisecond {
+		l.stats.Waited++
+	}
+	l.stats.TotalWait += wait
+	l.stats.MaxWait = max(l.stats.MaxWait, wait)
+	l.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.release(model)
+		})
+	}
+
+	return release, wait, nil
+}
+
+// Stats returns a snapshot of the limiter's state.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.stats
+	s.InFlight = l.inFlight
+	s.Queued = len(l.queue)
+
+	return s
+}
+
+// release frees a slot and hands it to the next waiter. The caller must hold
+// the lock.
+func (l *Limiter) release(model string) {
+	l.inFlight--
+	l.modelInFlight[model]--
+	l.dispatch()
+}
+
+// dispatch admits queued waiters, highest priority first, while there are
+// free slots. A waiter blocked by its model's limit doesn't hold back waiters
+// for other models. The caller must hold the lock.
+func (l *Limiter) dispatch() {
+	for i := 0; i < len(l.queue); {
+		if l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+			return
+		}
+
+		w := l.queue[i]
+		if n, exists := l.modelLimits[w.model]; exists && l.modelInFlight[w.model] >= n {
+			i++
+			continue
+		}
+
+		l.queue = slices.Delete(l.queue, i, i+1)
+		l.inFlight++
+		l.modelInFlight[w.model]++
+		l.stats.Admitted++
+
+		w.admitted = true
+		close(w.ready)
+	}
+}
+
+func compareWaiters(a, b *waiter) int {
+	if a.priority != b.priority {
+		return int(b.priority) - int(a.priority)
+	}
+
+	switch {
+	case a.seq < b.seq:
+		return -1
+	case a.seq > b.seq:
+		return 1
+	}
+
+	return 0
+}
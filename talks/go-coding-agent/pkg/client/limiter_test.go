@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queued waits until n requests wait in the limiter.
+func queued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+
+	for range 1000 {
+		if l.Stats().Queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("got %d queued, want %d", l.Stats().Queued, n)
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := NewLimiter(1)
+
+	release, _, err := l.Acquire(context.Background(), "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan Priority, 2)
+	acquire := func(p Priority) {
+		release, _, err := l.Acquire(ContextWithPriority(context.Background(), p), "m")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		admitted <- p
+		release()
+	}
+
+	// The background request queues first but the interactive one goes
+	// ahead of it.
+	go acquire(PriorityBackground)
+	queued(t, l, 1)
+	go acquire(PriorityInteractive)
+	queued(t, l, 2)
+
+	release()
+
+	if first, second := <-admitted, <-admitted; first != PriorityInteractive || second != PriorityBackground {
+		t.Errorf("admitted %s then %s, want interactive first", first, second)
+	}
+
+	stats := l.Stats()
+	if stats.InFlight != 0 || stats.Queued != 0 || stats.Admitted != 3 {
+		t.Errorf("got stats %+v, want 3 admitted and none left", stats)
+	}
+}
+
+func TestLimiterModelLimit(t *testing.T) {
+	l := NewLimiter(0, WithModelLimit("big", 1))
+
+	release, _, err := l.Acquire(context.Background(), "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	done := make(chan struct{})
+	go func() {
+		release, _, err := l.Acquire(context.Background(), "big")
+		if err == nil {
+			release()
+		}
+		close(done)
+	}()
+	queued(t, l, 1)
+
+	// A request for another model isn't held back by the queued one.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	small, _, err := l.Acquire(ctx, "small")
+	if err != nil {
+		t.Fatalf("small waited behind big: %s", err)
+	}
+	small()
+
+	release()
+	<-done
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1)
+
+	release, _, err := l.Acquire(context.Background(), "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := l.Acquire(ctx, "m"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context error", err)
+	}
+
+	if stats := l.Stats(); stats.Queued != 0 || stats.Canceled != 1 || stats.InFlight != 1 {
+		t.Errorf("got stats %+v, want the canceled request gone from the queue", stats)
+	}
+}
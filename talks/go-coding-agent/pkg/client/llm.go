@@ -3,9 +3,13 @@ package client
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"maps"
 	"net/http"
+	"os"
+	"sync"
 )
 
 type LLM struct {
@@ -13,38 +17,102 @@ type LLM struct {
 	clnSSE *SSEClient[ChatSSE]
 	url    string
 	model  string
+	strict bool
+	cache  Cache
+
+	mu      sync.Mutex
+	info    *ModelInfo
+	infoErr error
+	warned  map[string]bool
 }
 
-func NewLLM(url string, model string) *LLM {
-	return &LLM{
-		cln:    New(StdoutLogger),
-		clnSSE: NewSSE[ChatSSE](StdoutLogger),
+// NewLLM constructs an LLM for the model served at the url. Only warnings
+// and errors are logged by default, to stderr so they don't mix with the
+// answers a program prints, WithClientOptions(WithSlog(log)) changes that.
+func NewLLM(url string, model string, options ...func(llm *LLM)) *LLM {
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	llm := LLM{
+		cln:    New(NoopLogger, WithSlog(log)),
+		clnSSE: NewSSE[ChatSSE](NoopLogger, WithSlog(log)),
 		url:    url,
 		model:  model,
 	}
+
+	for _, option := range options {
+		option(&llm)
+	}
+
+	return &llm
+}
+
+// URL returns the endpoint the LLM sends requests to.
+func (llm *LLM) URL() string {
+	return llm.url
+}
+
+// Model returns the name of the model the LLM uses.
+func (llm *LLM) Model() string {
+	return llm.model
+}
+
+// WithClientOptions applies the client options, like middleware, to the
+// clients used by the LLM.
+func WithClientOptions(options ...func(cln *Client)) func(llm *LLM) {
+	return func(llm *LLM) {
+		for _, option := range options {
+			option(llm.cln)
+			option(llm.clnSSE.Client)
+		}
+	}
+}
+
+// WithStrictCapabilities makes calls fail when they use a capability the
+// model is known not to support, like sending an image to a text only model.
+// By default only a warning is logged.
+func WithStrictCapabilities() func(llm *LLM) {
+	return func(llm *LLM) {
+		llm.strict = true
+	}
+}
+
+// WithCache enables the response cache for non-streaming chat requests with
+// a temperature of 0 and for embeddings. Any other request is never cached
+// since the response isn't deterministic.
+func WithCache(cache Cache) func(llm *LLM) {
+	return func(llm *LLM) {
+		llm.cache = cache
+	}
 }
 
-type withParam struct {
+// Option configures a single chat or embedding request.
+type Option struct {
 	typ string
 	d   D
 }
 
-func WithImage(mimeType string, image []byte) withParam {
+func WithImage(mimeType string, image []byte) Option {
+	return Option{
+		typ: "image",
+		d:   ImagePart(mimeType, image),
+	}
+}
+
+// ImagePart returns the content part of a message carrying the image as a
+// data URL, the way WithImage sends it.
+func ImagePart(mimeType string, image []byte) D {
 	dataBase64 := base64.StdEncoding.EncodeToString(image)
 
-	return withParam{
-		typ: "image",
-		d: D{
-			"type": "image_url",
-			"image_url": D{
-				"url": fmt.Sprintf("data:%s;base64,%s", mimeType, dataBase64),
-			},
+	return D{
+		"type": "image_url",
+		"image_url": D{
+			"url": fmt.Sprintf("data:%s;base64,%s", mimeType, dataBase64),
 		},
 	}
 }
 
-func WithParams(temperature float32, topP float32, topK int) withParam {
-	return withParam{
+func WithParams(temperature float32, topP float32, topK int) Option {
+	return Option{
 		typ: "params",
 		d: D{
 			"temperature": temperature,
@@ -54,8 +122,8 @@ func WithParams(temperature float32, topP float32, topK int) withParam {
 	}
 }
 
-func WithRepeatPenalty(penalty float32, lastN int) withParam {
-	return withParam{
+func WithRepeatPenalty(penalty float32, lastN int) Option {
+	return Option{
 		typ: "repeat",
 		d: D{
 			"repeat_penalty": penalty,
@@ -64,8 +132,79 @@ func WithRepeatPenalty(penalty float32, lastN int) withParam {
 	}
 }
 
-func (llm *LLM) ChatCompletions(ctx context.Context, text string, options ...withParam) (string, error) {
+// WithMaxTokens bounds the number of tokens the model can generate.
+func WithMaxTokens(n int) Option {
+	return Option{
+		typ: "body",
+		d:   D{"max_tokens": n},
+	}
+}
+
+// WithStop sets the sequences that stop the generation.
+func WithStop(stop ...string) Option {
+	return Option{
+		typ: "body",
+		d:   D{"stop": stop},
+	}
+}
+
+// WithSeed makes sampling reproducible on servers that support it.
+func WithSeed(seed int) Option {
+	return Option{
+		typ: "body",
+		d:   D{"seed": seed},
+	}
+}
+
+// WithSystemPrompt adds a system message in front of the conversation. It's
+// ignored when the conversation passed with WithConversation already starts
+// with a system message.
+func WithSystemPrompt(prompt string) Option {
+	return Option{
+		typ: "system",
+		d: D{
+			"role":    "system",
+			"content": prompt,
+		},
+	}
+}
+
+// WithTools makes the tools available to the model, which decides when to
+// call them.
+func WithTools(tools ...D) Option {
+	return Option{
+		typ: "tools",
+		d: D{
+			"tools":       tools,
+			"tool_choice": "auto",
+		},
+	}
+}
+
+// WithResponseFormat constrains the output, for example D{"type": "json_object"}
+// or a json_schema format.
+func WithResponseFormat(format D) Option {
+	return Option{
+		typ: "body",
+		d:   D{"response_format": format},
+	}
+}
+
+// WithConversation sends the messages ahead of the text, which allows a whole
+// conversation to be passed. When the text is empty the conversation is sent
+// as is.
+func WithConversation(messages []D) Option {
+	return Option{
+		typ: "conversation",
+		d:   D{"messages": messages},
+	}
+}
+
+// request builds the request body shared by the chat calls.
+func (llm *LLM) request(ctx context.Context, text string, options []Option) (D, error) {
 	var images []D
+	var system D
+	var conversation []D
 
 	params := D{
 		"temperature": 1.0,
@@ -73,7 +212,7 @@ func (llm *LLM) ChatCompletions(ctx context.Context, text string, options ...wit
 		"top_k":       20,
 	}
 
-	var repeatParams D
+	extra := D{}
 
 	for _, opt := range options {
 		switch opt.typ {
@@ -81,56 +220,104 @@ func (llm *LLM) ChatCompletions(ctx context.Context, text string, options ...wit
 			images = append(images, opt.d)
 		case "params":
 			params = opt.d
-		case "repeat":
-			repeatParams = opt.d
+		case "system":
+			system = opt.d
+		case "conversation":
+			conversation = append(conversation, opt.d["messages"].([]D)...)
+		case "tools":
+			if err := llm.check(ctx, CapabilityTools); err != nil {
+				return nil, err
+			}
+			maps.Copy(extra, opt.d)
+		default:
+			maps.Copy(extra, opt.d)
 		}
 	}
 
-	var content any = text
-	if len(images) > 0 {
-		content = append([]D{{"type": "text", "text": text}}, images...)
+	var messages []D
+	if system != nil && (len(conversation) == 0 || conversation[0]["role"] != "system") {
+		messages = append(messages, system)
+	}
+	messages = append(messages, conversation...)
+
+	if text != "" || len(images) > 0 {
+		var content any = text
+		if len(images) > 0 {
+			if err := llm.check(ctx, CapabilityVision); err != nil {
+				return nil, err
+			}
+
+			content = append([]D{{"type": "text", "text": text}}, images...)
+		}
+
+		messages = append(messages, D{
+			"role":    "user",
+			"content": content,
+		})
 	}
 
 	d := D{
-		"model": llm.model,
-		"messages": []D{
-			{
-				"role":    "user",
-				"content": content,
-			},
-		},
+		"model":    llm.model,
+		"messages": messages,
 	}
 
 	maps.Copy(d, params)
-	maps.Copy(d, repeatParams)
+	maps.Copy(d, extra)
+
+	return d, nil
+}
+
+// ChatCompletions sends the chat request and returns the answer with the
+// tool calls the model made and why it stopped.
+func (llm *LLM) ChatCompletions(ctx context.Context, text string, options ...Option) (ChatResult, error) {
+	d, err := llm.request(ctx, text, options)
+	if err != nil {
+		return ChatResult{}, err
+	}
 
 	var chat Chat
-	if err := llm.cln.Do(ctx, http.MethodPost, llm.url, d, &chat); err != nil {
-		return "", fmt.Errorf("do: %w", err)
+	if err := llm.do(ctx, d, &chat, isZero(d["temperature"])); err != nil {
+		return ChatResult{}, fmt.Errorf("do: %w", err)
 	}
 
 	if len(chat.Choices) == 0 {
-		return "", fmt.Errorf("no response")
+		return ChatResult{}, fmt.Errorf("no response")
+	}
+
+	choice := chat.Choices[0]
+
+	result := ChatResult{
+		Content:      choice.Message.Content,
+		Reasoning:    choice.Message.Reasoning,
+		FinishReason: choice.FinishReason,
+		Usage:        chat.Usage,
+	}
+
+	// The arguments of a call are complete here, they failed to parse when
+	// they're missing.
+	for _, call := range choice.Message.ToolCalls {
+		if call.Function.Arguments == nil {
+			var arguments map[string]any
+			err := json.Unmarshal([]byte(call.Function.RawArguments), &arguments)
+			return result, fmt.Errorf("tool call %s: arguments: %w", call.Function.Name, err)
+		}
+		result.ToolCalls = append(result.ToolCalls, call)
 	}
 
-	return chat.Choices[0].Message.Content, nil
+	return result, nil
 }
 
-func (llm *LLM) ChatCompletionsSSE(ctx context.Context, content string) (chan ChatSSE, error) {
-	d := D{
-		"model": llm.model,
-		"messages": []D{
-			{
-				"role":    "user",
-				"content": content,
-			},
-		},
-		"temperature": 1.0,
-		"top_p":       0.5,
-		"top_k":       20,
-		"stream":      true,
+// ChatCompletionsSSE streams the response. It accepts the same options as
+// ChatCompletions.
+func (llm *LLM) ChatCompletionsSSE(ctx context.Context, content string, options ...Option) (chan ChatSSE, error) {
+	d, err := llm.request(ctx, content, options)
+	if err != nil {
+		return nil, err
 	}
 
+	d["stream"] = true
+	d["stream_options"] = D{"include_usage": true}
+
 	ch := make(chan ChatSSE, 100)
 	if err := llm.clnSSE.Do(ctx, http.MethodPost, llm.url, d, ch); err != nil {
 		return nil, fmt.Errorf("do: %w", err)
@@ -148,7 +335,7 @@ func (llm *LLM) EmbedText(ctx context.Context, input string) ([]float64, error)
 	}
 
 	var resp Embedding
-	if err := llm.cln.Do(ctx, http.MethodPost, llm.url, d, &resp); err != nil {
+	if err := llm.do(ctx, d, &resp, true); err != nil {
 		return nil, fmt.Errorf("do: %w", err)
 	}
 
@@ -160,22 +347,17 @@ func (llm *LLM) EmbedText(ctx context.Context, input string) ([]float64, error)
 }
 
 func (llm *LLM) EmbedWithImage(ctx context.Context, description string, image []byte, mimeType string) ([]float64, error) {
-	dataBase64 := base64.StdEncoding.EncodeToString(image)
+	if err := llm.check(ctx, CapabilityVision); err != nil {
+		return nil, err
+	}
 
 	d := D{
 		"model": llm.model,
-		"input": []D{
-			{
-				"type": "image_url",
-				"image_url": D{
-					"url": fmt.Sprintf("data:%s;base64,%s", mimeType, dataBase64),
-				},
-			},
-		},
+		"input": []D{ImagePart(mimeType, image)},
 	}
 
 	var resp Embedding
-	if err := llm.cln.Do(ctx, http.MethodPost, llm.url, d, &resp); err != nil {
+	if err := llm.do(ctx, d, &resp, true); err != nil {
 		return nil, fmt.Errorf("do: %w", err)
 	}
 
@@ -184,4 +366,41 @@ func (llm *LLM) EmbedWithImage(ctx context.Context, description string, image []
 	}
 
 	return resp.Data[0].Embedding, nil
-}
\ No newline at end of file
+}
+
+// =============================================================================
+
+// do performs the request, going through the cache when the request is
+// cacheable and a cache is configured.
+func (llm *LLM) do(ctx context.Context, d D, v any, cacheable bool) error {
+	if llm.cache == nil || !cacheable {
+		return llm.cln.Do(ctx, http.MethodPost, llm.url, d, v)
+	}
+
+	key, err := CacheKey(llm.model, d)
+	if err != nil {
+		return llm.cln.Do(ctx, http.MethodPost, llm.url, d, v)
+	}
+
+	if data, exists := llm.cache.Get(key); exists {
+		if err := json.Unmarshal(data, v); err == nil {
+			llm.cln.cacheHit(ctx, http.MethodPost, llm.url, d)
+			return nil
+		}
+	}
+
+	if err := llm.cln.Do(ctx, http.MethodPost, llm.url, d, v); err != nil {
+		return err
+	}
+
+	if data, err := json.Marshal(v); err == nil {
+		llm.cache.Set(key, data)
+	}
+
+	return nil
+}
+
+func isZero(v any) bool {
+	f, ok := toFloat(v)
+	return ok && f == 0
+}
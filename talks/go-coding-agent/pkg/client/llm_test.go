@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// answering is a chat server sending the body as the response.
+func answering(t *testing.T, body string) *LLM {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewLLM(srv.URL, "m", WithClientOptions(WithSlog(nil)))
+}
+
+func TestChatCompletionsToolCalls(t *testing.T) {
+	llm := answering(t, `{
+		"choices": [{
+			"message": {
+				"role": "assistant",
+				"content": "",
+				"reasoning": "I need the weather.",
+				"tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{\"city\": \"Paris\"}"}},
+					{"id": "call_2", "type": "function", "function": {"name": "time", "arguments": {}}}
+				]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+	}`)
+
+	result, err := llm.ChatCompletions(context.Background(), "weather in Paris?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.FinishReason != "tool_calls" || result.Reasoning != "I need the weather." {
+		t.Errorf("got finish reason %q and reasoning %q", result.FinishReason, result.Reasoning)
+	}
+
+	if len(result.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(result.ToolCalls))
+	}
+
+	if call := result.ToolCalls[0]; call.ID != "call_1" || call.Function.Name != "weather" || call.Function.Arguments["city"] != "Paris" {
+		t.Errorf("got %+v", call)
+	}
+
+	if call := result.ToolCalls[1]; call.Function.Name != "time" || call.Function.Arguments == nil {
+		t.Errorf("got %+v, want empty arguments", call)
+	}
+
+	if result.Usage == nil || result.Usage.TotalTokens != 15 {
+		t.Errorf("got usage %+v", result.Usage)
+	}
+}
+
+func TestChatCompletionsBadArguments(t *testing.T) {
+	llm := answering(t, `{
+		"choices": [{
+			"message": {
+				"role": "assistant",
+				"content": "calling",
+				"tool_calls": [{"id": "call_1", "function": {"name": "weather", "arguments": "{\"city\": "}}]
+			},
+			"finish_reason": "tool_calls"
+		}]
+	}`)
+
+	result, err := llm.ChatCompletions(context.Background(), "weather in Paris?")
+	if err == nil {
+		t.Fatal("expected an error for arguments that aren't JSON")
+	}
+
+	if result.Content != "calling" || len(result.ToolCalls) != 0 {
+		t.Errorf("got %+v, want the content and no tool calls", result)
+	}
+}
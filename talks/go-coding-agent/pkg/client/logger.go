@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger is the original logging function the client accepted. It's still
+// supported through an slog.Handler adapter, see NewLoggerHandler.
+type Logger func(context.Context, string, ...any)
+
+var NoopLogger = func(ctx context.Context, msg string, v ...any) {}
+
+var StdoutLogger = func(ctx context.Context, msg string, v ...any) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "msg: %s", msg)
+
+	for i := 0; i < len(v); i = i + 2 {
+		if i+1 == len(v) {
+			fmt.Fprintf(&b, ", !BADKEY: %v", v[i])
+			break
+		}
+		fmt.Fprintf(&b, ", %s: %v", v[i], v[i+1])
+	}
+
+	log.Println(b.String())
+}
+
+// WithSlog replaces the client's logger. A nil logger discards everything.
+func WithSlog(log *slog.Logger) func(cln *Client) {
+	return func(cln *Client) {
+		if log == nil {
+			log = slog.New(slog.DiscardHandler)
+		}
+		cln.log = slog.New(requestIDHandler{log.Handler()})
+	}
+}
+
+// =============================================================================
+
+type loggerHandler struct {
+	log    Logger
+	level  slog.Leveler
+	attrs  []any
+	prefix string
+}
+
+// NewLoggerHandler adapts a Logger function into an slog.Handler so existing
+// Logger functions keep working. Records below the level are dropped.
+func NewLoggerHandler(log Logger, level slog.Leveler) slog.Handler {
+	return &loggerHandler{
+		log:   log,
+		level: level,
+	}
+}
+
+func (h *loggerHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *loggerHandler) Handle(ctx context.Context, r slog.Record) error {
+	args := make([]any, 0, len(h.attrs)+2*r.NumAttrs()+2)
+	if r.Level != slog.LevelInfo {
+		args = append(args, "level", r.Level.String())
+	}
+	args = append(args, h.attrs...)
+
+	r.Attrs(func(a slog.Attr) bool {
+		args = appendAttr(args, h.prefix, a)
+		return true
+	})
+
+	h.log(ctx, r.Message, args...)
+
+	return nil
+}
+
+func (h *loggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]any(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = appendAttr(h2.attrs, h.prefix, a)
+	}
+
+	return &h2
+}
+
+func (h *loggerHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+
+	return &h2
+}
+
+func appendAttr(args []any, prefix string, a slog.Attr) []any {
+	a.Value = a.Value.Resolve()
+
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			args = appendAttr(args, prefix+a.Key+".", ga)
+		}
+		return args
+	}
+
+	return append(args, prefix+a.Key, a.Value.Any())
+}
+
+// =============================================================================
+
+type ctxKey int
+
+const requestIDKey ctxKey = 1
+
+// ContextWithRequestID sets the request id the client sends in the
+// X-Request-ID header and adds to every log entry for the call.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id stored in the context, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ensureRequestID makes sure the context carries a request id, generating a
+// new one when the caller didn't provide it.
+func ensureRequestID(ctx context.Context) context.Context {
+	if RequestID(ctx) != "" {
+		return ctx
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return ContextWithRequestID(ctx, hex.EncodeToString(b))
+}
+
+// requestIDHandler adds the request id from the context to every record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
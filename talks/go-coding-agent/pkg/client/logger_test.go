@@ -0,0 +1,43 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+)
+
+func TestStdoutLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+
+	tests := []struct {
+		name string
+		args []any
+		want string
+	}{
+		{"empty", nil, "msg: call\n"},
+		{"even", []any{"status", 200, "model", "llama3"}, "msg: call, status: 200, model: llama3\n"},
+		{"odd", []any{"status", 200, "dangling"}, "msg: call, status: 200, !BADKEY: dangling\n"},
+		{"single", []any{"dangling"}, "msg: call, !BADKEY: dangling\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			StdoutLogger(context.Background(), "call", tt.args...)
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// RoundTripFunc performs a single HTTP round trip.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// Middleware hooks into the lifecycle of every request made by the client.
+// Any of the fields can be left nil. Middleware is applied in the order it's
+// registered, the first one being the outermost.
+type Middleware struct {
+	// BeforeRequest can inspect or modify the request before it's sent. The
+	// default headers are already set at this point and can be overridden.
+	BeforeRequest func(req *http.Request) error
+
+	// AfterResponse can inspect or modify the response before the client
+	// processes it. It's called for error status codes as well.
+	AfterResponse func(req *http.Request, resp *http.Response) error
+
+	// OnStreamChunk is called with the data of every SSE event before it's
+	// decoded. Returning a nil slice drops the chunk.
+	OnStreamChunk func(ctx context.Context, chunk []byte) ([]byte, error)
+
+	// Wrap decorates the round trip itself, which allows a middleware to
+	// short circuit the call, like a cache does.
+	Wrap func(next RoundTripFunc) RoundTripFunc
+}
+
+func (mw Middleware) wrap(next RoundTripFunc) RoundTripFunc {
+	if mw.Wrap != nil {
+		next = mw.Wrap(next)
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		if mw.BeforeRequest != nil {
+			if err := mw.BeforeRequest(req); err != nil {
+				return nil, fmt.Errorf("before request: %w", err)
+			}
+		}
+
+		resp, err := next(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if mw.AfterResponse != nil {
+			if err := mw.AfterResponse(req, resp); err != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("after response: %w", err)
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// WithMiddleware adds middleware to the client's request chain.
+func WithMiddleware(mw ...Middleware) func(cln *Client) {
+	return func(cln *Client) {
+		cln.middleware = append(cln.middleware, mw...)
+	}
+}
+
+// WithUserAgent replaces the default User-Agent header.
+func WithUserAgent(userAgent string) func(cln *Client) {
+	return func(cln *Client) {
+		cln.userAgent = userAgent
+	}
+}
+
+// roundTrip builds the middleware chain around the http client.
+func (cln *Client) roundTrip() RoundTripFunc {
+	rt := RoundTripFunc(cln.http.Do)
+	for i := len(cln.middleware) - 1; i >= 0; i-- {
+		rt = cln.middleware[i].wrap(rt)
+	}
+
+	return rt
+}
+
+// streamChunk runs the chunk through the OnStreamChunk hooks.
+func (cln *Client) streamChunk(ctx context.Context, chunk []byte) ([]byte, error) {
+	for _, mw := range cln.middleware {
+		if mw.OnStreamChunk == nil {
+			continue
+		}
+
+		var err error
+		if chunk, err = mw.OnStreamChunk(ctx, chunk); err != nil {
+			return nil, err
+		}
+
+		if chunk == nil {
+			return nil, nil
+		}
+	}
+
+	return chunk, nil
+}
+
+// =============================================================================
+
+// Header returns middleware that sets the header on every request.
+func Header(key string, value string) Middleware {
+	return Middleware{
+		BeforeRequest: func(req *http.Request) error {
+			req.Header.Set(key, value)
+			return nil
+		},
+	}
+}
+
+// LogBodies returns middleware that logs the full request and response
+// bodies at debug level. Streamed responses are logged one chunk at a time.
+// Bodies can contain prompts and base64 encoded images so keep this off in
+// production.
+func LogBodies(log *slog.Logger) Middleware {
+	return Middleware{
+		BeforeRequest: func(req *http.Request) error {
+			var body string
+			if req.GetBody != nil {
+				rc, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+
+				data, err := io.ReadAll(rc)
+				if err != nil {
+					return err
+				}
+
+				body = strings.TrimSpace(string(data))
+			}
+
+			log.DebugContext(req.Context(), "client: request", "method", req.Method, "endpoint", req.URL.String(), "body", body)
+
+			return nil
+		},
+		AfterResponse: func(req *http.Request, resp *http.Response) error {
+			if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+				log.DebugContext(req.Context(), "client: response", "status", resp.StatusCode, "body", "<stream>")
+				return nil
+			}
+
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+
+			resp.Body = io.NopCloser(bytes.NewReader(data))
+
+			log.DebugContext(req.Context(), "client: response", "status", resp.StatusCode, "body", string(data))
+
+			return nil
+		},
+		OnStreamChunk: func(ctx context.Context, chunk []byte) ([]byte, error) {
+			log.DebugContext(ctx, "client: chunk", "data", string(chunk))
+			return chunk, nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// traced is middleware writing every step it sees, with its name, to steps.
+func traced(name string, mu *sync.Mutex, steps *[]string) Middleware {
+	add := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*steps = append(*steps, name+" "+step)
+	}
+
+	return Middleware{
+		BeforeRequest: func(req *http.Request) error {
+			add("before")
+			return nil
+		},
+		AfterResponse: func(req *http.Request, resp *http.Response) error {
+			add("after")
+			return nil
+		},
+		OnStreamChunk: func(ctx context.Context, chunk []byte) ([]byte, error) {
+			add("chunk")
+			return chunk, nil
+		},
+		Wrap: func(next RoundTripFunc) RoundTripFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				add("wrap")
+				return next(req)
+			}
+		},
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var steps []string
+
+	cln := NewSSE[D](NoopLogger, WithSlog(nil), WithMiddleware(traced("a", &mu, &steps), traced("b", &mu, &steps)))
+
+	ch := make(chan D)
+	if err := cln.Do(context.Background(), http.MethodPost, srv.URL, D{}, ch); err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	// The first middleware is the outermost and sees the chunks first.
+	want := []string{"a before", "a wrap", "b before", "b wrap", "b after", "a after", "a chunk", "b chunk"}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !slices.Equal(steps, want) {
+		t.Errorf("got %v, want %v", steps, want)
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Request-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"given", "req-42"},
+		{"generated", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&buf, nil))
+
+			var before, chunk string
+			mw := Middleware{
+				BeforeRequest: func(req *http.Request) error {
+					before = RequestID(req.Context())
+					return nil
+				},
+				OnStreamChunk: func(ctx context.Context, data []byte) ([]byte, error) {
+					chunk = RequestID(ctx)
+					return data, nil
+				},
+			}
+
+			cln := NewSSE[D](NoopLogger, WithSlog(log), WithMiddleware(mw))
+
+			ctx := context.Background()
+			if tt.id != "" {
+				ctx = ContextWithRequestID(ctx, tt.id)
+			}
+
+			ch := make(chan D)
+			if err := cln.Do(ctx, http.MethodPost, srv.URL, D{}, ch); err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+
+			if header == "" || (tt.id != "" && header != tt.id) {
+				t.Fatalf("got header %q, want %q or a generated id", header, tt.id)
+			}
+
+			if before != header || chunk != header {
+				t.Errorf("got id %q before the request and %q for the chunks, want %q", before, chunk, header)
+			}
+
+			if !strings.Contains(buf.String(), "request_id="+header) {
+				t.Errorf("got log:\n%s\nwant the request id %s", buf.String(), header)
+			}
+		})
+	}
+}
@@ -53,29 +53,60 @@ func (t Time) MarshalJSON() ([]byte, error) {
 
 // =============================================================================
 
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// =============================================================================
+
+// Function is the function a tool call asks for. RawArguments is the JSON
+// text of the arguments as received and Arguments the arguments once they
+// form a complete object. A streamed call carries its arguments in pieces
+// which Collect joins before parsing them.
 type Function struct {
-	Name      string
-	Arguments map[string]any
+	Name         string
+	Arguments    map[string]any
+	RawArguments string
 }
 
 func (f *Function) UnmarshalJSON(b []byte) error {
 	var tmp struct {
-		Name         string `json:"name"`
-		RawArguments string `json:"arguments"`
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
 	}
 
 	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
 	}
 
-	arguments := make(map[string]any)
-	if err := json.Unmarshal([]byte(tmp.RawArguments), &arguments); err != nil {
-		return err
+	// The arguments are a string holding JSON in the OpenAI API, some
+	// servers send the object itself.
+	raw := string(tmp.Arguments)
+	var s string
+	if err := json.Unmarshal(tmp.Arguments, &s); err == nil {
+		raw = s
+	}
+	if raw == "null" {
+		raw = ""
 	}
 
 	*f = Function{
-		Name:      tmp.Name,
-		Arguments: arguments,
+		Name:         tmp.Name,
+		RawArguments: raw,
+	}
+
+	// A piece of the arguments isn't valid JSON on its own, it's left for
+	// Collect to parse once the call is complete.
+	switch {
+	case raw == "":
+		f.Arguments = make(map[string]any)
+	default:
+		var arguments map[string]any
+		if err := json.Unmarshal([]byte(raw), &arguments); err == nil {
+			f.Arguments = arguments
+		}
 	}
 
 	return nil
@@ -107,19 +138,23 @@ type ChatSSE struct {
 	Created Time            `json:"created"`
 	Model   string          `json:"model"`
 	Choices []ChatChoiceSSE `json:"choices"`
+	Usage   *Usage          `json:"usage,omitempty"`
 	Error   string          `json:"error"`
 }
 
 // =============================================================================
 
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Reasoning string     `json:"reasoning"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 }
 
 type ChatChoice struct {
-	Index   int         `json:"index"`
-	Message ChatMessage `json:"message"`
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
 }
 
 type Chat struct {
@@ -128,6 +163,17 @@ type Chat struct {
 	Created Time         `json:"created"`
 	Model   string       `json:"model"`
 	Choices []ChatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
+}
+
+// ChatResult is the complete response to a chat request, as returned by
+// ChatCompletions or assembled from a stream by Collect.
+type ChatResult struct {
+	Content      string
+	Reasoning    string
+	ToolCalls    []ToolCall
+	FinishReason string
+	Usage        *Usage
 }
 
 // =============================================================================
@@ -144,4 +190,5 @@ type Embedding struct {
 	Created Time            `json:"created"`
 	Model   string          `json:"model"`
 	Data    []EmbeddingData `json:"data"`
-}
\ No newline at end of file
+	Usage   *Usage          `json:"usage,omitempty"`
+}
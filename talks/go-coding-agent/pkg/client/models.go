@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrModelNotFound = errors.New("model not found")
+	ErrNotSupported  = errors.New("capability not supported by model")
+	ErrCapsUnknown   = errors.New("model capabilities unknown")
+)
+
+// Capability represents a feature a model may support. The values match the
+// capability names reported by Ollama's /api/show endpoint.
+type Capability string
+
+const (
+	CapabilityCompletion Capability = "completion"
+	CapabilityTools      Capability = "tools"
+	CapabilityVision     Capability = "vision"
+	CapabilityEmbedding  Capability = "embedding"
+	CapabilityThinking   Capability = "thinking"
+)
+
+// ModelInfo describes a model served by the configured endpoint. Detailed is
+// false when the context length and capabilities are unknown, either because
+// the server only implements the OpenAI /v1/models endpoint or because the
+// model comes from a list, which doesn't ask for them.
+type ModelInfo struct {
+	ID            string       `json:"id"`
+	Family        string       `json:"family,omitempty"`
+	ParameterSize string       `json:"parameter_size,omitempty"`
+	ContextLength int          `json:"context_length,omitempty"`
+	Capabilities  []Capability `json:"capabilities,omitempty"`
+	Detailed      bool         `json:"detailed"`
+}
+
+// Supports reports whether the model is known to support the capability.
+func (mi ModelInfo) Supports(c Capability) bool {
+	return slices.Contains(mi.Capabilities, c)
+}
+
+// =============================================================================
+
+type openAIModels struct {
+	Data []struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created Time   `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	} `json:"data"`
+}
+
+type ollamaTags struct {
+	Models []struct {
+		Name    string `json:"name"`
+		Model   string `json:"model"`
+		Details struct {
+			Family        string `json:"family"`
+			ParameterSize string `json:"parameter_size"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+type ollamaShow struct {
+	Details struct {
+		Family        string `json:"family"`
+		ParameterSize string `json:"parameter_size"`
+	} `json:"details"`
+	ModelInfo    map[string]any `json:"model_info"`
+	Capabilities []Capability   `json:"capabilities"`
+}
+
+// BaseURL strips the API path from an endpoint url so the other API routes
+// of the same server can be reached. For example:
+// http://localhost:11434/v1/chat/completions -> http://localhost:11434
+func BaseURL(url string) string {
+	for _, prefix := range []string{"/v1/", "/api/"} {
+		if i := strings.Index(url, prefix); i != -1 {
+			return url[:i]
+		}
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(url, "/v1"), "/")
+}
+
+// ListModels returns the models available on the server, in one request.
+// It tries the Ollama API first since it reports the family and size of the
+// models and falls back to the OpenAI /v1/models endpoint. The details take
+// a request per model, ShowModel fetches them for the models that need them.
+func (cln *Client) ListModels(ctx context.Context, url string) ([]ModelInfo, error) {
+	base := BaseURL(url)
+
+	var tags ollamaTags
+	if err := cln.Do(ctx, http.MethodGet, base+"/api/tags", nil, &tags); err == nil {
+		models := make([]ModelInfo, 0, len(tags.Models))
+		for _, m := range tags.Models {
+			models = append(models, ModelInfo{
+				ID:            m.Name,
+				Family:        m.Details.Family,
+				ParameterSize: m.Details.ParameterSize,
+			})
+		}
+
+		return models, nil
+	}
+
+	var list openAIModels
+	if err := cln.Do(ctx, http.MethodGet, base+"/v1/models", nil, &list); err != nil {
+		return nil, fmt.Errorf("list models: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, ModelInfo{ID: m.ID})
+	}
+
+	return models, nil
+}
+
+// ShowModel returns the details for the specified model using the Ollama
+// /api/show endpoint.
+func (cln *Client) ShowModel(ctx context.Context, url string, model string) (ModelInfo, error) {
+	var show ollamaShow
+	if err := cln.Do(ctx, http.MethodPost, BaseURL(url)+"/api/show", D{"model": model}, &show); err != nil {
+		return ModelInfo{}, fmt.Errorf("show model: %w", err)
+	}
+
+	mi := ModelInfo{
+		ID:            model,
+		Family:        show.Details.Family,
+		ParameterSize: show.Details.ParameterSize,
+		Capabilities:  show.Capabilities,
+		Detailed:      true,
+	}
+
+	// The context length key is prefixed by the model architecture, for
+	// example "gptoss.context_length".
+	for k, v := range show.ModelInfo {
+		if !strings.HasSuffix(k, ".context_length") {
+			continue
+		}
+
+		if n, ok := v.(float64); ok {
+			mi.ContextLength = int(n)
+		}
+	}
+
+	return mi, nil
+}
+
+// =============================================================================
+
+// Models returns the models available on the server the LLM is bound to.
+func (llm *LLM) Models(ctx context.Context) ([]ModelInfo, error) {
+	return llm.cln.ListModels(ctx, llm.url)
+}
+
+// ModelInfo returns the information for the model the LLM is bound to. The
+// result is cached after the first call, a failure included, so a server
+// that can't describe the model isn't asked again on every request.
+func (llm *LLM) ModelInfo(ctx context.Context) (ModelInfo, error) {
+	llm.mu.Lock()
+	defer llm.mu.Unlock()
+
+	if llm.infoErr != nil {
+		return ModelInfo{}, llm.infoErr
+	}
+
+	if llm.info != nil {
+		return *llm.info, nil
+	}
+
+	mi, err := llm.modelInfo(ctx)
+	if err != nil {
+		// A canceled call says nothing about the model.
+		if ctx.Err() == nil {
+			llm.infoErr = err
+		}
+		return ModelInfo{}, err
+	}
+
+	llm.info = &mi
+
+	return mi, nil
+}
+
+func (llm *LLM) modelInfo(ctx context.Context) (ModelInfo, error) {
+	mi, err := llm.cln.ShowModel(ctx, llm.url, llm.model)
+	if err == nil {
+		return mi, nil
+	}
+
+	models, lerr := llm.cln.ListModels(ctx, llm.url)
+	if lerr != nil {
+		return ModelInfo{}, fmt.Errorf("model info: %w", errors.Join(err, lerr))
+	}
+
+	idx := slices.IndexFunc(models, func(m ModelInfo) bool { return sameModel(m.ID, llm.model) })
+	if idx == -1 {
+		return ModelInfo{}, fmt.Errorf("%w: %s", ErrModelNotFound, llm.model)
+	}
+
+	return models[idx], nil
+}
+
+// sameModel reports whether the names are of the same model, a name without
+// a tag standing for the latest as in Ollama: llama3 is llama3:latest.
+func sameModel(a string, b string) bool {
+	return fullName(a) == fullName(b)
+}
+
+// fullName adds the latest tag to a model name without one. The colon of a
+// registry port, as in localhost:5000/llama3, isn't a tag.
+func fullName(model string) string {
+	i := strings.LastIndex(model, ":")
+	if i == -1 || strings.Contains(model[i:], "/") {
+		return model + ":latest"
+	}
+
+	return model
+}
+
+// Require validates the model exists and supports the specified capabilities.
+// Use it right after NewLLM to fail fast on a misconfigured model. When the
+// server can't report capabilities a warning is logged, once, and nil is
+// returned.
+func (llm *LLM) Require(ctx context.Context, caps ...Capability) error {
+	mi, err := llm.ModelInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !mi.Detailed {
+		llm.warnOnce(ctx, "llm: require", ErrCapsUnknown)
+		return nil
+	}
+
+	for _, c := range caps {
+		if !mi.Supports(c) {
+			return fmt.Errorf("%w: model[%s] capability[%s]", ErrNotSupported, llm.model, c)
+		}
+	}
+
+	return nil
+}
+
+// check is called before a request that needs the specified capability. In
+// strict mode an unsupported capability fails the call, otherwise a warning
+// is logged, once per capability, and the request goes out anyway.
+func (llm *LLM) check(ctx context.Context, c Capability) error {
+	err := llm.Require(ctx, c)
+	if err == nil {
+		return nil
+	}
+
+	if llm.strict && (errors.Is(err, ErrNotSupported) || errors.Is(err, ErrModelNotFound)) {
+		return err
+	}
+
+	llm.warnOnce(ctx, "llm: check", err, "capability", c)
+
+	return nil
+}
+
+// warnOnce logs the warning the first time it's seen, since the model info
+// it's about is cached and the same warning would come with every request.
+func (llm *LLM) warnOnce(ctx context.Context, msg string, err error, args ...any) {
+	key := fmt.Sprint(msg, args, err)
+
+	llm.mu.Lock()
+	seen := llm.warned[key]
+	if !seen {
+		if llm.warned == nil {
+			llm.warned = make(map[string]bool)
+		}
+		llm.warned[key] = true
+	}
+	llm.mu.Unlock()
+
+	if seen {
+		return
+	}
+
+	llm.cln.log.WarnContext(ctx, msg, append([]any{"model", llm.model, "err", err}, args...)...)
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// ollama is an Ollama server listing llama3:latest, which it only shows when
+// show is true. It counts the show requests.
+type ollama struct {
+	*httptest.Server
+	shows atomic.Int64
+}
+
+func newOllama(t *testing.T, show bool) *ollama {
+	t.Helper()
+
+	var o ollama
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models": [
+				{"name": "llama3:latest", "details": {"family": "llama", "parameter_size": "8B"}},
+				{"name": "nomic-embed-text:latest", "details": {"family": "nomic-bert"}}
+			]}`)
+
+		case "/api/show":
+			o.shows.Add(1)
+			if !show {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"details": {"family": "llama"}, "model_info": {"llama.context_length": 8192}, "capabilities": ["completion", "tools"]}`)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(o.Close)
+
+	return &o
+}
+
+func TestListModelsOneRequest(t *testing.T) {
+	o := newOllama(t, true)
+
+	models, err := New(NoopLogger, WithSlog(nil)).ListModels(context.Background(), o.URL+"/v1/chat/completions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models) != 2 || models[0].ID != "llama3:latest" || models[0].ParameterSize != "8B" || models[0].Detailed {
+		t.Errorf("got %+v", models)
+	}
+
+	if n := o.shows.Load(); n != 0 {
+		t.Errorf("got %d show requests, want the details left for later", n)
+	}
+}
+
+func TestModelInfo(t *testing.T) {
+	o := newOllama(t, true)
+
+	llm := NewLLM(o.URL+"/v1/chat/completions", "llama3", WithClientOptions(WithSlog(nil)))
+
+	for range 2 {
+		mi, err := llm.ModelInfo(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !mi.Detailed || mi.ContextLength != 8192 || !mi.Supports(CapabilityTools) {
+			t.Errorf("got %+v", mi)
+		}
+	}
+
+	if n := o.shows.Load(); n != 1 {
+		t.Errorf("got %d show requests, want 1 then the cached info", n)
+	}
+
+	if err := llm.Require(context.Background(), CapabilityVision); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+}
+
+func TestModelInfoLatestTag(t *testing.T) {
+	o := newOllama(t, false)
+
+	// Without /api/show the model is found in the list under its full name.
+	llm := NewLLM(o.URL+"/v1/chat/completions", "llama3", WithClientOptions(WithSlog(nil)))
+
+	mi, err := llm.ModelInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mi.ID != "llama3:latest" || mi.Detailed {
+		t.Errorf("got %+v", mi)
+	}
+
+	missing := NewLLM(o.URL+"/v1/chat/completions", "llama3:70b", WithClientOptions(WithSlog(nil)))
+	if _, err := missing.ModelInfo(context.Background()); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("got %v, want ErrModelNotFound for another tag", err)
+	}
+}
+
+func TestModelInfoWarnsOnce(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"unknown capabilities", newOllama(t, false).URL},
+		{"no model info", srv.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&buf, nil))
+
+			llm := NewLLM(tt.url+"/v1/chat/completions", "llama3", WithClientOptions(WithSlog(log)))
+
+			var first int64
+			for i := range 3 {
+				if err := llm.check(context.Background(), CapabilityTools); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					first = requests.Load()
+				}
+			}
+
+			if n := strings.Count(buf.String(), "level=WARN"); n != 1 {
+				t.Errorf("got %d warnings, want 1:\n%s", n, buf.String())
+			}
+
+			if n := requests.Load(); n != first {
+				t.Errorf("got %d requests, want %d then the cached result", n, first)
+			}
+		})
+	}
+}
+
+func TestSameModel(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"llama3", "llama3:latest", true},
+		{"llama3:latest", "llama3:latest", true},
+		{"llama3", "llama3:8b", false},
+		{"gpt-oss:20b", "gpt-oss:20b", true},
+		{"localhost:5000/llama3", "localhost:5000/llama3:latest", true},
+		{"localhost:5000/llama3", "llama3", false},
+	}
+
+	for _, tt := range tests {
+		if got := sameModel(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameModel(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// The helpers in this file consume the channel returned by ChatCompletionsSSE.
+// Breaking out of an iterator early leaves the rest of the stream unread, so
+// cancel the context used for the request when doing that.
+
+// Deltas yields the delta of the first choice of every chunk, skipping chunks
+// without choices like the final usage chunk. An error reported by the server
+// is yielded once and ends the iteration.
+func Deltas(ch <-chan ChatSSE) iter.Seq2[ChatDeltaSSE, error] {
+	return func(yield func(ChatDeltaSSE, error) bool) {
+		for resp := range ch {
+			if resp.Error != "" {
+				yield(ChatDeltaSSE{}, errors.New(resp.Error))
+				return
+			}
+
+			if len(resp.Choices) == 0 {
+				continue
+			}
+
+			if !yield(resp.Choices[0].Delta, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ContentTokens yields the content tokens of the stream, dropping everything
+// else.
+func ContentTokens(ch <-chan ChatSSE) iter.Seq2[string, error] {
+	return tokens(ch, func(d ChatDeltaSSE) string { return d.Content })
+}
+
+// ReasoningTokens yields the reasoning tokens of the stream, dropping
+// everything else.
+func ReasoningTokens(ch <-chan ChatSSE) iter.Seq2[string, error] {
+	return tokens(ch, func(d ChatDeltaSSE) string { return d.Reasoning })
+}
+
+func tokens(ch <-chan ChatSSE, field func(d ChatDeltaSSE) string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for delta, err := range Deltas(ch) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			if s := field(delta); s != "" {
+				if !yield(s, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// =============================================================================
+
+type streamReader struct {
+	next func() (string, error, bool)
+	stop func()
+	buf  string
+	err  error
+}
+
+// NewStreamReader returns a reader over the content tokens of the stream, so
+// model output can be piped with io.Copy to a file or terminal.
+func NewStreamReader(ch <-chan ChatSSE) io.ReadCloser {
+	next, stop := iter.Pull2(ContentTokens(ch))
+
+	return &streamReader{
+		next: next,
+		stop: stop,
+	}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for r.buf == "" {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		s, err, ok := r.next()
+		switch {
+		case !ok:
+			r.err = io.EOF
+		case err != nil:
+			r.err = err
+		default:
+			r.buf = s
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// Close stops reading the stream. Cancel the request's context as well to
+// release the connection.
+func (r *streamReader) Close() error {
+	r.stop()
+	return nil
+}
+
+// =============================================================================
+
+// Collect drains the stream and returns the final text, reasoning and tool
+// calls. Tool calls split across chunks are merged by their index, the
+// pieces of their arguments joined and parsed once the stream ends. Chunks
+// are concatenated exactly as received.
+func Collect(ch <-chan ChatSSE) (ChatResult, error) {
+	return CollectWith(ch, nil)
+}
+
+// CollectWith is Collect calling fn, when not nil, with every delta as it
+// arrives, so the answer can be shown while it's assembled. On error the
+// result holds the text received until then and no tool calls.
+func CollectWith(ch <-chan ChatSSE, fn func(delta ChatDeltaSSE)) (ChatResult, error) {
+	var content strings.Builder
+	var reasoning strings.Builder
+	var result ChatResult
+
+	// The calls with the joined pieces of their arguments. A new id under the
+	// same index starts another call, as some servers send every complete
+	// call with index 0.
+	var calls []*ToolCall
+	var args []*strings.Builder
+	byIndex := make(map[int]int)
+
+	done := func() {
+		result.Content = content.String()
+		result.Reasoning = reasoning.String()
+	}
+
+	for resp := range ch {
+		if resp.Error != "" {
+			done()
+			return result, errors.New(resp.Error)
+		}
+
+		if resp.Usage != nil {
+			result.Usage = resp.Usage
+		}
+
+		if len(resp.Choices) == 0 {
+			continue
+		}
+
+		choice := resp.Choices[0]
+		if choice.FinishReason != "" {
+			result.FinishReason = choice.FinishReason
+		}
+
+		content.WriteString(choice.Delta.Content)
+		reasoning.WriteString(choice.Delta.Reasoning)
+
+		if fn != nil {
+			fn(choice.Delta)
+		}
+
+		for _, tc := range choice.Delta.ToolCalls {
+			i, exists := byIndex[tc.Index]
+			if exists && tc.ID != "" && calls[i].ID != "" && tc.ID != calls[i].ID {
+				exists = false
+			}
+
+			if !exists {
+				byIndex[tc.Index] = len(calls)
+				calls = append(calls, &tc)
+				args = append(args, &strings.Builder{})
+				args[len(args)-1].WriteString(tc.Function.RawArguments)
+				continue
+			}
+
+			call := calls[i]
+			if call.ID == "" {
+				call.ID = tc.ID
+			}
+			if call.Type == "" {
+				call.Type = tc.Type
+			}
+			if call.Function.Name == "" {
+				call.Function.Name = tc.Function.Name
+			}
+			args[i].WriteString(tc.Function.RawArguments)
+		}
+	}
+
+	done()
+
+	for i, call := range calls {
+		raw := args[i].String()
+
+		call.Function.RawArguments = raw
+		call.Function.Arguments = make(map[string]any)
+		if strings.TrimSpace(raw) != "" {
+			if err := json.Unmarshal([]byte(raw), &call.Function.Arguments); err != nil {
+				return result, fmt.Errorf("tool call %s: arguments: %w", call.Function.Name, err)
+			}
+		}
+
+		result.ToolCalls = append(result.ToolCalls, *call)
+	}
+
+	slices.SortStableFunc(result.ToolCalls, func(a, b ToolCall) int { return a.Index - b.Index })
+
+	return result, nil
+}
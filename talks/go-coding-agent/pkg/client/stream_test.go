@@ -0,0 +1,167 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// events decodes the data lines of a stream into a closed channel, as the
+// SSE client hands them out.
+func events(t *testing.T, lines ...string) <-chan ChatSSE {
+	t.Helper()
+
+	ch := make(chan ChatSSE, len(lines))
+	for _, line := range lines {
+		var resp ChatSSE
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("decode %s: %s", line, err)
+		}
+		ch <- resp
+	}
+	close(ch)
+
+	return ch
+}
+
+func TestCollectContent(t *testing.T) {
+	ch := events(t,
+		`{"choices":[{"delta":{"reasoning":"let me "}}]}`,
+		`{"choices":[{"delta":{"reasoning":"think","content":"Hel"}}]}`,
+		`{"choices":[{"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+	)
+
+	var deltas int
+	result, err := CollectWith(ch, func(delta ChatDeltaSSE) { deltas++ })
+	if err != nil {
+		t.Fatalf("collect: %s", err)
+	}
+
+	if result.Content != "Hello" || result.Reasoning != "let me think" || result.FinishReason != "stop" {
+		t.Errorf("got %+v", result)
+	}
+
+	if deltas != 3 {
+		t.Errorf("got %d deltas, want 3", deltas)
+	}
+}
+
+func TestCollectFragmentedArguments(t *testing.T) {
+	ch := events(t,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"pa"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"th\":\"x.txt\"}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+	)
+
+	result, err := Collect(ch)
+	if err != nil {
+		t.Fatalf("collect: %s", err)
+	}
+
+	if len(result.ToolCalls) != 1 {
+		t.Fatalf("got %d tool calls, want 1", len(result.ToolCalls))
+	}
+
+	call := result.ToolCalls[0]
+	if call.ID != "call_1" || call.Type != "function" || call.Function.Name != "read_file" {
+		t.Errorf("got call %+v", call)
+	}
+
+	if got := call.Function.Arguments["path"]; got != "x.txt" {
+		t.Errorf("got path %v, want x.txt", got)
+	}
+
+	if call.Function.RawArguments != `{"path":"x.txt"}` {
+		t.Errorf("got raw arguments %q", call.Function.RawArguments)
+	}
+}
+
+func TestCollectInterleavedCalls(t *testing.T) {
+	ch := events(t,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","function":{"name":"list_files","arguments":"{\"dir\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","function":{"name":"read_file","arguments":"{\"path\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\".\"}"}},{"index":0,"function":{"arguments":"\"a.go\"}"}}]}}]}`,
+	)
+
+	result, err := Collect(ch)
+	if err != nil {
+		t.Fatalf("collect: %s", err)
+	}
+
+	if len(result.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(result.ToolCalls))
+	}
+
+	// The calls are in the order of their index, not of their first chunk.
+	a, b := result.ToolCalls[0], result.ToolCalls[1]
+	if b.ID != "call_b" || b.Function.Arguments["dir"] != "." {
+		t.Errorf("got call %+v", b)
+	}
+	if a.ID != "call_a" || a.Function.Arguments["path"] != "a.go" {
+		t.Errorf("got call %+v", a)
+	}
+}
+
+func TestCollectCompleteCallsSameIndex(t *testing.T) {
+	// Some servers send every call whole, with the arguments as an object
+	// and all under index 0.
+	ch := events(t,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"read_file","arguments":{"path":"a.go"}}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_2","function":{"name":"read_file","arguments":{"path":"b.go"}}}]}}]}`,
+	)
+
+	result, err := Collect(ch)
+	if err != nil {
+		t.Fatalf("collect: %s", err)
+	}
+
+	if len(result.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(result.ToolCalls))
+	}
+
+	for i, want := range []string{"a.go", "b.go"} {
+		if got := result.ToolCalls[i].Function.Arguments["path"]; got != want {
+			t.Errorf("call %d: got path %v, want %s", i, got, want)
+		}
+	}
+}
+
+func TestCollectNoArguments(t *testing.T) {
+	ch := events(t, `{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"git_status"}}]}}]}`)
+
+	result, err := Collect(ch)
+	if err != nil {
+		t.Fatalf("collect: %s", err)
+	}
+
+	if args := result.ToolCalls[0].Function.Arguments; args == nil || len(args) != 0 {
+		t.Errorf("got arguments %v, want an empty map", args)
+	}
+}
+
+func TestCollectTruncatedArguments(t *testing.T) {
+	ch := events(t,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"read_file","arguments":"{\"pa"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"length"}]}`,
+	)
+
+	if _, err := Collect(ch); err == nil {
+		t.Error("expected an error for arguments cut short")
+	}
+}
+
+func TestCollectError(t *testing.T) {
+	ch := events(t,
+		`{"choices":[{"delta":{"content":"partial"}}]}`,
+		`{"error":"model crashed"}`,
+	)
+
+	result, err := Collect(ch)
+	if err == nil || err.Error() != "model crashed" {
+		t.Fatalf("got error %v, want the server's", err)
+	}
+
+	if result.Content != "partial" {
+		t.Errorf("got content %q, want what came before the error", result.Content)
+	}
+}
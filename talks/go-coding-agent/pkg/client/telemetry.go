@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "go-coding-agent/pkg/client"
+
+// telemetry holds the tracer and instruments used by a client. Attribute
+// names follow the OpenTelemetry GenAI semantic conventions.
+type telemetry struct {
+	tracer    trace.Tracer
+	requests  metric.Int64Counter
+	errors    metric.Int64Counter
+	duration  metric.Float64Histogram
+	tokens    metric.Int64Histogram
+	ttft      metric.Float64Histogram
+	stream    metric.Float64Histogram
+	tokensSec metric.Float64Histogram
+	queueWait metric.Float64Histogram
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	meter := mp.Meter(instrumentationName)
+
+	// Errors creating instruments only happen with invalid names, in which
+	// case the meter hands back a noop instrument we can still use.
+	requests, _ := meter.Int64Counter("gen_ai.client.requests",
+		metric.WithDescription("Number of requests sent to the model server."),
+		metric.WithUnit("{request}"))
+	errs, _ := meter.Int64Counter("gen_ai.client.errors",
+		metric.WithDescription("Number of requests that failed."),
+		metric.WithUnit("{request}"))
+	duration, _ := meter.Float64Histogram("gen_ai.client.operation.duration",
+		metric.WithDescription("GenAI operation duration."),
+		metric.WithUnit("s"))
+	tokens, _ := meter.Int64Histogram("gen_ai.client.token.usage",
+		metric.WithDescription("Measures number of input and output tokens used."),
+		metric.WithUnit("{token}"))
+	ttft, _ := meter.Float64Histogram("gen_ai.client.time_to_first_token",
+		metric.WithDescription("Time from sending a streaming request to receiving the first chunk."),
+		metric.WithUnit("s"))
+	stream, _ := meter.Float64Histogram("gen_ai.client.stream.duration",
+		metric.WithDescription("Time from sending a streaming request to the end of the stream."),
+		metric.WithUnit("s"))
+	tokensSec, _ := meter.Float64Histogram("gen_ai.client.output_tokens_per_second",
+		metric.WithDescription("Output tokens generated per second of streaming."),
+		metric.WithUnit("{token}/s"))
+	queueWait, _ := meter.Float64Histogram("gen_ai.client.queue.wait",
+		metric.WithDescription("Time a request waited in the limiter queue."),
+		metric.WithUnit("s"))
+
+	return &telemetry{
+		tracer:    tp.Tracer(instrumentationName),
+		requests:  requests,
+		errors:    errs,
+		duration:  duration,
+		tokens:    tokens,
+		ttft:      ttft,
+		stream:    stream,
+		tokensSec: tokensSec,
+		queueWait: queueWait,
+	}
+}
+
+// queued records the time a request waited for a slot in the limiter.
+func (tel *telemetry) queued(ctx context.Context, model string, p Priority, wait time.Duration) {
+	tel.queueWait.Record(ctx, wait.Seconds(), metric.WithAttributes(
+		attribute.String("gen_ai.request.model", model),
+		attribute.String("priority", p.String())))
+}
+
+// WithTelemetry sets the providers used for tracing and metrics. By default
+// the global providers are used, which do nothing until an SDK is installed.
+func WithTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) func(cln *Client) {
+	return func(cln *Client) {
+		cln.tel = newTelemetry(tp, mp)
+	}
+}
+
+func defaultTelemetry() *telemetry {
+	return newTelemetry(otel.GetTracerProvider(), otel.GetMeterProvider())
+}
+
+// =============================================================================
+
+// call tracks the span and measurements of a single request.
+type call struct {
+	tel     *telemetry
+	span    trace.Span
+	start   time.Time
+	attrs   []attribute.KeyValue
+	first   time.Time
+	stream  bool
+	reasons []string
+}
+
+// startCall starts the span for a request. The span name is the operation
+// followed by the model, like "chat gpt-oss:20b".
+func (tel *telemetry) startCall(ctx context.Context, method string, endpoint string, body D, stream bool) (context.Context, *call) {
+	op := operation(method, endpoint)
+	model, _ := body["model"].(string)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("gen_ai.operation.name", op),
+		attribute.String("gen_ai.system", "openai"),
+	}
+	if model != "" {
+		attrs = append(attrs, attribute.String("gen_ai.request.model", model))
+	}
+	if u, err := url.Parse(endpoint); err == nil {
+		attrs = append(attrs, attribute.String("server.address", u.Hostname()))
+	}
+
+	spanAttrs := append([]attribute.KeyValue{}, attrs...)
+	for key, attr := range map[string]string{
+		"temperature": "gen_ai.request.temperature",
+		"top_p":       "gen_ai.request.top_p",
+		"top_k":       "gen_ai.request.top_k",
+		"max_tokens":  "gen_ai.request.max_tokens",
+		"seed":        "gen_ai.request.seed",
+	} {
+		if v, ok := toFloat(body[key]); ok {
+			spanAttrs = append(spanAttrs, attribute.Float64(attr, v))
+		}
+	}
+
+	name := op
+	if model != "" {
+		name = fmt.Sprintf("%s %s", op, model)
+	}
+
+	ctx, span := tel.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(spanAttrs...))
+
+	tel.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+
+	c := call{
+		tel:    tel,
+		span:   span,
+		start:  time.Now(),
+		attrs:  attrs,
+		stream: stream,
+	}
+
+	return ctx, &c
+}
+
+// chunk records the arrival of a streamed chunk. The first one sets the time
+// to first token.
+func (c *call) chunk(ctx context.Context) {
+	if !c.first.IsZero() {
+		return
+	}
+
+	c.first = time.Now()
+
+	ttft := c.first.Sub(c.start).Seconds()
+	c.tel.ttft.Record(ctx, ttft, metric.WithAttributes(c.attrs...))
+	c.span.SetAttributes(attribute.Float64("gen_ai.response.time_to_first_token", ttft))
+}
+
+// cached marks the call as served from the cache.
+func (c *call) cached() {
+	c.span.SetAttributes(attribute.Bool("gen_ai.response.cached", true))
+}
+
+// finish records a finish reason reported by the model.
+func (c *call) finish(reason string) {
+	if reason != "" {
+		c.reasons = append(c.reasons, reason)
+	}
+}
+
+// end finishes the span and records the call's measurements.
+func (c *call) end(ctx context.Context, status int, usage *Usage, err error) {
+	defer c.span.End()
+
+	elapsed := time.Since(c.start).Seconds()
+	attrs := c.attrs
+
+	// A failed call has no response to take the status from.
+	var se *StatusError
+	if status == 0 && errors.As(err, &se) {
+		status = se.StatusCode
+	}
+
+	if status != 0 {
+		c.span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+
+	if len(c.reasons) > 0 {
+		c.span.SetAttributes(attribute.StringSlice("gen_ai.response.finish_reasons", c.reasons))
+	}
+
+	if err != nil {
+		errType := fmt.Sprintf("%T", err)
+		if errors.As(err, &se) {
+			errType = fmt.Sprint(se.StatusCode)
+		}
+
+		attrs = append(attrs, attribute.String("error.type", errType))
+
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, err.Error())
+		c.tel.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+
+	c.tel.duration.Record(ctx, elapsed, metric.WithAttributes(attrs...))
+
+	if usage != nil {
+		c.span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", usage.PromptTokens),
+			attribute.Int("gen_ai.usage.output_tokens", usage.CompletionTokens))
+
+		c.tel.tokens.Record(ctx, int64(usage.PromptTokens),
+			metric.WithAttributes(append(attrs, attribute.String("gen_ai.token.type", "input"))...))
+		c.tel.tokens.Record(ctx, int64(usage.CompletionTokens),
+			metric.WithAttributes(append(attrs, attribute.String("gen_ai.token.type", "output"))...))
+	}
+
+	if !c.stream {
+		return
+	}
+
+	c.tel.stream.Record(ctx, elapsed, metric.WithAttributes(attrs...))
+
+	if usage != nil && !c.first.IsZero() {
+		if gen := time.Since(c.first).Seconds(); gen > 0 {
+			tps := float64(usage.CompletionTokens) / gen
+			c.tel.tokensSec.Record(ctx, tps, metric.WithAttributes(attrs...))
+			c.span.SetAttributes(attribute.Float64("gen_ai.response.output_tokens_per_second", tps))
+		}
+	}
+}
+
+// operation maps the endpoint to a GenAI operation name.
+func operation(method string, endpoint string) string {
+	switch {
+	case strings.Contains(endpoint, "/chat/completions"):
+		return "chat"
+	case strings.Contains(endpoint, "/completions"):
+		return "text_completion"
+	case strings.Contains(endpoint, "/embed"):
+		return "embeddings"
+	default:
+		return strings.ToLower(method)
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	noopmetric "go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTelemetryFailedRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error": {"message": "out of memory"}}`)
+	}))
+	defer srv.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	cln := New(NoopLogger, WithSlog(nil), WithTelemetry(tp, noopmetric.NewMeterProvider()))
+
+	body := D{"model": "llama3", "temperature": 0.5, "max_tokens": 100}
+
+	var v D
+	err := cln.Do(context.Background(), http.MethodPost, srv.URL+"/v1/chat/completions", body, &v)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want 1", len(ended))
+	}
+	span := ended[0]
+
+	if span.Name() != "chat llama3" || span.SpanKind() != trace.SpanKindClient {
+		t.Errorf("got span %s of kind %s, want a client span named chat llama3", span.Name(), span.SpanKind())
+	}
+
+	got := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		got[kv.Key] = kv.Value
+	}
+
+	want := []attribute.KeyValue{
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.system", "openai"),
+		attribute.String("gen_ai.request.model", "llama3"),
+		attribute.String("server.address", "127.0.0.1"),
+		attribute.Float64("gen_ai.request.temperature", 0.5),
+		attribute.Float64("gen_ai.request.max_tokens", 100),
+		attribute.Int("http.response.status_code", http.StatusInternalServerError),
+	}
+	for _, kv := range want {
+		if v, exists := got[kv.Key]; !exists || v != kv.Value {
+			t.Errorf("got %s = %v, want %v", kv.Key, v.Emit(), kv.Value.Emit())
+		}
+	}
+
+	if status := span.Status(); status.Code != codes.Error || status.Description != err.Error() {
+		t.Errorf("got status %+v, want the error", status)
+	}
+
+	events := span.Events()
+	if len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("got events %+v, want the error recorded", events)
+	}
+}
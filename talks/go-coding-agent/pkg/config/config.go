@@ -0,0 +1,556 @@
+// Package config provides support for loading the agent's configuration from
+// layered YAML or TOML files, the environment and named profiles.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Set of file names searched for, in order, in every config directory.
+var fileNames = []string{"config.yaml", "config.yml", "config.toml"}
+
+// ProjectDir is the directory holding the project config, searched for from
+// the working directory up to the root of the filesystem.
+const ProjectDir = ".agent"
+
+// projectRestricted lists the keys a project file can only set when the
+// user trusts the project. A cloned repository could otherwise run commands
+// with hooks, send the user's API keys or prompts to a server of its choice,
+// or let every tool run without asking.
+var projectRestricted = [][]string{
+	{"hooks"},
+	{"endpoints"},
+	{"permissions"},
+	{"memory", "embed_url"},
+	{"memory", "path"},
+	{"trusted_projects"},
+}
+
+// Set of permissions a tool can be given.
+const (
+	PermissionAllow = "allow"
+	PermissionAsk   = "ask"
+	PermissionDeny  = "deny"
+)
+
+// =============================================================================
+
+// Endpoint is a chat completions server and the model to use on it. The API
+// key is never stored in the file, APIKeyEnv names the environment variable
+// holding it.
+type Endpoint struct {
+	URL       string `json:"url"`
+	Model     string `json:"model"`
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+}
+
+// APIKey returns the key from the environment, empty when the endpoint
+// doesn't need one.
+func (e Endpoint) APIKey() string {
+	if e.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(e.APIKeyEnv)
+}
+
+// Sampling holds the sampling parameters sent with every request. MaxTokens
+// is left to the server when zero.
+type Sampling struct {
+	Temperature float32 `json:"temperature"`
+	TopP        float32 `json:"top_p"`
+	TopK        int     `json:"top_k"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`
+}
+
+// Permissions decides which tools run without asking. Tools maps a tool name
+// to allow, ask or deny and Default applies to the tools not listed.
+type Permissions struct {
+	Default string            `json:"default"`
+	Tools   map[string]string `json:"tools,omitempty"`
+}
+
+// For returns the permission of the tool.
+func (p Permissions) For(tool string) string {
+	if perm, exists := p.Tools[tool]; exists {
+		return perm
+	}
+	return p.Default
+}
+
+// Delegate sets the limits of the sub-agents the delegate_task tool starts.
+// Zero values use the agent's defaults.
+type Delegate struct {
+	MaxTokens   int `json:"max_tokens,omitempty"`
+	MaxSteps    int `json:"max_steps,omitempty"`
+	MaxParallel int `json:"max_parallel,omitempty"`
+}
+
+// Hook is a command run around the tool calls, receiving the call as JSON on
+// stdin. Tools limits it to the named tools, all of them when empty.
+type Hook struct {
+	Command string   `json:"command"`
+	Tools   []string `json:"tools,omitempty"`
+}
+
+// Hooks lists the commands run before and after the tools, in order.
+type Hooks struct {
+	PreTool  []Hook `json:"pre_tool,omitempty"`
+	PostTool []Hook `json:"post_tool,omitempty"`
+}
+
+// Memory configures the facts the agent keeps across sessions. They're
+// embedded with EmbedModel on EmbedURL, the embeddings endpoint next to the
+// active endpoint when empty. Path defaults to memory.json in the
+// go-coding-agent directory of the user's config directory. Recall is the
+// number of memories sent with every turn when they're relevant.
+type Memory struct {
+	Enabled    bool   `json:"enabled"`
+	Path       string `json:"path,omitempty"`
+	EmbedURL   string `json:"embed_url,omitempty"`
+	EmbedModel string `json:"embed_model"`
+	Recall     int    `json:"recall"`
+}
+
+// Config is the complete configuration of the agent.
+type Config struct {
+	// Endpoint names the entry of Endpoints to use.
+	Endpoint  string              `json:"endpoint"`
+	Endpoints map[string]Endpoint `json:"endpoints"`
+
+	Sampling Sampling `json:"sampling"`
+
+	// Tools lists the enabled tools, all of them when empty.
+	Tools       []string    `json:"tools"`
+	Permissions Permissions `json:"permissions"`
+	Hooks       Hooks       `json:"hooks"`
+
+	// SystemPrompt replaces the template of the system prompt, see
+	// agent.DefaultSystemPrompt for the fields it can use.
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	MaxSteps     int      `json:"max_steps"`
+	Reasoning    string   `json:"reasoning"`
+	Delegate     Delegate `json:"delegate"`
+	Memory       Memory   `json:"memory"`
+
+	// Profiles are partial configs applied on top of the files when
+	// selected by name.
+	Profiles map[string]map[string]any `json:"profiles,omitempty"`
+
+	// TrustedProjects lists the directories whose project file can set the
+	// keys of projectRestricted. It's only read from the user file.
+	TrustedProjects []string `json:"trusted_projects,omitempty"`
+
+	// Profile is the selected profile, Sources the files that were read and
+	// Ignored the keys of an untrusted project file that were left out.
+	Profile string   `json:"profile,omitempty"`
+	Sources []string `json:"-"`
+	Ignored []string `json:"-"`
+}
+
+// Defaults returns the configuration used when nothing else is set, which
+// talks to a local ollama.
+func Defaults() Config {
+	return Config{
+		Endpoint: "local",
+		Endpoints: map[string]Endpoint{
+			"local": {
+				URL:   "http://localhost:11434/v1/chat/completions",
+				Model: "gpt-oss:20b",
+			},
+		},
+		Sampling: Sampling{
+			Temperature: 0.1,
+			TopP:        0.1,
+			TopK:        1,
+		},
+		Permissions: Permissions{
+			Default: PermissionAllow,
+		},
+		Memory: Memory{
+			EmbedModel: "nomic-embed-text",
+			Recall:     3,
+		},
+		MaxSteps:  25,
+		Reasoning: "collapsed",
+	}
+}
+
+// Active returns the endpoint selected by the config.
+func (cfg Config) Active() Endpoint {
+	return cfg.Endpoints[cfg.Endpoint]
+}
+
+// SetURL overrides the URL of the active endpoint.
+func (cfg *Config) SetURL(u string) {
+	e := cfg.Active()
+	e.URL = u
+	cfg.setActive(e)
+}
+
+// SetModel overrides the model of the active endpoint.
+func (cfg *Config) SetModel(model string) {
+	e := cfg.Active()
+	e.Model = model
+	cfg.setActive(e)
+}
+
+func (cfg *Config) setActive(e Endpoint) {
+	if cfg.Endpoints == nil {
+		cfg.Endpoints = make(map[string]Endpoint)
+	}
+	cfg.Endpoints[cfg.Endpoint] = e
+}
+
+// =============================================================================
+
+// Options locates the layers to load. Empty fields use the defaults.
+type Options struct {
+	// Profile selects a profile, overriding LLM_PROFILE and the profile key
+	// of the files.
+	Profile string
+
+	// UserFile defaults to config.yaml, .yml or .toml in the go-coding-agent
+	// directory of the user's config directory.
+	UserFile string
+
+	// Dir is where the search for the project config starts, the working
+	// directory by default.
+	Dir string
+}
+
+// Load builds the configuration from, in increasing priority, the defaults,
+// the user file, the project file, the selected profile and the LLM_*
+// environment variables. Flags are applied by the caller on the result.
+// Unless the project is one of the trusted projects of the user file, the
+// keys of its file that run commands or send data elsewhere are ignored and
+// listed in Ignored.
+func Load(opts Options) (Config, error) {
+	cfg := Defaults()
+
+	userFile := opts.UserFile
+	if userFile == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			userFile = find(filepath.Join(dir, "go-coding-agent"))
+		}
+	}
+
+	projectFile, err := findProject(opts.Dir)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, path := range []string{userFile, projectFile} {
+		if path == "" {
+			continue
+		}
+
+		layer, err := readFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		if path == projectFile && !cfg.trusts(filepath.Dir(filepath.Dir(path))) {
+			cfg.Ignored = restrict(layer)
+		}
+
+		if err := apply(&cfg, layer); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+
+		cfg.Sources = append(cfg.Sources, path)
+	}
+
+	switch {
+	case opts.Profile != "":
+		cfg.Profile = opts.Profile
+	case os.Getenv("LLM_PROFILE") != "":
+		cfg.Profile = os.Getenv("LLM_PROFILE")
+	}
+
+	if cfg.Profile != "" {
+		layer, exists := cfg.Profiles[cfg.Profile]
+		if !exists {
+			return Config{}, fmt.Errorf("unknown profile %q, have %s", cfg.Profile, strings.Join(slices.Sorted(maps.Keys(cfg.Profiles)), ", "))
+		}
+
+		if err := apply(&cfg, layer); err != nil {
+			return Config{}, fmt.Errorf("profile %s: %w", cfg.Profile, err)
+		}
+	}
+
+	if v := os.Getenv("LLM_ENDPOINT"); v != "" {
+		cfg.Endpoint = v
+	}
+
+	if v := os.Getenv("LLM_SERVER"); v != "" {
+		cfg.SetURL(v)
+	}
+
+	if v := os.Getenv("LLM_MODEL"); v != "" {
+		cfg.SetModel(v)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration and reports every problem found. The
+// tools are the names of the tools the program provides.
+func (cfg Config) Validate(tools []string) error {
+	var errs []error
+
+	for name, e := range cfg.Endpoints {
+		if _, err := url.ParseRequestURI(e.URL); err != nil {
+			errs = append(errs, fmt.Errorf("endpoints.%s.url: %q is not a valid URL", name, e.URL))
+		}
+
+		if e.Model == "" {
+			errs = append(errs, fmt.Errorf("endpoints.%s.model: missing", name))
+		}
+	}
+
+	if _, exists := cfg.Endpoints[cfg.Endpoint]; !exists {
+		errs = append(errs, fmt.Errorf("endpoint: %q is not one of the endpoints", cfg.Endpoint))
+	} else if e := cfg.Active(); e.APIKeyEnv != "" && e.APIKey() == "" {
+		errs = append(errs, fmt.Errorf("endpoints.%s.api_key_env: %s is not set", cfg.Endpoint, e.APIKeyEnv))
+	}
+
+	s := cfg.Sampling
+	if s.Temperature < 0 || s.Temperature > 2 {
+		errs = append(errs, fmt.Errorf("sampling.temperature: %v is outside 0..2", s.Temperature))
+	}
+	if s.TopP < 0 || s.TopP > 1 {
+		errs = append(errs, fmt.Errorf("sampling.top_p: %v is outside 0..1", s.TopP))
+	}
+	if s.TopK < 0 {
+		errs = append(errs, fmt.Errorf("sampling.top_k: %d is negative", s.TopK))
+	}
+	if s.MaxTokens < 0 {
+		errs = append(errs, fmt.Errorf("sampling.max_tokens: %d is negative", s.MaxTokens))
+	}
+
+	for _, name := range cfg.Tools {
+		if !slices.Contains(tools, name) {
+			errs = append(errs, fmt.Errorf("tools: unknown tool %q", name))
+		}
+	}
+
+	perms := map[string]string{"permissions.default": cfg.Permissions.Default}
+	for name, perm := range cfg.Permissions.Tools {
+		perms["permissions.tools."+name] = perm
+	}
+	for _, key := range slices.Sorted(maps.Keys(perms)) {
+		switch perms[key] {
+		case PermissionAllow, PermissionAsk, PermissionDeny:
+		default:
+			errs = append(errs, fmt.Errorf("%s: %q must be allow, ask or deny", key, perms[key]))
+		}
+	}
+
+	for event, hooks := range map[string][]Hook{"pre_tool": cfg.Hooks.PreTool, "post_tool": cfg.Hooks.PostTool} {
+		for i, h := range hooks {
+			if strings.TrimSpace(h.Command) == "" {
+				errs = append(errs, fmt.Errorf("hooks.%s[%d].command: missing", event, i))
+			}
+
+			for _, name := range h.Tools {
+				if !slices.Contains(tools, name) {
+					errs = append(errs, fmt.Errorf("hooks.%s[%d].tools: unknown tool %q", event, i, name))
+				}
+			}
+		}
+	}
+
+	if cfg.MaxSteps < 0 {
+		errs = append(errs, fmt.Errorf("max_steps: %d is negative", cfg.MaxSteps))
+	}
+
+	d := cfg.Delegate
+	if d.MaxTokens < 0 || d.MaxSteps < 0 || d.MaxParallel < 0 {
+		errs = append(errs, errors.New("delegate: the limits can't be negative"))
+	}
+
+	m := cfg.Memory
+	if m.EmbedURL != "" {
+		if _, err := url.ParseRequestURI(m.EmbedURL); err != nil {
+			errs = append(errs, fmt.Errorf("memory.embed_url: %q is not a valid URL", m.EmbedURL))
+		}
+	}
+	if m.Enabled && m.EmbedModel == "" {
+		errs = append(errs, errors.New("memory.embed_model: missing"))
+	}
+	if m.Recall < 0 {
+		errs = append(errs, fmt.Errorf("memory.recall: %d is negative", m.Recall))
+	}
+
+	switch cfg.Reasoning {
+	case "hidden", "collapsed", "full":
+	default:
+		errs = append(errs, fmt.Errorf("reasoning: %q must be hidden, collapsed or full", cfg.Reasoning))
+	}
+
+	// Map iteration makes the order random, keep the report stable.
+	slices.SortStableFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+
+	return errors.Join(errs...)
+}
+
+// trusts reports whether the project in dir is one of the trusted projects.
+func (cfg Config) trusts(dir string) bool {
+	for _, p := range cfg.TrustedProjects {
+		if p, err := filepath.Abs(p); err == nil && p == dir {
+			return true
+		}
+	}
+
+	return false
+}
+
+// =============================================================================
+
+// restrict removes the keys of projectRestricted from the layer and its
+// profiles, returning the ones it held. The keys are matched without case at
+// every level, as encoding/json does when the layer is applied.
+func restrict(layer map[string]any) []string {
+	var removed []string
+
+	strip := func(m map[string]any, prefix string) {
+		for _, key := range projectRestricted {
+			if remove(m, key) {
+				removed = append(removed, prefix+strings.Join(key, "."))
+			}
+		}
+	}
+
+	strip(layer, "")
+
+	for _, k := range slices.Sorted(maps.Keys(layer)) {
+		if !strings.EqualFold(k, "profiles") {
+			continue
+		}
+
+		profiles, _ := layer[k].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(profiles)) {
+			if profile, ok := profiles[name].(map[string]any); ok {
+				strip(profile, "profiles."+name+".")
+			}
+		}
+	}
+
+	return removed
+}
+
+// remove deletes the key, a path into nested maps, under every spelling of
+// its parts and reports whether it was there.
+func remove(m map[string]any, key []string) bool {
+	var found bool
+	for k, v := range m {
+		if !strings.EqualFold(k, key[0]) {
+			continue
+		}
+
+		if len(key) == 1 {
+			delete(m, k)
+			found = true
+			continue
+		}
+
+		if child, ok := v.(map[string]any); ok && remove(child, key[1:]) {
+			found = true
+		}
+	}
+
+	return found
+}
+
+// apply merges a layer into the config. Only the keys present in the layer
+// change, maps are merged by key and lists replaced.
+func apply(cfg *Config, layer map[string]any) error {
+	data, err := json.Marshal(layer)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	return nil
+}
+
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	layer := make(map[string]any)
+
+	switch filepath.Ext(path) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &layer); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+	default:
+		if err := yaml.Unmarshal(data, &layer); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return layer, nil
+}
+
+// find returns the first config file present in the directory.
+func find(dir string) string {
+	for _, name := range fileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// findProject searches for the project config from the directory up.
+func findProject(dir string) (string, error) {
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", fmt.Errorf("working directory: %w", err)
+		}
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("abs: %w", err)
+	}
+
+	for {
+		if path := find(filepath.Join(dir, ProjectDir)); path != "" {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
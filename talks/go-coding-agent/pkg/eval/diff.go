@@ -0,0 +1,177 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// TaskDiff pairs the results of a task in two runs. Before or After is nil
+// when the task is missing from that run.
+type TaskDiff struct {
+	Task   string
+	Before *Result
+	After  *Result
+}
+
+// Change describes how the outcome of the task changed: fixed, broken,
+// added, removed or empty when it didn't.
+func (d TaskDiff) Change() string {
+	switch {
+	case d.Before == nil:
+		return "added"
+	case d.After == nil:
+		return "removed"
+	case !d.Before.Passed && d.After.Passed:
+		return "fixed"
+	case d.Before.Passed && !d.After.Passed:
+		return "broken"
+	}
+
+	return ""
+}
+
+// Diff pairs the tasks of two runs by name, in the order of the second run
+// with the removed tasks last.
+func Diff(before Run, after Run) []TaskDiff {
+	old := make(map[string]*Result, len(before.Results))
+	for i := range before.Results {
+		old[before.Results[i].Task] = &before.Results[i]
+	}
+
+	var diffs []TaskDiff
+	for i := range after.Results {
+		res := &after.Results[i]
+		diffs = append(diffs, TaskDiff{Task: res.Task, Before: old[res.Task], After: res})
+		delete(old, res.Task)
+	}
+
+	for i := range before.Results {
+		if res := &before.Results[i]; old[res.Task] != nil {
+			diffs = append(diffs, TaskDiff{Task: res.Task, Before: res})
+		}
+	}
+
+	return diffs
+}
+
+// WriteDiff writes the comparison of the two runs as a table, followed by
+// the totals.
+func WriteDiff(w io.Writer, before Run, after Run) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tPASSED\tTOKENS\tTIME\tTOOL CALLS\tCHANGE")
+
+	var tb, ta totals
+	for _, d := range Diff(before, after) {
+		tb.add(d.Before)
+		ta.add(d.After)
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.Task,
+			compare(d.Before, d.After, func(r *Result) string { return passed(r.Passed) }),
+			delta(d.Before, d.After, func(r *Result) int64 { return int64(r.TotalTokens) }, count),
+			delta(d.Before, d.After, func(r *Result) int64 { return r.DurationMS }, duration),
+			delta(d.Before, d.After, func(r *Result) int64 { return int64(r.ToolCalls) }, count),
+			d.Change(),
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%s (%s) -> %s (%s)\n", before.Suite, label(before), after.Suite, label(after))
+	fmt.Fprintf(w, "passed      %d/%d -> %d/%d\n", tb.passed, tb.tasks, ta.passed, ta.tasks)
+	fmt.Fprintf(w, "tokens      %s\n", change(tb.tokens, ta.tokens, count))
+	fmt.Fprintf(w, "time        %s\n", change(tb.duration, ta.duration, duration))
+	fmt.Fprintf(w, "tool calls  %s\n", change(tb.toolCalls, ta.toolCalls, count))
+
+	return nil
+}
+
+// =============================================================================
+
+type totals struct {
+	tasks     int
+	passed    int
+	tokens    int64
+	duration  int64
+	toolCalls int64
+}
+
+func (t *totals) add(r *Result) {
+	if r == nil {
+		return
+	}
+
+	t.tasks++
+	if r.Passed {
+		t.passed++
+	}
+	t.tokens += int64(r.TotalTokens)
+	t.duration += r.DurationMS
+	t.toolCalls += int64(r.ToolCalls)
+}
+
+func label(r Run) string {
+	if r.Model == "" {
+		return r.Started.Format(time.DateTime)
+	}
+	return r.Model + ", " + r.Started.Format(time.DateTime)
+}
+
+func passed(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+func count(n int64) string {
+	return fmt.Sprint(n)
+}
+
+func duration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(100 * time.Millisecond).String()
+}
+
+func compare(before *Result, after *Result, value func(r *Result) string) string {
+	switch {
+	case before == nil:
+		return value(after)
+	case after == nil:
+		return value(before) + " -> -"
+	}
+
+	b, a := value(before), value(after)
+	if b == a {
+		return a
+	}
+	return b + " -> " + a
+}
+
+func delta(before *Result, after *Result, value func(r *Result) int64, format func(int64) string) string {
+	switch {
+	case before == nil:
+		return format(value(after))
+	case after == nil:
+		return format(value(before)) + " -> -"
+	}
+
+	return change(value(before), value(after), format)
+}
+
+// change shows the two values with the relative change between them.
+func change(before int64, after int64, format func(int64) string) string {
+	if before == after {
+		return format(after)
+	}
+
+	if before == 0 {
+		return fmt.Sprintf("%s -> %s", format(before), format(after))
+	}
+
+	pct := float64(after-before) / float64(before) * 100
+	return fmt.Sprintf("%s -> %s (%+.0f%%)", format(before), format(after), pct)
+}
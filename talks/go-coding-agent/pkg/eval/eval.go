@@ -0,0 +1,510 @@
+// Package eval provides support for measuring the coding agent on suites of
+// tasks, each run headless in a fresh copy of a fixture repository.
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxOutput caps the output of the verification kept in the results.
+const maxOutput = 4 * 1024
+
+// Task is a single problem handed to the agent. It passes when the agent
+// finishes without error, the verification command exits with 0 and the
+// answer holds the expected output.
+type Task struct {
+	Name string `yaml:"name"`
+
+	// Fixture is the directory copied into the working directory of the
+	// agent, relative to the suite file. The agent starts in an empty
+	// directory when it's empty.
+	Fixture string `yaml:"fixture"`
+
+	Prompt string `yaml:"prompt"`
+	Verify string `yaml:"verify"`
+
+	// Expect is a line the answer must have, compared without the spaces
+	// around it. ExpectRegexp is a regular expression the answer must
+	// match, (?m) makes ^ and $ match at the lines.
+	Expect       string `yaml:"expect"`
+	ExpectRegexp string `yaml:"expect_regexp"`
+
+	Timeout time.Duration `yaml:"timeout"`
+
+	expect *regexp.Regexp
+}
+
+// Suite is a set of tasks run together.
+type Suite struct {
+	Name string `yaml:"name"`
+
+	// Args are added to the command line of the agent for every task.
+	Args []string `yaml:"args"`
+
+	// Config is the user config of the agent for every task, in place of
+	// the user's own. Every task gets a config directory of its own, where
+	// the memories are kept unless the config sets memory.path.
+	Config map[string]any `yaml:"config"`
+
+	// Timeout applies to the tasks without their own, 10 minutes by default.
+	Timeout time.Duration `yaml:"timeout"`
+
+	Tasks []Task `yaml:"tasks"`
+
+	dir string
+}
+
+// LoadSuite reads the suite from the YAML file.
+func LoadSuite(path string) (Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Suite{}, fmt.Errorf("read suite: %w", err)
+	}
+
+	var s Suite
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return Suite{}, fmt.Errorf("decode suite %s: %w", path, err)
+	}
+
+	s.dir = filepath.Dir(path)
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if s.Timeout == 0 {
+		s.Timeout = 10 * time.Minute
+	}
+
+	var errs []error
+	names := make(map[string]bool)
+	for i, t := range s.Tasks {
+		switch {
+		case t.Name == "":
+			errs = append(errs, fmt.Errorf("task %d: missing name", i+1))
+		case names[t.Name]:
+			errs = append(errs, fmt.Errorf("task %s: duplicate name", t.Name))
+		}
+		names[t.Name] = true
+
+		if strings.TrimSpace(t.Prompt) == "" {
+			errs = append(errs, fmt.Errorf("task %s: missing prompt", t.Name))
+		}
+
+		if t.Verify == "" && t.Expect == "" && t.ExpectRegexp == "" {
+			errs = append(errs, fmt.Errorf("task %s: needs verify, expect or expect_regexp", t.Name))
+		}
+
+		if t.ExpectRegexp != "" {
+			re, err := regexp.Compile(t.ExpectRegexp)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("task %s: expect_regexp: %w", t.Name, err))
+			}
+			s.Tasks[i].expect = re
+		}
+
+		if t.Fixture != "" {
+			if info, err := os.Stat(filepath.Join(s.dir, t.Fixture)); err != nil || !info.IsDir() {
+				errs = append(errs, fmt.Errorf("task %s: fixture %s is not a directory", t.Name, t.Fixture))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Suite{}, fmt.Errorf("suite %s:\n%w", path, err)
+	}
+
+	return s, nil
+}
+
+// =============================================================================
+
+// Result is the outcome of a single task.
+type Result struct {
+	Task       string `json:"task"`
+	Passed     bool   `json:"passed"`
+	Reason     string `json:"reason,omitempty"`
+	ExitCode   int    `json:"exit_code"`
+	ExitReason string `json:"exit_reason,omitempty"`
+	Answer     string `json:"answer,omitempty"`
+	Verify     string `json:"verify_output,omitempty"`
+
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+	TotalTokens      int   `json:"total_tokens"`
+	ToolCalls        int   `json:"tool_calls"`
+	DurationMS       int64 `json:"duration_ms"`
+
+	// Dir is the working directory of the agent when it was kept.
+	Dir string `json:"dir,omitempty"`
+}
+
+// Run is the outcome of a whole suite.
+type Run struct {
+	Suite    string    `json:"suite"`
+	Model    string    `json:"model,omitempty"`
+	Args     []string  `json:"args,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration int64     `json:"duration_ms"`
+	Results  []Result  `json:"results"`
+}
+
+// Passed returns the number of tasks that passed.
+func (r Run) Passed() int {
+	var n int
+	for _, res := range r.Results {
+		if res.Passed {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Save writes the run as JSON.
+func (r Run) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// LoadRun reads a run saved with Save.
+func LoadRun(path string) (Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Run{}, fmt.Errorf("read run: %w", err)
+	}
+
+	var r Run
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Run{}, fmt.Errorf("decode run %s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// =============================================================================
+
+// Runner runs the suites with the agent binary.
+type Runner struct {
+	agent    string
+	args     []string
+	parallel int
+	keep     bool
+	progress func(res Result)
+}
+
+// NewRunner constructs a runner calling the agent binary at path, with the
+// args added to the command line of every task.
+func NewRunner(path string, args []string, options ...func(r *Runner)) *Runner {
+	r := Runner{
+		agent:    path,
+		args:     args,
+		parallel: 1,
+	}
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	return &r
+}
+
+// WithParallel runs n tasks at once, one by default since a local model
+// serves one request at a time anyway.
+func WithParallel(n int) func(r *Runner) {
+	return func(r *Runner) {
+		r.parallel = max(n, 1)
+	}
+}
+
+// WithKeep keeps the working directories of the tasks for inspection.
+func WithKeep() func(r *Runner) {
+	return func(r *Runner) {
+		r.keep = true
+	}
+}
+
+// WithProgress calls the function as every task finishes.
+func WithProgress(fn func(res Result)) func(r *Runner) {
+	return func(r *Runner) {
+		r.progress = fn
+	}
+}
+
+// Run runs every task of the suite, stopping early when the context is
+// canceled.
+func (r *Runner) Run(ctx context.Context, s Suite) Run {
+	run := Run{
+		Suite:   s.Name,
+		Args:    slices.Concat(s.Args, r.args),
+		Started: time.Now(),
+		Results: make([]Result, len(s.Tasks)),
+	}
+
+	var mu sync.Mutex
+	sem := make(chan struct{}, r.parallel)
+
+	var wg sync.WaitGroup
+	for i, t := range s.Tasks {
+		wg.Go(func() {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				run.Results[i] = Result{Task: t.Name, Reason: "not run: " + ctx.Err().Error()}
+				return
+			}
+
+			res, model := r.runTask(ctx, s, t, run.Args)
+			run.Results[i] = res
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if run.Model == "" {
+				run.Model = model
+			}
+			if r.progress != nil {
+				r.progress(res)
+			}
+		})
+	}
+	wg.Wait()
+
+	run.Duration = time.Since(run.Started).Milliseconds()
+
+	return run
+}
+
+// agentOutput is the part of the JSON written by the agent in headless mode
+// the runner needs.
+type agentOutput struct {
+	Content    string `json:"content"`
+	Error      string `json:"error"`
+	ExitReason string `json:"exit_reason"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	ToolCalls  int    `json:"tool_calls"`
+	Usage      struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+	Events []struct {
+		Type  string `json:"type"`
+		Model string `json:"model"`
+	} `json:"events"`
+}
+
+// runTask runs the agent on a copy of the fixture, then the verification.
+// The agent works in a directory next to its config directory, so it can't
+// see its config nor its memories.
+func (r *Runner) runTask(ctx context.Context, s Suite, t Task, args []string) (Result, string) {
+	res := Result{Task: t.Name}
+
+	base, err := os.MkdirTemp("", "eval-"+t.Name+"-")
+	if err != nil {
+		res.Reason = err.Error()
+		return res, ""
+	}
+
+	dir := filepath.Join(base, "work")
+	if r.keep {
+		res.Dir = dir
+	} else {
+		defer os.RemoveAll(base)
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		res.Reason = err.Error()
+		return res, ""
+	}
+
+	configFile, err := writeConfig(filepath.Join(base, "config"), s.Config)
+	if err != nil {
+		res.Reason = fmt.Sprintf("config: %s", err)
+		return res, ""
+	}
+
+	if t.Fixture != "" {
+		if err := os.CopyFS(dir, os.DirFS(filepath.Join(s.dir, t.Fixture))); err != nil {
+			res.Reason = fmt.Sprintf("copy fixture: %s", err)
+			return res, ""
+		}
+	}
+
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = s.Timeout
+	}
+
+	// The agent enforces the timeout itself so it still reports what it did,
+	// the context is a backstop.
+	cmdArgs := append(append([]string{"-config", configFile, "-output", "json", "-timeout", timeout.String()}, args...), "-p", t.Prompt)
+
+	actx, cancel := context.WithTimeout(ctx, timeout+30*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(actx, r.agent, cmdArgs...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	runErr := cmd.Run()
+
+	var out agentOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		res.DurationMS = time.Since(start).Milliseconds()
+		res.Reason = fmt.Sprintf("agent: %s", firstLine(stderr.String(), runErr))
+		return res, ""
+	}
+
+	var model string
+	for _, e := range out.Events {
+		if e.Type == "start" {
+			model = e.Model
+		}
+	}
+
+	res.ExitCode = out.ExitCode
+	res.ExitReason = out.ExitReason
+	res.Answer = out.Content
+	res.PromptTokens = out.Usage.PromptTokens
+	res.CompletionTokens = out.Usage.CompletionTokens
+	res.TotalTokens = out.Usage.TotalTokens
+	res.ToolCalls = out.ToolCalls
+	res.DurationMS = out.DurationMS
+
+	switch {
+	case out.ExitCode != 0:
+		res.Reason = fmt.Sprintf("agent stopped: %s", out.ExitReason)
+		if out.Error != "" {
+			res.Reason += ": " + out.Error
+		}
+		return res, model
+
+	case t.Expect != "" && !hasLine(out.Content, t.Expect):
+		res.Reason = fmt.Sprintf("answer has no line %q", t.Expect)
+		return res, model
+
+	case t.expect != nil && !t.expect.MatchString(out.Content):
+		res.Reason = fmt.Sprintf("answer doesn't match %s", t.expect)
+		return res, model
+	}
+
+	if t.Verify != "" {
+		output, err := verify(ctx, dir, t.Verify)
+		res.Verify = output
+		if err != nil {
+			res.Reason = fmt.Sprintf("verify: %s", err)
+			return res, model
+		}
+	}
+
+	res.Passed = true
+
+	return res, model
+}
+
+// writeConfig writes the config into the directory, with the memories kept
+// there unless it says otherwise, and returns the path of the file.
+func writeConfig(dir string, config map[string]any) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	cfg := maps.Clone(config)
+	if cfg == nil {
+		cfg = make(map[string]any)
+	}
+
+	memory, _ := cfg["memory"].(map[string]any)
+	memory = maps.Clone(memory)
+	if memory == nil {
+		memory = make(map[string]any)
+	}
+	if _, exists := memory["path"]; !exists {
+		memory["path"] = filepath.Join(dir, "memory.json")
+	}
+	cfg["memory"] = memory
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// hasLine reports whether one of the lines of the text is the want line,
+// ignoring the spaces around them.
+func hasLine(text string, want string) bool {
+	want = strings.TrimSpace(want)
+	for line := range strings.Lines(text) {
+		if strings.TrimSpace(line) == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+func verify(ctx context.Context, dir string, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+
+	output := out.String()
+	if len(output) > maxOutput {
+		output = output[len(output)-maxOutput:]
+	}
+
+	return output, err
+}
+
+func firstLine(s string, err error) string {
+	if line, _, _ := strings.Cut(strings.TrimSpace(s), "\n"); line != "" {
+		return line
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "no output"
+}
@@ -0,0 +1,129 @@
+package eval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAgent writes a script standing for the agent: it answers with the
+// answer and saves its arguments and the config it's given to the record
+// file.
+func fakeAgent(t *testing.T, answer string) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+
+	script := `#!/bin/sh
+echo "$@" > ` + record + `
+cat "$2" >> ` + record + `
+pwd >> ` + record + `
+printf '{"content": "%s", "exit_code": 0, "events": [{"type": "start", "model": "fake"}]}' '` + answer + `'
+`
+
+	path := filepath.Join(dir, "agent")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, record
+}
+
+func suite(t *testing.T, yaml string) Suite {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSuite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestExpect(t *testing.T) {
+	agent, _ := fakeAgent(t, `There are\n  12  \nfiles.`)
+
+	s := suite(t, `
+tasks:
+  - {name: line, prompt: count, expect: "12"}
+  - {name: part, prompt: count, expect: "1"}
+  - {name: regexp, prompt: count, expect_regexp: '(?m)^\s*\d+\s*$'}
+  - {name: no-match, prompt: count, expect_regexp: '^\d+$'}
+`)
+
+	run := NewRunner(agent, nil).Run(context.Background(), s)
+
+	want := map[string]bool{"line": true, "part": false, "regexp": true, "no-match": false}
+	for _, res := range run.Results {
+		if res.Passed != want[res.Task] {
+			t.Errorf("%s: got passed %t, want %t: %s", res.Task, res.Passed, want[res.Task], res.Reason)
+		}
+	}
+
+	if run.Model != "fake" {
+		t.Errorf("got model %q", run.Model)
+	}
+}
+
+func TestBadRegexp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	if err := os.WriteFile(path, []byte("tasks:\n  - {name: a, prompt: p, expect_regexp: '('}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSuite(path); err == nil || !strings.Contains(err.Error(), "expect_regexp") {
+		t.Errorf("got %v, want an error for the regexp", err)
+	}
+}
+
+func TestIsolatedConfig(t *testing.T) {
+	agent, record := fakeAgent(t, "done")
+
+	s := suite(t, `
+config:
+  max_steps: 3
+  memory:
+    enabled: true
+tasks:
+  - {name: a, prompt: go, expect: done}
+`)
+
+	run := NewRunner(agent, []string{"-model", "m"}, WithKeep()).Run(context.Background(), s)
+
+	res := run.Results[0]
+	if !res.Passed {
+		t.Fatalf("failed: %s", res.Reason)
+	}
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(res.Dir)) })
+
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+
+	configDir := filepath.Join(filepath.Dir(res.Dir), "config")
+
+	if !strings.HasPrefix(got, "-config "+filepath.Join(configDir, "config.yaml")+" ") || !strings.Contains(got, "-model m -p go") {
+		t.Errorf("got arguments %s", got)
+	}
+
+	for _, want := range []string{"max_steps: 3", "enabled: true", "path: " + filepath.Join(configDir, "memory.json")} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the config has no %q:\n%s", want, got)
+		}
+	}
+
+	// The agent doesn't work in the directory of its config.
+	if !strings.HasSuffix(strings.TrimSpace(got), res.Dir) {
+		t.Errorf("got the agent working elsewhere than %s:\n%s", res.Dir, got)
+	}
+}
@@ -0,0 +1,284 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"text/template"
+	"text/template/parse"
+)
+
+// check walks the parsed template, the partials it includes and the bodies
+// of its if, with and range actions, and resolves every field chain against
+// T. Nothing is executed, so the functions of the template aren't called.
+// Where the type isn't known statically, like an any field or the result of
+// index, the chain isn't checked further. Every unknown field is reported.
+func check[T any](tmpl *template.Template, funcs template.FuncMap) error {
+	c := checker{
+		tmpl:  tmpl,
+		funcs: funcs,
+		seen:  make(map[string]bool),
+	}
+
+	root := known(reflect.TypeFor[T]())
+	c.walk(tmpl.Tree.Root, root, map[string]reflect.Type{"$": root})
+
+	return errors.Join(c.errs...)
+}
+
+// checker holds the state of a check. A nil reflect.Type stands for a value
+// whose type isn't known.
+type checker struct {
+	tmpl  *template.Template
+	funcs template.FuncMap
+	seen  map[string]bool
+	errs  []error
+}
+
+func (c *checker) walk(node parse.Node, dot reflect.Type, vars map[string]reflect.Type) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, dot, vars)
+		}
+
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot, vars)
+
+	case *parse.IfNode:
+		scope := maps.Clone(vars)
+		c.pipe(n.Pipe, dot, scope)
+		c.walk(n.List, dot, scope)
+		c.walk(n.ElseList, dot, scope)
+
+	case *parse.WithNode:
+		scope := maps.Clone(vars)
+		typ := c.pipe(n.Pipe, dot, scope)
+		c.walk(n.List, typ, scope)
+		c.walk(n.ElseList, dot, scope)
+
+	case *parse.RangeNode:
+		scope := maps.Clone(vars)
+		key, elem := c.rangePipe(n.Pipe, dot, scope)
+		switch len(n.Pipe.Decl) {
+		case 1:
+			scope[n.Pipe.Decl[0].Ident[0]] = elem
+		case 2:
+			scope[n.Pipe.Decl[0].Ident[0]] = key
+			scope[n.Pipe.Decl[1].Ident[0]] = elem
+		}
+		c.walk(n.List, elem, scope)
+		c.walk(n.ElseList, dot, scope)
+
+	case *parse.TemplateNode:
+		var typ reflect.Type
+		if n.Pipe != nil {
+			typ = c.pipe(n.Pipe, dot, vars)
+		}
+		c.partial(n.Name, typ)
+	}
+}
+
+// partial checks the template included by name with a dot of the type, once
+// per type so recursive partials end.
+func (c *checker) partial(name string, dot reflect.Type) {
+	key := fmt.Sprintf("%s %v", name, dot)
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+
+	t := c.tmpl.Lookup(name)
+	if t == nil || t.Tree == nil {
+		return
+	}
+
+	c.walk(t.Tree.Root, dot, map[string]reflect.Type{"$": dot})
+}
+
+// pipe checks the commands of the pipeline and returns the type of its
+// result, declaring its variables in vars.
+func (c *checker) pipe(p *parse.PipeNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	if p == nil {
+		return nil
+	}
+
+	typ := c.commands(p, dot, vars)
+	for _, v := range p.Decl {
+		vars[v.Ident[0]] = typ
+	}
+
+	return typ
+}
+
+// commands checks the commands of the pipeline and returns the type of the
+// result of the last one.
+func (c *checker) commands(p *parse.PipeNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	var typ reflect.Type
+	for _, cmd := range p.Cmds {
+		typ = c.command(cmd, dot, vars)
+	}
+
+	return typ
+}
+
+// rangePipe checks the pipeline of a range and returns the types of the
+// keys and the elements it iterates over.
+func (c *checker) rangePipe(p *parse.PipeNode, dot reflect.Type, vars map[string]reflect.Type) (reflect.Type, reflect.Type) {
+	typ := indirect(c.commands(p, dot, vars))
+	if typ == nil {
+		return nil, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		return reflect.TypeFor[int](), known(typ.Elem())
+	case reflect.Map:
+		return known(typ.Key()), known(typ.Elem())
+	case reflect.Chan:
+		return nil, known(typ.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typ, typ
+	}
+
+	return nil, nil
+}
+
+// command checks the arguments of the command and returns the type of its
+// result.
+func (c *checker) command(cmd *parse.CommandNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	for _, arg := range cmd.Args[1:] {
+		c.arg(arg, dot, vars)
+	}
+
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		return c.function(ident.Ident)
+	}
+
+	return c.arg(cmd.Args[0], dot, vars)
+}
+
+// arg checks an argument and returns its type.
+func (c *checker) arg(node parse.Node, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+
+	case *parse.FieldNode:
+		return c.fields(n, dot, n.Ident)
+
+	case *parse.VariableNode:
+		typ, exists := vars[n.Ident[0]]
+		if !exists {
+			return nil
+		}
+		return c.fields(n, typ, n.Ident[1:])
+
+	case *parse.ChainNode:
+		return c.fields(n, c.arg(n.Node, dot, vars), n.Field)
+
+	case *parse.PipeNode:
+		return c.pipe(n, dot, maps.Clone(vars))
+
+	case *parse.IdentifierNode:
+		return c.function(n.Ident)
+
+	case *parse.StringNode:
+		return reflect.TypeFor[string]()
+
+	case *parse.BoolNode:
+		return reflect.TypeFor[bool]()
+	}
+
+	return nil
+}
+
+// fields resolves the chain of field names starting from the type.
+func (c *checker) fields(node parse.Node, typ reflect.Type, names []string) reflect.Type {
+	for _, name := range names {
+		typ = indirect(typ)
+		if typ == nil || typ.Kind() == reflect.Interface {
+			return nil
+		}
+
+		if m, exists := reflect.PointerTo(typ).MethodByName(name); exists {
+			if m.Type.NumOut() == 0 {
+				return nil
+			}
+			typ = known(m.Type.Out(0))
+			continue
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			f, exists := typ.FieldByName(name)
+			if !exists || !f.IsExported() {
+				location, _ := c.tmpl.ErrorContext(node)
+				c.errs = append(c.errs, fmt.Errorf("%s: can't evaluate field %s in type %s", location, name, typ))
+				return nil
+			}
+			typ = known(f.Type)
+
+		case reflect.Map:
+			typ = known(typ.Elem())
+
+		default:
+			location, _ := c.tmpl.ErrorContext(node)
+			c.errs = append(c.errs, fmt.Errorf("%s: can't evaluate field %s in type %s", location, name, typ))
+			return nil
+		}
+	}
+
+	return typ
+}
+
+// function returns the type of the result of the function, nil when it
+// depends on the arguments.
+func (c *checker) function(name string) reflect.Type {
+	switch name {
+	case "print", "printf", "println", "html", "js", "urlquery":
+		return reflect.TypeFor[string]()
+	case "len":
+		return reflect.TypeFor[int]()
+	case "eq", "ne", "lt", "le", "gt", "ge", "not":
+		return reflect.TypeFor[bool]()
+	}
+
+	fn, exists := c.funcs[name]
+	if !exists {
+		return nil
+	}
+
+	typ := reflect.TypeOf(fn)
+	if typ.Kind() != reflect.Func || typ.NumOut() == 0 {
+		return nil
+	}
+
+	return known(typ.Out(0))
+}
+
+// =============================================================================
+
+// indirect returns the type a pointer points to.
+func indirect(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	return typ
+}
+
+// known returns the type, or nil for an interface whose dynamic type is only
+// known at run time.
+func known(typ reflect.Type) reflect.Type {
+	if typ == nil || typ.Kind() == reflect.Interface {
+		return nil
+	}
+
+	return typ
+}
@@ -0,0 +1,373 @@
+// Package prompt provides support for building prompts from text/template
+// templates with typed inputs, partials and few-shot examples, loaded from
+// files that can be reloaded while iterating on them.
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrTooLong is returned when a prompt is estimated to use more tokens than
+// the limit of its template.
+var ErrTooLong = errors.New("prompt exceeds the token limit")
+
+// Example is a single input and the output expected for it, shown to the
+// model as a few-shot example.
+type Example struct {
+	Input  string `json:"input" yaml:"input"`
+	Output string `json:"output" yaml:"output"`
+}
+
+// ExampleMessages turns the examples into user and assistant messages, to
+// be sent ahead of the conversation with client.WithConversation.
+func ExampleMessages(examples []Example) []client.D {
+	msgs := make([]client.D, 0, 2*len(examples))
+	for _, e := range examples {
+		msgs = append(msgs,
+			client.D{"role": "user", "content": e.Input},
+			client.D{"role": "assistant", "content": e.Output},
+		)
+	}
+
+	return msgs
+}
+
+// EstimateTokens returns a rough count of the tokens of the text, for
+// budgeting before a tokenizer is involved. It assumes 4 characters per
+// token, or 3 tokens for every 4 words when the words are short.
+func EstimateTokens(text string) int {
+	chars := (utf8.RuneCountInString(text) + 3) / 4
+	words := len(strings.Fields(text)) * 4 / 3
+
+	return max(chars, words)
+}
+
+// =============================================================================
+
+// Options configures a template.
+type Options struct {
+	partials  map[string]string
+	funcs     template.FuncMap
+	maxTokens int
+}
+
+// WithPartial adds a template the prompt can include with
+// {{template "name" .}}.
+func WithPartial(name string, text string) func(o *Options) {
+	return func(o *Options) {
+		o.partials[name] = text
+	}
+}
+
+// WithFuncs adds functions the template can call.
+func WithFuncs(funcs template.FuncMap) func(o *Options) {
+	return func(o *Options) {
+		maps.Copy(o.funcs, funcs)
+	}
+}
+
+// WithMaxTokens makes Execute fail with ErrTooLong when the prompt is
+// estimated to use more than n tokens.
+func WithMaxTokens(n int) func(o *Options) {
+	return func(o *Options) {
+		o.maxTokens = n
+	}
+}
+
+// Template is a prompt filled from an input of type T. The fields the
+// template uses are checked against T when it's parsed, so a typo fails
+// early instead of producing an empty prompt.
+type Template[T any] struct {
+	name string
+	opts Options
+
+	// The files the template was loaded from and their modification times,
+	// empty when it was parsed from a string.
+	path  string
+	files map[string]time.Time
+
+	mu   sync.RWMutex
+	tmpl *template.Template
+}
+
+// New parses the template text.
+func New[T any](name string, text string, options ...func(o *Options)) (*Template[T], error) {
+	t := Template[T]{
+		name: name,
+		opts: newOptions(options),
+	}
+
+	tmpl, err := t.parse(text, t.opts.partials)
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+
+	return &t, nil
+}
+
+// Load parses the template file. The files next to it whose name starts
+// with an underscore are its partials, _context.tmpl being included with
+// {{template "context" .}}.
+func Load[T any](path string, options ...func(o *Options)) (*Template[T], error) {
+	t := Template[T]{
+		name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		opts: newOptions(options),
+		path: path,
+	}
+
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func newOptions(options []func(o *Options)) Options {
+	o := Options{
+		partials: make(map[string]string),
+		funcs: template.FuncMap{
+			"examples": formatExamples,
+			"join":     strings.Join,
+			"trim":     strings.TrimSpace,
+			"indent":   indent,
+		},
+	}
+
+	for _, option := range options {
+		option(&o)
+	}
+
+	return o
+}
+
+// Name returns the name of the template.
+func (t *Template[T]) Name() string {
+	return t.name
+}
+
+// Execute fills the template with the input.
+func (t *Template[T]) Execute(in T) (string, error) {
+	text, err := t.render(in)
+	if err != nil {
+		return "", err
+	}
+
+	if t.opts.maxTokens > 0 {
+		if n := EstimateTokens(text); n > t.opts.maxTokens {
+			return "", fmt.Errorf("%w: prompt %s is about %d tokens, the limit is %d", ErrTooLong, t.name, n, t.opts.maxTokens)
+		}
+	}
+
+	return text, nil
+}
+
+// Tokens returns the estimated number of tokens of the prompt filled with
+// the input.
+func (t *Template[T]) Tokens(in T) (int, error) {
+	text, err := t.render(in)
+	if err != nil {
+		return 0, err
+	}
+
+	return EstimateTokens(text), nil
+}
+
+func (t *Template[T]) render(in T) (string, error) {
+	t.mu.RLock()
+	tmpl := t.tmpl
+	t.mu.RUnlock()
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, in); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", t.name, err)
+	}
+
+	return b.String(), nil
+}
+
+// =============================================================================
+
+// Reload parses the files of a loaded template again. The template in use
+// is kept when they don't parse.
+func (t *Template[T]) Reload() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("prompt: %w", err)
+	}
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("prompt: %w", err)
+	}
+	files := map[string]time.Time{t.path: info.ModTime()}
+
+	partials := maps.Clone(t.opts.partials)
+
+	matches, err := partialFiles(filepath.Dir(t.path))
+	if err != nil {
+		return fmt.Errorf("prompt: %w", err)
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(matches)) {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("prompt: %w", err)
+		}
+
+		name := strings.TrimPrefix(filepath.Base(path), "_")
+		partials[strings.TrimSuffix(name, filepath.Ext(name))] = string(text)
+		files[path] = matches[path].ModTime()
+	}
+
+	tmpl, err := t.parse(string(data), partials)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The files are recorded even when they don't parse so Watch waits for
+	// the next change before trying again.
+	t.files = files
+	if err != nil {
+		return err
+	}
+	t.tmpl = tmpl
+
+	return nil
+}
+
+// Watch reloads a loaded template whenever its files change, checking them
+// every interval until the context is canceled. Errors are reported to
+// onError, if not nil, and the last template that parsed stays in use.
+func (t *Template[T]) Watch(ctx context.Context, interval time.Duration, onError func(err error)) {
+	if t.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !t.changed() {
+			continue
+		}
+
+		if err := t.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// changed reports whether a file was modified, added or removed since the
+// last reload.
+func (t *Template[T]) changed() bool {
+	t.mu.RLock()
+	files := t.files
+	t.mu.RUnlock()
+
+	matches, _ := partialFiles(filepath.Dir(t.path))
+	if len(matches)+1 != len(files) {
+		return true
+	}
+
+	for path, modTime := range files {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// partialFiles returns the partials next to a template, the files of dir
+// whose name starts with an underscore.
+func partialFiles(dir string) (map[string]os.FileInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "_*"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]os.FileInfo, len(matches))
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files[path] = info
+	}
+
+	return files, nil
+}
+
+// parse parses the text with the partials and checks the fields it uses
+// exist in T.
+func (t *Template[T]) parse(text string, partials map[string]string) (*template.Template, error) {
+	tmpl := template.New(t.name).Funcs(t.opts.funcs).Option("missingkey=error")
+
+	for name, partial := range partials {
+		if _, err := tmpl.New(name).Parse(partial); err != nil {
+			return nil, fmt.Errorf("prompt %s: partial %s: %w", t.name, name, err)
+		}
+	}
+
+	if _, err := tmpl.Parse(text); err != nil {
+		return nil, fmt.Errorf("prompt %s: %w", t.name, err)
+	}
+
+	if err := check[T](tmpl, t.opts.funcs); err != nil {
+		return nil, fmt.Errorf("prompt %s: %w", t.name, err)
+	}
+
+	return tmpl, nil
+}
+
+// =============================================================================
+
+// formatExamples writes the examples as numbered input and output blocks.
+func formatExamples(examples []Example) string {
+	var b strings.Builder
+	for i, e := range examples {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Example %d:\nInput: %s\nOutput: %s\n", i+1, strings.TrimSpace(e.Input), strings.TrimSpace(e.Output))
+	}
+
+	return b.String()
+}
+
+// indent prefixes every line of the text with n spaces.
+func indent(n int, text string) string {
+	pad := strings.Repeat(" ", n)
+
+	var b strings.Builder
+	for line := range strings.Lines(text) {
+		b.WriteString(pad)
+		b.WriteString(line)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package prompt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type hit struct {
+	Source string
+	Text   string
+}
+
+type input struct {
+	Question string
+	Hits     []hit
+	Limits   map[string]int
+	Extra    any
+	Author   *author
+}
+
+type author struct {
+	Name string
+}
+
+func (a author) Initials() string {
+	return a.Name[:1]
+}
+
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"valid", `{{.Question}}{{range $i, $h := .Hits}}{{$i}} {{$h.Source}}{{end}}{{with .Author}}{{.Name}} {{.Initials}}{{end}}`, nil},
+		{"top level", `{{.Questoin}}`, []string{"field Questoin in type prompt.input"}},
+		{"if body", `{{if .Hits}}{{.Hitz}}{{end}}`, []string{"field Hitz in type prompt.input"}},
+		{"else body", `{{if .Hits}}{{else}}{{.Nope}}{{end}}`, []string{"field Nope in type prompt.input"}},
+		{"range body", `{{range .Hits}}{{.Sourse}}{{end}}`, []string{"field Sourse in type prompt.hit"}},
+		{"range variable", `{{range $h := .Hits}}{{$h.Txt}}{{end}}`, []string{"field Txt in type prompt.hit"}},
+		{"root variable", `{{range .Hits}}{{$.Questoin}}{{end}}`, []string{"field Questoin in type prompt.input"}},
+		{"with body", `{{with .Author}}{{.Nmae}}{{end}}`, []string{"field Nmae in type prompt.author"}},
+		{"pipeline argument", `{{printf "%s" .Questoin}}`, []string{"field Questoin in type prompt.input"}},
+		{"every error", `{{.A}}{{range .Hits}}{{.B}}{{end}}`, []string{"field A in type prompt.input", "field B in type prompt.hit"}},
+		{"map keys", `{{.Limits.anything}}`, nil},
+		{"any field", `{{.Extra.Whatever.Else}}`, nil},
+		{"field of a string", `{{.Question.Length}}`, []string{"field Length in type string"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New[input]("test", tt.text)
+
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("got %s, want no error", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got no error, want %v", tt.want)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("got %s, want it to mention %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPartials(t *testing.T) {
+	_, err := New[input]("test", `{{range .Hits}}{{template "hit" .}}{{end}}`,
+		WithPartial("hit", `[{{.Source}}] {{.Body}}`),
+	)
+	if err == nil || !strings.Contains(err.Error(), "field Body in type prompt.hit") {
+		t.Errorf("got %v, want the partial's unknown field", err)
+	}
+}
+
+func TestCheckDoesNotRunFuncs(t *testing.T) {
+	var calls int
+	tmpl, err := New[input]("test", `{{shout .Question}}`, WithFuncs(map[string]any{
+		"shout": func(s string) string {
+			calls++
+			return strings.ToUpper(s)
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 0 {
+		t.Fatalf("a func was called %d times when the template was parsed", calls)
+	}
+
+	text, err := tmpl.Execute(input{Question: "why"})
+	if err != nil || text != "WHY" || calls != 1 {
+		t.Errorf("got %q, %v after %d calls", text, err, calls)
+	}
+}
+
+func TestCheckFuncResult(t *testing.T) {
+	_, err := New[input]("test", `{{(author .Question).Nmae}}`, WithFuncs(map[string]any{
+		"author": func(name string) author { return author{Name: name} },
+	}))
+	if err == nil || !strings.Contains(err.Error(), "field Nmae in type prompt.author") {
+		t.Errorf("got %v, want the field checked against the func's result", err)
+	}
+}
+
+// =============================================================================
+
+func TestMaxTokens(t *testing.T) {
+	tmpl, err := New[input]("test", `{{.Question}}`, WithMaxTokens(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tmpl.Execute(input{Question: "short"}); err != nil {
+		t.Errorf("got %s for a short prompt", err)
+	}
+
+	if _, err := tmpl.Execute(input{Question: strings.Repeat("word ", 20)}); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+}
+
+func TestLoadPartials(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, text string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("answer.tmpl", `{{template "context" .}}Q: {{.Question}}`)
+	write("_context.tmpl", `{{range .Hits}}[{{.Source}}] {{end}}`)
+
+	tmpl, err := Load[input](filepath.Join(dir, "answer.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := tmpl.Execute(input{Question: "why", Hits: []hit{{Source: "a.md"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "[a.md] Q: why" {
+		t.Errorf("got %q", text)
+	}
+
+	// A broken partial fails the reload and keeps the template in use.
+	write("_context.tmpl", `{{range .Hits}}{{.Sorce}}{{end}}`)
+	if err := tmpl.Reload(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+
+	if text, _ := tmpl.Execute(input{Question: "why"}); text != "Q: why" {
+		t.Errorf("got %q from the template in use", text)
+	}
+}
+
+func TestChangedIgnoresDirectories(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "answer.tmpl"), []byte(`Q: {{.Question}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "_drafts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := Load[input](filepath.Join(dir, "answer.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The directory isn't a partial, Watch would reload every interval.
+	if tmpl.changed() {
+		t.Error("got changed, want the directory ignored")
+	}
+}
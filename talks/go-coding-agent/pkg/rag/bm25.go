@@ -0,0 +1,139 @@
+package rag
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Set of BM25 parameters: k1 sets how quickly repeating a term stops adding
+// to the score and b how much long chunks are penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25 is a keyword index over chunks. It finds the exact identifiers and
+// rare words an embedding blurs, like a function name in a code question.
+type BM25 struct {
+	docs   []bm25Doc
+	df     map[string]int
+	avgLen float64
+}
+
+type bm25Doc struct {
+	chunk  Chunk
+	terms  map[string]int
+	length int
+}
+
+// NewBM25 indexes the chunks.
+func NewBM25(chunks []Chunk) *BM25 {
+	x := BM25{
+		docs: make([]bm25Doc, len(chunks)),
+		df:   make(map[string]int),
+	}
+
+	var total int
+	for i, c := range chunks {
+		tokens := Tokenize(c.Text)
+
+		terms := make(map[string]int)
+		for _, t := range tokens {
+			terms[t]++
+		}
+		for t := range terms {
+			x.df[t]++
+		}
+
+		x.docs[i] = bm25Doc{chunk: c, terms: terms, length: len(tokens)}
+		total += len(tokens)
+	}
+
+	if len(chunks) > 0 {
+		x.avgLen = float64(total) / float64(len(chunks))
+	}
+
+	return &x
+}
+
+// Search returns the k chunks scoring highest for the query. Chunks sharing
+// no term with the query aren't returned.
+func (x *BM25) Search(query string, k int) []Hit {
+	terms := slices.Compact(slices.Sorted(slices.Values(Tokenize(query))))
+
+	n := float64(len(x.docs))
+
+	var hits []Hit
+	for _, d := range x.docs {
+		var score float64
+		for _, t := range terms {
+			tf := float64(d.terms[t])
+			if tf == 0 {
+				continue
+			}
+
+			df := float64(x.df[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - bm25B + bm25B*float64(d.length)/max(x.avgLen, 1)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+
+		if score > 0 {
+			hits = append(hits, Hit{Chunk: d.chunk, Score: score})
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b Hit) int { return cmpScore(a.Score, b.Score) })
+
+	return hits[:min(k, len(hits))]
+}
+
+// Tokenize splits the text into lowercase terms for the keyword index.
+// Identifiers are kept whole and also split into their words, so
+// ChatCompletionsSSE matches both itself and "chat completions".
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	var tokens []string
+	for _, w := range words {
+		tokens = append(tokens, strings.ToLower(w))
+
+		parts := splitIdentifier(w)
+		if len(parts) > 1 {
+			for _, p := range parts {
+				tokens = append(tokens, strings.ToLower(p))
+			}
+		}
+	}
+
+	return tokens
+}
+
+// splitIdentifier splits a word at underscores and case changes:
+// HTTPServer_test gives HTTP, Server and test.
+func splitIdentifier(word string) []string {
+	var parts []string
+	for _, w := range strings.Split(word, "_") {
+		rs := []rune(w)
+
+		start := 0
+		for i := 1; i < len(rs); i++ {
+			lowerToUpper := unicode.IsLower(rs[i-1]) && unicode.IsUpper(rs[i])
+			acronymEnd := unicode.IsUpper(rs[i-1]) && unicode.IsUpper(rs[i]) && i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if lowerToUpper || acronymEnd {
+				parts = append(parts, string(rs[start:i]))
+				start = i
+			}
+		}
+
+		if start < len(rs) {
+			parts = append(parts, string(rs[start:]))
+		}
+	}
+
+	return parts
+}
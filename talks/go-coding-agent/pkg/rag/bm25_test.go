@@ -0,0 +1,48 @@
+package rag
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, world!", []string{"hello", "world"}},
+		{"ChatCompletionsSSE", []string{"chatcompletionssse", "chat", "completions", "sse"}},
+		{"HTTPServer_test", []string{"httpserver_test", "http", "server", "test"}},
+		{"max_tokens=3", []string{"max_tokens", "max", "tokens", "3"}},
+	}
+
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBM25(t *testing.T) {
+	chunks := []Chunk{
+		{Source: "a", Text: "The client streams the chat completions."},
+		{Source: "b", Text: "func ChatCompletionsSSE(ctx context.Context) opens the stream."},
+		{Source: "c", Text: "Embeddings turn text into vectors."},
+	}
+
+	x := NewBM25(chunks)
+
+	hits := x.Search("ChatCompletionsSSE", 3)
+	if len(hits) == 0 || hits[0].Source != "b" {
+		t.Fatalf("got %+v, want the chunk with the identifier first", hits)
+	}
+
+	// The words of the identifier find the prose as well.
+	if len(hits) != 2 || hits[1].Source != "a" {
+		t.Errorf("got %+v, want the chunk about chat completions second", hits)
+	}
+
+	if hits := x.Search("kubernetes", 3); len(hits) != 0 {
+		t.Errorf("got %+v for a word no chunk has", hits)
+	}
+}
@@ -0,0 +1,76 @@
+package rag
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Set of chunking defaults, in characters. Chunks overlap so a sentence cut
+// at a boundary is still found whole in one of them.
+const (
+	DefaultChunkSize    = 1200
+	DefaultChunkOverlap = 200
+)
+
+// Split cuts the text into chunks of about size characters, breaking at
+// paragraphs, then lines, then sentences and words when it can. Consecutive
+// chunks share up to overlap characters.
+func Split(text string, size int, overlap int) []string {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	overlap = min(max(overlap, 0), size/2)
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	var chunks []string
+	for {
+		if utf8.RuneCountInString(text) <= size {
+			return append(chunks, text)
+		}
+
+		end := cut(text, size)
+		chunks = append(chunks, strings.TrimSpace(text[:end]))
+
+		// Start the next chunk overlap characters before the cut, at the
+		// start of a word.
+		start := end
+		for n := 0; n < overlap && start > 0; n++ {
+			_, w := utf8.DecodeLastRuneInString(text[:start])
+			start -= w
+		}
+		if i := strings.IndexAny(text[start:end], " \n"); i != -1 && overlap > 0 {
+			start += i + 1
+		} else {
+			start = end
+		}
+
+		text = strings.TrimSpace(text[start:])
+	}
+}
+
+// cut returns the byte offset to end the chunk at, the last good break in
+// the first size characters.
+func cut(text string, size int) int {
+	limit := len(text)
+	for i := range text {
+		if size == 0 {
+			limit = i
+			break
+		}
+		size--
+	}
+
+	window := text[:limit]
+	for _, sep := range []string{"\n\n", "\n", ". ", " "} {
+		// A break too early makes tiny chunks, only the second half counts.
+		if i := strings.LastIndex(window, sep); i > limit/2 {
+			return i + len(sep)
+		}
+	}
+
+	return limit
+}
@@ -0,0 +1,68 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitShort(t *testing.T) {
+	if got := Split("  one chunk  ", 100, 10); len(got) != 1 || got[0] != "one chunk" {
+		t.Errorf("got %q", got)
+	}
+
+	if got := Split(" \n ", 100, 10); got != nil {
+		t.Errorf("got %q for an empty text", got)
+	}
+}
+
+func TestSplitParagraphs(t *testing.T) {
+	text := strings.Repeat("a", 60) + "\n\n" + strings.Repeat("b", 60)
+
+	got := Split(text, 100, 0)
+	if len(got) != 2 || got[0] != strings.Repeat("a", 60) || got[1] != strings.Repeat("b", 60) {
+		t.Errorf("got %q, want the two paragraphs", got)
+	}
+}
+
+func TestSplitSizeAndOverlap(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 50)
+
+	chunks := Split(text, 120, 30)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks", len(chunks))
+	}
+
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > 120 {
+			t.Errorf("chunk %d has %d characters, over the size", i, n)
+		}
+	}
+
+	// Consecutive chunks share words across the cut.
+	for i := 1; i < len(chunks); i++ {
+		prev := strings.Fields(chunks[i-1])
+		if first := strings.Fields(chunks[i])[0]; !strings.Contains(strings.Join(prev[len(prev)-6:], " "), first) {
+			t.Errorf("chunk %d starts with %q, not found at the end of the chunk before", i, first)
+		}
+	}
+
+	// Every word of the text is kept.
+	joined := strings.Join(chunks, " ")
+	if !strings.HasSuffix(joined, "lazy dog.") || !strings.HasPrefix(joined, "The quick") {
+		t.Errorf("lost the ends of the text")
+	}
+}
+
+func TestSplitRunes(t *testing.T) {
+	text := strings.Repeat("日本語のテキスト", 40)
+
+	for _, c := range Split(text, 50, 10) {
+		if !utf8.ValidString(c) {
+			t.Fatalf("got a chunk cut inside a rune: %q", c)
+		}
+		if n := utf8.RuneCountInString(c); n > 50 {
+			t.Errorf("got %d characters, over the size", n)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/prompt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// rrfK dampens the weight of the top ranks in reciprocal rank fusion, 60 is
+// the value from the original paper and works well without tuning.
+const rrfK = 60
+
+// Reranker scores the candidates against the query again, with a model
+// better at it than the retrieval. It returns them sorted by the new score.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, hits []Hit) ([]Hit, error)
+}
+
+// =============================================================================
+
+// HybridRetriever retrieves the chunks by both keywords and embedding
+// similarity and fuses the two rankings, so a question finds the chunks
+// that match its meaning as well as the ones holding its exact words. The
+// fused candidates can then be reranked and diversified.
+type HybridRetriever struct {
+	store      *Store
+	embed      Embedder
+	candidates int
+	reranker   Reranker
+	lambda     float64
+
+	mu      sync.Mutex
+	index   *BM25
+	version int
+}
+
+// NewHybridRetriever constructs a retriever searching the store. The keyword
+// index is rebuilt when the store changes.
+func NewHybridRetriever(store *Store, embed Embedder, options ...func(r *HybridRetriever)) *HybridRetriever {
+	r := HybridRetriever{
+		store:      store,
+		embed:      embed,
+		candidates: 20,
+		version:    -1,
+	}
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	return &r
+}
+
+// WithCandidates sets the number of chunks taken from each ranking and
+// handed to the reranker, 20 by default and never fewer than k.
+func WithCandidates(n int) func(r *HybridRetriever) {
+	return func(r *HybridRetriever) {
+		r.candidates = n
+	}
+}
+
+// WithReranker reranks the fused candidates before the top k are kept.
+func WithReranker(reranker Reranker) func(r *HybridRetriever) {
+	return func(r *HybridRetriever) {
+		r.reranker = reranker
+	}
+}
+
+// WithMMR picks the k chunks by maximal marginal relevance, trading the
+// relevance of a chunk for how different it is from the chunks already
+// picked. Lambda is the weight of relevance, 1 ignoring diversity, 0.7 being
+// a good start. It's off by default.
+func WithMMR(lambda float64) func(r *HybridRetriever) {
+	return func(r *HybridRetriever) {
+		r.lambda = lambda
+	}
+}
+
+// Retrieve implements Retriever. The score of the hits is the fused score,
+// or the reranker's when there is one. With MMR the hits are in the order
+// they were picked, which isn't always by score.
+func (r *HybridRetriever) Retrieve(ctx context.Context, query string, k int) ([]Hit, error) {
+	n := max(r.candidates, k)
+
+	embedding, err := r.embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	hits := fuse(n, r.store.Search(embedding, n), r.keywords().Search(query, n))
+
+	if r.reranker != nil && len(hits) > 0 {
+		if hits, err = r.reranker.Rerank(ctx, query, hits); err != nil {
+			return nil, fmt.Errorf("rerank: %w", err)
+		}
+	}
+
+	if r.lambda > 0 {
+		return mmr(hits, k, r.lambda), nil
+	}
+
+	return hits[:min(k, len(hits))], nil
+}
+
+// keywords returns the keyword index, rebuilding it when the store changed
+// since it was built.
+func (r *HybridRetriever) keywords() *BM25 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chunks, version := r.store.snapshot()
+	if version != r.version {
+		r.index = NewBM25(chunks)
+		r.version = version
+	}
+
+	return r.index
+}
+
+// fuse merges the rankings by reciprocal rank fusion: a chunk scores the sum
+// of 1/(rrfK+rank) over the rankings it's in. Only the ranks count, so the
+// scores of different retrievers don't need to be comparable.
+func fuse(k int, rankings ...[]Hit) []Hit {
+	type key struct {
+		source string
+		index  int
+	}
+
+	var hits []Hit
+	pos := make(map[key]int)
+
+	for _, ranking := range rankings {
+		for rank, h := range ranking {
+			score := 1 / float64(rrfK+rank+1)
+
+			id := key{h.Source, h.Index}
+			if i, exists := pos[id]; exists {
+				hits[i].Score += score
+				continue
+			}
+
+			pos[id] = len(hits)
+			hits = append(hits, Hit{Chunk: h.Chunk, Score: score})
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b Hit) int { return cmpScore(a.Score, b.Score) })
+
+	return hits[:min(k, len(hits))]
+}
+
+// mmr picks k hits one at a time, each maximizing lambda times its relevance
+// minus 1-lambda times its similarity to the closest hit already picked. The
+// relevance is the score scaled to 0..1 so it weighs like the similarity.
+func mmr(hits []Hit, k int, lambda float64) []Hit {
+	if len(hits) == 0 {
+		return hits
+	}
+
+	lo, hi := hits[0].Score, hits[0].Score
+	for _, h := range hits {
+		lo = min(lo, h.Score)
+		hi = max(hi, h.Score)
+	}
+
+	relevance := func(h Hit) float64 {
+		if hi == lo {
+			return 1
+		}
+		return (h.Score - lo) / (hi - lo)
+	}
+
+	left := slices.Clone(hits)
+	picked := make([]Hit, 0, min(k, len(hits)))
+
+	for len(picked) < k && len(left) > 0 {
+		best, bestScore := 0, 0.0
+		for i, h := range left {
+			var similarity float64
+			for _, p := range picked {
+				similarity = max(similarity, dot(h.Embedding, p.Embedding))
+			}
+
+			score := lambda*relevance(h) - (1-lambda)*similarity
+			if i == 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		picked = append(picked, left[best])
+		left = slices.Delete(left, best, best+1)
+	}
+
+	return picked
+}
+
+// =============================================================================
+
+// rerankPrompt asks the model to grade every passage against the query.
+const rerankPrompt = `Grade how well each passage below answers the query, from 0 for
+unrelated to 10 for answering it fully. Grade every passage.
+
+Reply with JSON only, like {"scores": [{"n": 1, "score": 7}]}.
+
+Query: {{.Query}}
+
+{{range $i, $h := .Hits}}[{{inc $i}}]
+{{clip $h.Text}}
+
+{{end}}`
+
+type rerankInput struct {
+	Query string
+	Hits  []Hit
+}
+
+// LLMReranker reranks the candidates by asking a chat model to grade them,
+// all in one request.
+type LLMReranker struct {
+	chat   Chatter
+	prompt *prompt.Template[rerankInput]
+}
+
+// NewLLMReranker constructs a reranker grading with the chat model.
+func NewLLMReranker(chat Chatter) (*LLMReranker, error) {
+	tmpl, err := prompt.New[rerankInput]("rerank", rerankPrompt, prompt.WithFuncs(map[string]any{
+		"inc": func(i int) int { return i + 1 },
+		"clip": func(text string) string {
+			rs := []rune(strings.TrimSpace(text))
+			if len(rs) > 1500 {
+				return string(rs[:1500]) + "..."
+			}
+			return string(rs)
+		},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	r := LLMReranker{
+		chat:   chat,
+		prompt: tmpl,
+	}
+
+	return &r, nil
+}
+
+// Rerank implements Reranker. The score of a hit is its grade scaled to
+// 0..1, the passages the model didn't grade scoring 0 and keeping their
+// order.
+func (r *LLMReranker) Rerank(ctx context.Context, query string, hits []Hit) ([]Hit, error) {
+	text, err := r.prompt.Execute(rerankInput{Query: query, Hits: hits})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.chat.ChatCompletions(ctx, text,
+		client.WithParams(0, 1, 40),
+		client.WithResponseFormat(client.D{"type": "json_object"}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := result.Content
+
+	// Models wrap the JSON in prose or code fences despite the format.
+	if i, j := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); i != -1 && j > i {
+		answer = answer[i : j+1]
+	}
+
+	var grades struct {
+		Scores []struct {
+			N     int     `json:"n"`
+			Score float64 `json:"score"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(answer), &grades); err != nil {
+		return nil, fmt.Errorf("decode grades: %w", err)
+	}
+
+	out := slices.Clone(hits)
+	for i := range out {
+		out[i].Score = 0
+	}
+
+	for _, g := range grades.Scores {
+		if g.N >= 1 && g.N <= len(out) {
+			out[g.N-1].Score = min(max(g.Score, 0), 10) / 10
+		}
+	}
+
+	slices.SortStableFunc(out, func(a, b Hit) int { return cmpScore(a.Score, b.Score) })
+
+	return out, nil
+}
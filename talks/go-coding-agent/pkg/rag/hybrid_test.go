@@ -0,0 +1,125 @@
+package rag
+
+import (
+	"context"
+	"go-coding-agent/pkg/client"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFuse(t *testing.T) {
+	hit := func(source string) Hit { return Hit{Chunk: Chunk{Source: source}} }
+
+	vector := []Hit{hit("a"), hit("b"), hit("c")}
+	keyword := []Hit{hit("c"), hit("d"), hit("b")}
+
+	fused := fuse(10, vector, keyword)
+
+	var got []string
+	for _, h := range fused {
+		got = append(got, h.Source)
+	}
+
+	// b and c are in both rankings, c ranking higher overall by a hair
+	// over b: 1/61+1/63 against 1/62+1/63.
+	want := []string{"c", "b", "a", "d"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if want := 1.0/61 + 1.0/63; fused[0].Score != want {
+		t.Errorf("got score %f, want %f", fused[0].Score, want)
+	}
+
+	if got := fuse(2, vector, keyword); len(got) != 2 {
+		t.Errorf("got %d hits, want the top 2", len(got))
+	}
+}
+
+func TestMMR(t *testing.T) {
+	hits := []Hit{
+		{Chunk: Chunk{Source: "a", Embedding: []float64{1, 0}}, Score: 1},
+		{Chunk: Chunk{Source: "a copy", Embedding: []float64{1, 0}}, Score: 0.95},
+		{Chunk: Chunk{Source: "b", Embedding: []float64{0, 1}}, Score: 0.9},
+	}
+
+	picked := mmr(hits, 2, 0.5)
+	if len(picked) != 2 || picked[0].Source != "a" || picked[1].Source != "b" {
+		t.Errorf("got %+v, want the different chunk over the copy", picked)
+	}
+
+	// With all the weight on relevance the order is the score's.
+	picked = mmr(hits, 2, 1)
+	if picked[1].Source != "a copy" {
+		t.Errorf("got %+v, want the copy second without diversity", picked)
+	}
+}
+
+// grader grades the passages from the prompt, the ones mentioning the word
+// highest.
+type grader struct {
+	chat
+	word string
+}
+
+func (g *grader) ChatCompletions(ctx context.Context, text string, options ...client.Option) (client.ChatResult, error) {
+	var scores []string
+	for i, part := range strings.Split(text, "\n[")[1:] {
+		score := "1"
+		if strings.Contains(part, g.word) {
+			score = "9"
+		}
+		scores = append(scores, `{"n": `+string(rune('1'+i))+`, "score": `+score+`}`)
+	}
+
+	answer := "Here you go:\n```json\n{\"scores\": [" + strings.Join(scores, ", ") + "]}\n```"
+
+	return client.ChatResult{Content: answer}, nil
+}
+
+func TestHybridRerank(t *testing.T) {
+	store, _ := NewStore("")
+
+	svc, err := NewService(store, embed, &chat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for source, text := range map[string]string{
+		"a.md": "The agent streams answers from the model.",
+		"b.md": "The agent stores embeddings in a file.",
+		"c.md": "Unrelated text about cooking pasta.",
+	} {
+		if _, err := svc.Ingest(ctx, source, text, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reranker, err := NewLLMReranker(&grader{word: "embeddings"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewHybridRetriever(store, embed, WithReranker(reranker))
+
+	hits, err := r.Retrieve(ctx, "how does the agent work", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hits) != 2 || hits[0].Source != "b.md" || hits[0].Score != 0.9 {
+		t.Errorf("got %+v, want the passage the grader liked first", hits)
+	}
+
+	// A new document is found by keywords once added.
+	if _, err := svc.Ingest(ctx, "d.md", "ChatCompletionsSSE opens the stream.", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	hits, err = NewHybridRetriever(store, embed).Retrieve(ctx, "ChatCompletionsSSE", 1)
+	if err != nil || hits[0].Source != "d.md" {
+		t.Errorf("got %+v, %v, want the new document", hits, err)
+	}
+}
@@ -0,0 +1,404 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-coding-agent/pkg/client"
+	"go-coding-agent/pkg/prompt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// answerPrompt is the template of the question sent to the model with the
+// retrieved chunks.
+const answerPrompt = `Answer the question using only the sources below. Cite the sources you use
+with their number in brackets, like [1]. When the sources don't hold the
+answer, say that you don't know.
+
+{{range $i, $h := .Hits}}[{{inc $i}}] {{$h.Source}}
+{{trim $h.Text}}
+
+{{end}}Question: {{.Question}}`
+
+type answerInput struct {
+	Question string
+	Hits     []Hit
+}
+
+// Citation is a chunk the answer was based on, numbered as in the answer.
+type Citation struct {
+	N      int     `json:"n"`
+	Source string  `json:"source"`
+	Index  int     `json:"index"`
+	Score  float64 `json:"score"`
+	Text   string  `json:"text"`
+}
+
+// Chatter sends prompts to a chat model, client.LLM and client.LLMGroup do.
+type Chatter interface {
+	ChatCompletions(ctx context.Context, text string, options ...client.Option) (client.ChatResult, error)
+	ChatCompletionsSSE(ctx context.Context, content string, options ...client.Option) (chan client.ChatSSE, error)
+}
+
+// =============================================================================
+
+// Service answers questions from the documents ingested into its store.
+type Service struct {
+	store     *Store
+	retriever Retriever
+	embed     Embedder
+	chat      Chatter
+	prompt    *prompt.Template[answerInput]
+	topK      int
+	chunkSize int
+	overlap   int
+	parallel  int
+	maxBody   int64
+}
+
+// NewService constructs a service embedding the documents with embed and
+// answering with the chat model. The chunks are retrieved by embedding
+// similarity unless WithRetriever sets another retriever.
+func NewService(store *Store, embed Embedder, chat Chatter, options ...func(s *Service)) (*Service, error) {
+	tmpl, err := prompt.New[answerInput]("answer", answerPrompt, prompt.WithFuncs(map[string]any{
+		"inc": func(i int) int { return i + 1 },
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	s := Service{
+		store:     store,
+		embed:     embed,
+		chat:      chat,
+		prompt:    tmpl,
+		topK:      4,
+		chunkSize: DefaultChunkSize,
+		overlap:   DefaultChunkOverlap,
+		parallel:  4,
+		maxBody:   10 << 20,
+	}
+
+	for _, option := range options {
+		option(&s)
+	}
+
+	if s.retriever == nil {
+		s.retriever = NewVectorRetriever(store, embed)
+	}
+
+	return &s, nil
+}
+
+// WithRetriever sets how the chunks are retrieved.
+func WithRetriever(r Retriever) func(s *Service) {
+	return func(s *Service) {
+		s.retriever = r
+	}
+}
+
+// WithTopK sets the number of chunks retrieved when the query doesn't say,
+// 4 by default.
+func WithTopK(k int) func(s *Service) {
+	return func(s *Service) {
+		s.topK = k
+	}
+}
+
+// WithChunking sets the size and overlap of the chunks, in characters.
+func WithChunking(size int, overlap int) func(s *Service) {
+	return func(s *Service) {
+		s.chunkSize = size
+		s.overlap = overlap
+	}
+}
+
+// WithParallelEmbeddings sets the number of chunks of a document embedded
+// at the same time, 4 by default.
+func WithParallelEmbeddings(n int) func(s *Service) {
+	return func(s *Service) {
+		s.parallel = max(n, 1)
+	}
+}
+
+// WithMaxBodySize sets the size limit of a request body in bytes, 10MB by
+// default, a larger document being rejected with 413.
+func WithMaxBodySize(n int64) func(s *Service) {
+	return func(s *Service) {
+		s.maxBody = n
+	}
+}
+
+// Ingest splits the text into chunks, embeds them and stores them under the
+// source, replacing what the source had. It returns the number of chunks.
+func (s *Service) Ingest(ctx context.Context, source string, text string, metadata map[string]string) (int, error) {
+	parts := Split(text, s.chunkSize, s.overlap)
+	if len(parts) == 0 {
+		return 0, errors.New("document is empty")
+	}
+
+	embeddings, err := s.embedAll(ctx, parts)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	chunks := make([]Chunk, len(parts))
+	for i, part := range parts {
+		chunks[i] = Chunk{
+			Index:     i,
+			Text:      part,
+			Metadata:  metadata,
+			Embedding: embeddings[i],
+			Added:     now,
+		}
+	}
+
+	if err := s.store.Put(source, chunks); err != nil {
+		return 0, err
+	}
+
+	return len(chunks), nil
+}
+
+// embedAll embeds the texts, up to s.parallel at a time. The first error
+// cancels the rest.
+func (s *Service) embedAll(ctx context.Context, texts []string) ([][]float64, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	embeddings := make([][]float64, len(texts))
+	sem := make(chan struct{}, s.parallel)
+
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Go(func() {
+			defer func() { <-sem }()
+
+			embedding, err := s.embed(ctx, text)
+			if err != nil {
+				cancel(fmt.Errorf("embed chunk %d: %w", i, err))
+				return
+			}
+			embeddings[i] = embedding
+		})
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	return embeddings, nil
+}
+
+// Prepare retrieves the chunks for the question and builds the prompt.
+func (s *Service) Prepare(ctx context.Context, question string, k int) (string, []Citation, error) {
+	if k <= 0 {
+		k = s.topK
+	}
+
+	hits, err := s.retriever.Retrieve(ctx, question, k)
+	if err != nil {
+		return "", nil, fmt.Errorf("retrieve: %w", err)
+	}
+
+	text, err := s.prompt.Execute(answerInput{Question: question, Hits: hits})
+	if err != nil {
+		return "", nil, err
+	}
+
+	citations := make([]Citation, len(hits))
+	for i, h := range hits {
+		citations[i] = Citation{N: i + 1, Source: h.Source, Index: h.Index, Score: h.Score, Text: h.Text}
+	}
+
+	return text, citations, nil
+}
+
+// =============================================================================
+
+// Handler returns the HTTP API of the service:
+//
+//	POST   /v1/documents          ingest {"source", "text", "metadata"}
+//	GET    /v1/documents          list the sources
+//	DELETE /v1/documents/{source} delete a source
+//	POST   /v1/query              answer {"question", "top_k", "stream"}
+//
+// A query streams the answer as server-sent events when stream is set or the
+// client accepts text/event-stream.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/documents", s.handleIngest)
+	mux.HandleFunc("GET /v1/documents", s.handleList)
+	mux.HandleFunc("DELETE /v1/documents/{source...}", s.handleDelete)
+	mux.HandleFunc("POST /v1/query", s.handleQuery)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type ingestRequest struct {
+	Source   string            `json:"source"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (s *Service) handleIngest(w http.ResponseWriter, r *http.Request) {
+	var req ingestRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, errors.New("source is required"))
+		return
+	}
+
+	n, err := s.Ingest(r.Context(), req.Source, req.Text, req.Metadata)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, client.D{"source": req.Source, "chunks": n})
+}
+
+func (s *Service) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, client.D{"sources": s.store.Sources()})
+}
+
+func (s *Service) handleDelete(w http.ResponseWriter, r *http.Request) {
+	source := r.PathValue("source")
+
+	n, err := s.store.Delete(source)
+	switch {
+	case errors.Is(err, ErrNoSource):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, client.D{"source": source, "deleted": n})
+}
+
+type queryRequest struct {
+	Question string `json:"question"`
+	TopK     int    `json:"top_k"`
+	Stream   bool   `json:"stream"`
+}
+
+func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Question) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("question is required"))
+		return
+	}
+
+	ctx := r.Context()
+
+	text, citations, err := s.Prepare(ctx, req.Question, req.TopK)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Stream || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.stream(w, r, text, citations)
+		return
+	}
+
+	result, err := s.chat.ChatCompletions(ctx, text)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("chat: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, client.D{"answer": result.Content, "citations": citations})
+}
+
+// stream sends the citations first, then the tokens of the answer as they
+// come, then done. An error after the stream started is sent as an event.
+func (s *Service) stream(w http.ResponseWriter, r *http.Request, text string, citations []Citation) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	ch, err := s.chat.ChatCompletionsSSE(r.Context(), text)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("chat: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data any) {
+		b, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		flusher.Flush()
+	}
+
+	send("citations", client.D{"citations": citations})
+
+	for token, err := range client.ContentTokens(ch) {
+		if err != nil {
+			send("error", client.D{"error": err.Error()})
+			return
+		}
+		send("token", client.D{"text": token})
+	}
+
+	send("done", client.D{})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decode reads the JSON body of the request, writing the error response
+// when it can't.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("body is over the limit of %d bytes", tooLarge.Limit))
+		return false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode: %w", err))
+		return false
+	}
+
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, client.D{"error": err.Error()})
+}
@@ -0,0 +1,210 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-coding-agent/pkg/client"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// embed is a bag of words embedder, texts sharing words are close.
+func embed(ctx context.Context, text string) ([]float64, error) {
+	v := make([]float64, 64)
+	for _, w := range Tokenize(text) {
+		h := fnv.New32a()
+		h.Write([]byte(w))
+		v[h.Sum32()%64]++
+	}
+
+	return v, nil
+}
+
+// chat answers every prompt with the same text and keeps the last prompt.
+type chat struct {
+	mu     sync.Mutex
+	prompt string
+}
+
+func (c *chat) ChatCompletions(ctx context.Context, text string, options ...client.Option) (client.ChatResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prompt = text
+
+	return client.ChatResult{Content: "Paris [1]", FinishReason: "stop"}, nil
+}
+
+func (c *chat) ChatCompletionsSSE(ctx context.Context, content string, options ...client.Option) (chan client.ChatSSE, error) {
+	ch := make(chan client.ChatSSE, 2)
+	for _, token := range []string{"Paris ", "[1]"} {
+		ch <- client.ChatSSE{Choices: []client.ChatChoiceSSE{{Delta: client.ChatDeltaSSE{Content: token}}}}
+	}
+	close(ch)
+
+	return ch, nil
+}
+
+func newService(t *testing.T, embed Embedder, options ...func(s *Service)) (*Service, *chat, *httptest.Server) {
+	t.Helper()
+
+	store, _ := NewStore("")
+	c := chat{}
+
+	svc, err := NewService(store, embed, &c, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(svc.Handler())
+	t.Cleanup(srv.Close)
+
+	return svc, &c, srv
+}
+
+func post(t *testing.T, url string, body string) (*http.Response, client.D) {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var d client.D
+	json.NewDecoder(resp.Body).Decode(&d)
+
+	return resp, d
+}
+
+func TestServiceQuery(t *testing.T) {
+	_, c, srv := newService(t, embed)
+
+	post(t, srv.URL+"/v1/documents", `{"source": "fr.txt", "text": "Paris is the capital of France."}`)
+	post(t, srv.URL+"/v1/documents", `{"source": "de.txt", "text": "Berlin is the capital of Germany."}`)
+
+	resp, d := post(t, srv.URL+"/v1/query", `{"question": "What is the capital of France?", "top_k": 1}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %v", resp.StatusCode, d)
+	}
+
+	citations, _ := d["citations"].([]any)
+	if d["answer"] != "Paris [1]" || len(citations) != 1 {
+		t.Fatalf("got %v", d)
+	}
+
+	if source := citations[0].(map[string]any)["source"]; source != "fr.txt" {
+		t.Errorf("cited %v, want fr.txt", source)
+	}
+
+	if !strings.Contains(c.prompt, "[1] fr.txt\nParis is the capital of France.") {
+		t.Errorf("the prompt is missing the source:\n%s", c.prompt)
+	}
+}
+
+func TestServiceStream(t *testing.T) {
+	_, _, srv := newService(t, embed)
+
+	post(t, srv.URL+"/v1/documents", `{"source": "fr.txt", "text": "Paris is the capital of France."}`)
+
+	resp, err := http.Post(srv.URL+"/v1/query", "application/json", strings.NewReader(`{"question": "Capital of France?", "stream": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := string(data)
+	for _, want := range []string{"event: citations", `data: {"text":"Paris "}`, "event: done"} {
+		if !strings.Contains(events, want) {
+			t.Errorf("missing %q in\n%s", want, events)
+		}
+	}
+}
+
+func TestServiceBodyLimit(t *testing.T) {
+	_, _, srv := newService(t, embed, WithMaxBodySize(100))
+
+	resp, d := post(t, srv.URL+"/v1/documents", `{"source": "big.txt", "text": "`+strings.Repeat("word ", 100)+`"}`)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d: %v, want 413", resp.StatusCode, d)
+	}
+}
+
+func TestIngestParallel(t *testing.T) {
+	var running, most atomic.Int64
+	slow := func(ctx context.Context, text string) ([]float64, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		return embed(ctx, text)
+	}
+
+	svc, _, _ := newService(t, slow, WithChunking(50, 0), WithParallelEmbeddings(3))
+
+	n, err := svc.Ingest(context.Background(), "long.txt", strings.Repeat("Some words to embed here. ", 40), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 6 {
+		t.Fatalf("got %d chunks, want enough to run in parallel", n)
+	}
+
+	if m := most.Load(); m != 3 {
+		t.Errorf("got at most %d embeddings at once, want 3", m)
+	}
+
+	// The chunks keep their order.
+	for i, c := range svc.store.Chunks() {
+		if c.Index != i || len(c.Embedding) == 0 {
+			t.Fatalf("chunk %d: got index %d with %d dimensions", i, c.Index, len(c.Embedding))
+		}
+	}
+}
+
+func TestIngestEmbedError(t *testing.T) {
+	var calls atomic.Int64
+	failing := func(ctx context.Context, text string) ([]float64, error) {
+		if calls.Add(1) == 2 {
+			return nil, errors.New("model crashed")
+		}
+		return embed(ctx, text)
+	}
+
+	svc, _, _ := newService(t, failing, WithChunking(50, 0), WithParallelEmbeddings(1))
+
+	if _, err := svc.Ingest(context.Background(), "long.txt", strings.Repeat("Some words to embed here. ", 40), nil); err == nil || !strings.Contains(err.Error(), "model crashed") {
+		t.Fatalf("got %v, want the embedding error", err)
+	}
+
+	if len(svc.store.Chunks()) != 0 {
+		t.Error("a document failing to embed was stored")
+	}
+
+	// The other chunks weren't embedded after the failure.
+	if n := calls.Load(); n > 3 {
+		t.Errorf("got %d embeddings after the error", n)
+	}
+}
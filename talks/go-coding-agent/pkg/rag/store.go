@@ -0,0 +1,319 @@
+// Package rag provides support for answering questions from a set of
+// documents: they're split into chunks, embedded and stored, and the chunks
+// closest to a question are handed to the model with it.
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSource is returned when deleting a source the store doesn't hold.
+var ErrNoSource = errors.New("source not found")
+
+// Chunk is a piece of a document, the unit that is embedded and retrieved.
+type Chunk struct {
+	Source    string            `json:"source"`
+	Index     int               `json:"index"`
+	Text      string            `json:"text"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Embedding []float64         `json:"embedding"`
+	Added     time.Time         `json:"added"`
+}
+
+// Hit is a chunk retrieved for a query with its score, higher being more
+// relevant.
+type Hit struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+// Retriever finds the k chunks most relevant to the query.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, k int) ([]Hit, error)
+}
+
+// Source describes a document held in the store.
+type Source struct {
+	Source string    `json:"source"`
+	Chunks int       `json:"chunks"`
+	Added  time.Time `json:"added"`
+}
+
+// =============================================================================
+
+// Store keeps the chunks in memory and persists each source to a file of
+// its own in a directory, so a change only writes the source it's about.
+type Store struct {
+	dir string
+
+	mu     sync.RWMutex
+	chunks []Chunk
+
+	// version counts the changes, for the indexes built from the chunks to
+	// know when they're stale.
+	version int
+}
+
+// storeFile is what a source's file holds.
+type storeFile struct {
+	Source string  `json:"source"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// NewStore constructs a store persisted in the directory, loading the
+// sources already there. An empty dir keeps the chunks in memory.
+func NewStore(dir string) (*Store, error) {
+	s := Store{
+		dir: dir,
+	}
+
+	if dir == "" {
+		return &s, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("read store: %w", err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read store: %w", err)
+		}
+
+		var f storeFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("decode store %s: %w", path, err)
+		}
+
+		s.chunks = append(s.chunks, f.Chunks...)
+	}
+
+	return &s, nil
+}
+
+// Put replaces the chunks of the source with the chunks given.
+func (s *Store) Put(source string, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chunks = slices.DeleteFunc(s.chunks, func(c Chunk) bool { return c.Source == source })
+	for _, c := range chunks {
+		c.Source = source
+		c.Embedding = normalize(c.Embedding)
+		s.chunks = append(s.chunks, c)
+	}
+	s.version++
+
+	return s.save(source)
+}
+
+// Delete removes the chunks of the source, returning how many there were.
+func (s *Store) Delete(source string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.chunks)
+	s.chunks = slices.DeleteFunc(s.chunks, func(c Chunk) bool { return c.Source == source })
+
+	deleted := n - len(s.chunks)
+	if deleted == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrNoSource, source)
+	}
+	s.version++
+
+	if s.dir == "" {
+		return deleted, nil
+	}
+
+	if err := os.Remove(s.path(source)); err != nil && !os.IsNotExist(err) {
+		return deleted, fmt.Errorf("save store: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// Sources lists the documents in the store, sorted by name.
+func (s *Store) Sources() []Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bySource := make(map[string]*Source)
+	for _, c := range s.chunks {
+		src, exists := bySource[c.Source]
+		if !exists {
+			src = &Source{Source: c.Source, Added: c.Added}
+			bySource[c.Source] = src
+		}
+		src.Chunks++
+	}
+
+	sources := make([]Source, 0, len(bySource))
+	for _, src := range bySource {
+		sources = append(sources, *src)
+	}
+	slices.SortFunc(sources, func(a, b Source) int { return strings.Compare(a.Source, b.Source) })
+
+	return sources
+}
+
+// Chunks returns every chunk in the store.
+func (s *Store) Chunks() []Chunk {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.chunks)
+}
+
+// snapshot returns the chunks with the version of the store they're from.
+func (s *Store) snapshot() ([]Chunk, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.chunks), s.version
+}
+
+// Search returns the k chunks with the embeddings closest to the embedding,
+// by cosine similarity.
+func (s *Store) Search(embedding []float64, k int) []Hit {
+	embedding = normalize(embedding)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits := make([]Hit, 0, len(s.chunks))
+	for _, c := range s.chunks {
+		hits = append(hits, Hit{Chunk: c, Score: dot(embedding, c.Embedding)})
+	}
+
+	slices.SortStableFunc(hits, func(a, b Hit) int { return cmpScore(a.Score, b.Score) })
+
+	return hits[:min(k, len(hits))]
+}
+
+// save writes the file of the source. The caller holds the lock.
+func (s *Store) save(source string) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	f := storeFile{Source: source}
+	for _, c := range s.chunks {
+		if c.Source == source {
+			f.Chunks = append(f.Chunks, c)
+		}
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("encode store: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("save store: %w", err)
+	}
+
+	// Write aside and rename so a crash never leaves half a source.
+	path := s.path(source)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("save store: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("save store: %w", err)
+	}
+
+	return nil
+}
+
+// path returns the file of the source, named by a hash of it since a source
+// can be any string, like a path with slashes.
+func (s *Store) path(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// =============================================================================
+
+// Embedder turns a text into an embedding, client.LLM.EmbedText does.
+type Embedder func(ctx context.Context, text string) ([]float64, error)
+
+// VectorRetriever retrieves the chunks by embedding similarity.
+type VectorRetriever struct {
+	store *Store
+	embed Embedder
+}
+
+// NewVectorRetriever constructs a retriever searching the store.
+func NewVectorRetriever(store *Store, embed Embedder) *VectorRetriever {
+	return &VectorRetriever{
+		store: store,
+		embed: embed,
+	}
+}
+
+// Retrieve implements Retriever.
+func (r *VectorRetriever) Retrieve(ctx context.Context, query string, k int) ([]Hit, error) {
+	embedding, err := r.embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	return r.store.Search(embedding, k), nil
+}
+
+// =============================================================================
+
+func normalize(v []float64) []float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+
+	if sum == 0 {
+		return v
+	}
+
+	norm := math.Sqrt(sum)
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+
+	return out
+}
+
+// dot is the cosine similarity of two normalized vectors.
+func dot(a []float64, b []float64) float64 {
+	var sum float64
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+// cmpScore sorts by descending score.
+func cmpScore(a float64, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
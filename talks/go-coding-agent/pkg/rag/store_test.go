@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePersistsSources(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put("a.md", []Chunk{{Text: "one", Embedding: []float64{1, 0}}, {Index: 1, Text: "two", Embedding: []float64{0, 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("docs/b.md", []Chunk{{Text: "three", Embedding: []float64{3, 4}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every source has a file of its own.
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("got files %v, want one per source", files)
+	}
+
+	before, err := os.Stat(s.path("docs/b.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing a source only rewrites its file.
+	if err := s.Put("a.md", []Chunk{{Text: "new", Embedding: []float64{1, 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := os.Stat(s.path("docs/b.md"))
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("the file of another source was written")
+	}
+
+	s, err = NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := s.Sources()
+	if len(sources) != 2 || sources[0].Source != "a.md" || sources[0].Chunks != 1 || sources[1].Chunks != 1 {
+		t.Fatalf("got sources %+v after a reload", sources)
+	}
+
+	// The embeddings are stored normalized.
+	if hits := s.Search([]float64{3, 4}, 1); hits[0].Text != "three" || hits[0].Embedding[0] != 0.6 {
+		t.Errorf("got hit %+v", hits[0])
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	s, _ := NewStore(dir)
+	if err := s.Put("a.md", []Chunk{{Text: "one"}, {Index: 1, Text: "two"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Delete("a.md")
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	if _, err := os.Stat(s.path("a.md")); !os.IsNotExist(err) {
+		t.Errorf("the file of the source is still there: %v", err)
+	}
+
+	if _, err := s.Delete("a.md"); !errors.Is(err, ErrNoSource) {
+		t.Errorf("got %v, want ErrNoSource", err)
+	}
+}
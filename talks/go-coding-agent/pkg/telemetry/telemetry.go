@@ -0,0 +1,137 @@
+// Package telemetry provides support for exporting OpenTelemetry traces and
+// metrics for the LLM client and the agent's tool calls.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Set of exporters supported by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config represents the telemetry configuration.
+type Config struct {
+	ServiceName string
+
+	// Exporter is one of none, stdout or otlp. The otlp exporter uses
+	// http/protobuf and honors the standard OTEL_EXPORTER_OTLP_* environment
+	// variables, defaulting to a collector on localhost:4318.
+	Exporter string
+
+	// MetricInterval is how often metrics are exported. Defaults to 15s.
+	MetricInterval time.Duration
+}
+
+// Setup installs the global tracer and meter providers. The returned function
+// flushes and shuts down the providers and must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	if cfg.Exporter == "" || cfg.Exporter == ExporterNone {
+		return noop, nil
+	}
+
+	if cfg.MetricInterval == 0 {
+		cfg.MetricInterval = 15 * time.Second
+	}
+
+	var spanExp sdktrace.SpanExporter
+	var metricExp sdkmetric.Exporter
+	var err error
+
+	switch cfg.Exporter {
+	case ExporterStdout:
+		if spanExp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint()); err != nil {
+			return noop, fmt.Errorf("stdout trace exporter: %w", err)
+		}
+		if metricExp, err = stdoutmetric.New(stdoutmetric.WithWriter(os.Stderr), stdoutmetric.WithPrettyPrint()); err != nil {
+			return noop, fmt.Errorf("stdout metric exporter: %w", err)
+		}
+
+	case ExporterOTLP:
+		if spanExp, err = otlptracehttp.New(ctx); err != nil {
+			return noop, fmt.Errorf("otlp trace exporter: %w", err)
+		}
+		if metricExp, err = otlpmetrichttp.New(ctx); err != nil {
+			return noop, fmt.Errorf("otlp metric exporter: %w", err)
+		}
+
+	default:
+		return noop, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+	))
+	if err != nil {
+		return noop, fmt.Errorf("resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExp),
+		sdktrace.WithResource(res),
+	)
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExp, sdkmetric.WithInterval(cfg.MetricInterval))),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}
+
+	return shutdown, nil
+}
+
+// =============================================================================
+
+// StartTool starts a span for the execution of a tool the model asked for.
+// Call the returned function with the tool's error, if any, when it's done.
+func StartTool(ctx context.Context, name string, callID string) (context.Context, func(error)) {
+	tracer := otel.Tracer("go-coding-agent/pkg/telemetry")
+
+	ctx, span := tracer.Start(ctx, "execute_tool "+name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("gen_ai.operation.name", "execute_tool"),
+			attribute.String("gen_ai.tool.name", name),
+			attribute.String("gen_ai.tool.call.id", callID),
+			attribute.String("gen_ai.tool.type", "function"),
+		))
+
+	end := func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	return ctx, end
+}